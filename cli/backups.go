@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+	"github.com/astaxie/beego"
+)
+
+// backupsCommand lists the online backups of the registry database or
+// restores one of them, the registry must not be running while restoring
+func backupsCommand(args []string) int {
+	flags := flag.NewFlagSet("backups", flag.ContinueOnError)
+	dir := flags.String("dir", beego.AppConfig.String("backupdir"), "backup directory")
+	target := flags.String("datasource", models.DataSource, "sqlite database to restore")
+	usage := "usage: backups [-dir dir] list | backups [-dir dir] [-datasource file] restore <backup>"
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 || *dir == "" {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	switch {
+	case flags.Arg(0) == "list" && flags.NArg() == 1:
+		backups, err := models.ListBackups(*dir)
+		if err != nil {
+			return report(err)
+		}
+		for _, backup := range backups {
+			fmt.Printf("%s\t%d\t%s\n", backup.Name, backup.Size, backup.SHA256)
+		}
+		return 0
+	case flags.Arg(0) == "restore" && flags.NArg() == 2:
+		backup, err := models.RestoreBackup(*dir, flags.Arg(1), *target)
+		if err != nil {
+			return report(err)
+		}
+		fmt.Fprintf(os.Stderr, "restored %s, taken %s, into %s\n", backup.Name, backup.CreatedAt.Format("2006-01-02 15:04:05 MST"), *target)
+		return 0
+	}
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
@@ -0,0 +1,148 @@
+// Package cli implements the client side modes of the registry binary,
+// they talk to a running registry through its HTTP API, except export,
+// import, backups and users which work on its database directly.
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// RegistryURLEnv names the environment variable holding the registry base
+// URL, http://localhost:38080 is used when it is not set
+const RegistryURLEnv = "SINKER_REGISTRY_URL"
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// do sends req to the registry with the token of $SINKER_TOKEN, if any
+func do(req *http.Request) (*http.Response, error) {
+	if token := os.Getenv(TokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return httpClient.Do(req)
+}
+
+func get(path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", registryURL()+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return do(req)
+}
+
+// Run executes the client mode selected by args, handled is false when args
+// do not select any and the binary should run the registry server
+func Run(args []string) (handled bool, exitCode int) {
+	if len(args) == 0 {
+		return false, 0
+	}
+	switch args[0] {
+	case "--list":
+		return true, report(ansibleList())
+	case "--host":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: --host <hostname>")
+			return true, 2
+		}
+		return true, report(ansibleHost(args[1]))
+	case "ssh":
+		return true, sshCommand(args[1:])
+	case "export":
+		return true, exportCommand(args[1:])
+	case "import":
+		return true, importCommand(args[1:])
+	case "backups":
+		return true, backupsCommand(args[1:])
+	case "users":
+		return true, usersCommand(args[1:])
+	}
+	return false, 0
+}
+
+func report(err error) int {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func registryURL() string {
+	if url := os.Getenv(RegistryURLEnv); url != "" {
+		return strings.TrimRight(url, "/")
+	}
+	return "http://localhost:38080"
+}
+
+// getJSON fetches path from the registry and decodes the response into v,
+// error messages the registry sends as a JSON string are returned as errors
+func getJSON(path string, v interface{}) error {
+	resp, err := get(path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry answered %s to GET %s: %s", resp.Status, path, body)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		var msg string
+		if json.Unmarshal(body, &msg) == nil {
+			return errors.New(msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// registryServes reports whether GET path succeeds on the registry
+func registryServes(path string) bool {
+	resp, err := get(path)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// postJSON sends v encoded in JSON to path and decodes the response into
+// out, any 2xx status is a success
+func postJSON(path string, v interface{}, out interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", registryURL()+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var msg string
+		if json.Unmarshal(body, &msg) == nil {
+			return errors.New(msg)
+		}
+		return fmt.Errorf("registry answered %s to POST %s: %s", resp.Status, path, body)
+	}
+	return json.Unmarshal(body, out)
+}
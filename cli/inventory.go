@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+)
+
+const ansibleInventoryPath = "/v1/inventory/ansible"
+
+// ansibleList prints the whole inventory, as Ansible expects from --list
+func ansibleList() error {
+	var inventory map[string]interface{}
+	if err := getJSON(ansibleInventoryPath, &inventory); err != nil {
+		return err
+	}
+	return json.NewEncoder(os.Stdout).Encode(inventory)
+}
+
+// ansibleHost prints the variables of a single host, as Ansible expects
+// from --host, unknown hosts have no variables
+func ansibleHost(host string) error {
+	var inventory struct {
+		Meta struct {
+			HostVars map[string]map[string]interface{} `json:"hostvars"`
+		} `json:"_meta"`
+	}
+	if err := getJSON(ansibleInventoryPath, &inventory); err != nil {
+		return err
+	}
+	vars, ok := inventory.Meta.HostVars[host]
+	if !ok {
+		vars = map[string]interface{}{}
+	}
+	return json.NewEncoder(os.Stdout).Encode(vars)
+}
@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+)
+
+// PassphraseEnv names the environment variable holding the passphrase of
+// the export and import subcommands, when no passphrase file is given
+const PassphraseEnv = "SINKER_PASSPHRASE"
+
+// snapshotFlags are the flags shared by export and import, they select the
+// database the subcommands work on directly, the registry need not run
+func snapshotFlags(name string) (*flag.FlagSet, *string, *string) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	source := flags.String("datasource", models.DataSource, "database data source")
+	passphraseFile := flags.String("passphrase-file", "", "file holding the passphrase, $"+PassphraseEnv+" by default")
+	return flags, source, passphraseFile
+}
+
+func readPassphrase(file string) (string, error) {
+	if file == "" {
+		if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+			return passphrase, nil
+		}
+		return "", fmt.Errorf("no passphrase, set $%s or use -passphrase-file", PassphraseEnv)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// exportCommand writes a snapshot of the registry database to a file, or
+// to stdout
+func exportCommand(args []string) int {
+	flags, source, passphraseFile := snapshotFlags("export")
+	output := flags.String("o", "-", "snapshot file, - for stdout")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: export [-o file] [-passphrase-file file] [-datasource source]")
+		return 2
+	}
+	passphrase, err := readPassphrase(*passphraseFile)
+	if err != nil {
+		return report(err)
+	}
+	if err := models.OpenDatabase(models.DatabaseDriver, *source); err != nil {
+		return report(err)
+	}
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return report(err)
+		}
+		defer file.Close()
+		w = file
+	}
+	manifest, err := models.ExportSnapshot(w, passphrase)
+	if err != nil {
+		if *output != "-" {
+			os.Remove(*output)
+		}
+		return report(err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %s\n", formatCounts(manifest))
+	return 0
+}
+
+// importCommand replaces the content of the registry database with a
+// snapshot, the registry must not be running on that database
+func importCommand(args []string) int {
+	flags, source, passphraseFile := snapshotFlags("import")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: import [-passphrase-file file] [-datasource source] <snapshot file|->")
+		return 2
+	}
+	passphrase, err := readPassphrase(*passphraseFile)
+	if err != nil {
+		return report(err)
+	}
+	var r io.Reader = os.Stdin
+	if name := flags.Arg(0); name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return report(err)
+		}
+		defer file.Close()
+		r = file
+	}
+	if err := models.OpenDatabase(models.DatabaseDriver, *source); err != nil {
+		return report(err)
+	}
+	manifest, err := models.RestoreSnapshot(r, passphrase)
+	if err != nil {
+		return report(err)
+	}
+	fmt.Fprintf(os.Stderr, "restored %s from the snapshot of %s into %s\n", formatCounts(manifest), manifest.CreatedAt.Format("2006-01-02 15:04:05 MST"), *source)
+	return 0
+}
+
+func formatCounts(manifest *models.SnapshotManifest) string {
+	return fmt.Sprintf("%d probes, %d host keys, %d providers, %d groups, %d users, %d webhooks, %d deliveries and %d changes",
+		manifest.Counts["probe"], manifest.Counts["probe_host_key"], manifest.Counts["provider"], manifest.Counts["probe_group"],
+		manifest.Counts["user"], manifest.Counts["webhook"], manifest.Counts["webhook_delivery"], manifest.Counts["probe_change"])
+}
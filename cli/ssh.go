@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+	"github.com/asaskevich/govalidator"
+)
+
+// SSHUserEnv names the environment variable holding the remote user of
+// the ssh subcommand, root is used when it is not set
+const SSHUserEnv = "SINKER_SSH_USER"
+
+// sshCommand runs ssh against a probe given by id, fqdn or IPv4 address,
+// with a short lived certificate when the registry is a certificate
+// authority, or else with the private key stored in the registry
+func sshCommand(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: ssh <probe id|fqdn|ipv4> [ssh arguments...]")
+		return 2
+	}
+	probe, err := resolveProbe(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	dir, err := ioutil.TempDir("", "sinker-ssh")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "id")
+	if err := writeIdentity(probe, keyFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	user := os.Getenv(SSHUserEnv)
+	if user == "" {
+		user = "root"
+	}
+	sshArgs := []string{"-i", keyFile, "-o", "IdentitiesOnly=yes", "-l", user}
+	var pinned []*models.ProbeHostKey
+	if err := getJSON("/v1/probe/"+url.PathEscape(probe.ProbeID)+"/hostkeys", &pinned); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to get host keys of probe %s: %s\n", probe.ProbeID, err)
+		return 1
+	}
+	if lines := models.KnownHostsLines(probe, pinned); len(lines) > 0 {
+		knownHosts := filepath.Join(dir, "known_hosts")
+		if err := ioutil.WriteFile(knownHosts, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		sshArgs = append(sshArgs, "-o", "UserKnownHostsFile="+knownHosts, "-o", "StrictHostKeyChecking=yes")
+	}
+	sshArgs = append(sshArgs, probe.Ipv4)
+	sshArgs = append(sshArgs, args[1:]...)
+
+	cmd := exec.Command("ssh", sshArgs...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				return status.ExitStatus()
+			}
+		}
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// writeIdentity writes the private key to log in to probe with at keyFile,
+// along with its certificate at keyFile-cert.pub when the registry issues
+// them
+func writeIdentity(probe *models.Probe, keyFile string) error {
+	if registryServes("/v1/ssh/ca.pub") {
+		private, public, err := models.GenerateSSHKey("")
+		if err != nil {
+			return err
+		}
+		request := models.CertificateRequest{PublicKey: public, ProbeIDs: []string{probe.ProbeID}, Requester: requester()}
+		var cert models.SignedCertificate
+		if err := postJSON("/v1/ssh/certificates", request, &cert); err != nil {
+			return fmt.Errorf("unable to get an ssh certificate for probe %s: %s", probe.ProbeID, err)
+		}
+		if err := ioutil.WriteFile(keyFile, []byte(private), 0600); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(keyFile+"-cert.pub", []byte(cert.Certificate+"\n"), 0600)
+	}
+	var keys map[string]string
+	if err := getJSON("/v1/probe/ssh/"+url.PathEscape(probe.ProbeID), &keys); err != nil {
+		return fmt.Errorf("unable to get ssh keys of probe %s: %s", probe.ProbeID, err)
+	}
+	return ioutil.WriteFile(keyFile, []byte(keys["SSHPrivateKey"]), 0600)
+}
+
+// requester identifies who asks for certificates, as user@host
+func requester() string {
+	user := os.Getenv("USER")
+	if user == "" {
+		user = "unknown"
+	}
+	host, _ := os.Hostname()
+	return user + "@" + host
+}
+
+// resolveProbe finds an enabled probe by IPv4 address, FQDN or id
+func resolveProbe(ref string) (*models.Probe, error) {
+	var probes []models.Probe
+	switch {
+	case govalidator.IsIPv4(ref):
+		if err := getJSON("/v1/probe/ip/"+ref, &probes); err != nil {
+			return nil, err
+		}
+	case strings.Contains(ref, ".") && govalidator.IsDNSName(ref):
+		if err := getJSON("/v1/probe/name/"+url.PathEscape(ref), &probes); err != nil {
+			return nil, err
+		}
+	default:
+		var probe models.Probe
+		if err := getJSON("/v1/probe/"+url.PathEscape(ref), &probe); err != nil {
+			return nil, fmt.Errorf("probe %s: %s", ref, err)
+		}
+		return &probe, nil
+	}
+	if len(probes) == 0 {
+		return nil, fmt.Errorf("no enabled probe found for %s", ref)
+	}
+	if len(probes) > 1 {
+		return nil, fmt.Errorf("%d probes found for %s, use the probe id", len(probes), ref)
+	}
+	return &probes[0], nil
+}
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+)
+
+// TokenEnv names the environment variable holding the token sent to the
+// registry when it authenticates API calls
+const TokenEnv = "SINKER_TOKEN"
+
+// usersCommand lists or adds registry users on its database directly, it
+// bootstraps the first admin when API calls are authenticated
+func usersCommand(args []string) int {
+	flags := flag.NewFlagSet("users", flag.ContinueOnError)
+	driver := flags.String("driver", models.DatabaseDriver, "database driver")
+	source := flags.String("datasource", models.DataSource, "database data source")
+	usage := "usage: users [-driver driver] [-datasource source] list | users [-driver driver] [-datasource source] add <name> <role> [team]"
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	switch {
+	case flags.Arg(0) == "list" && flags.NArg() == 1:
+		if err := models.OpenDatabase(*driver, *source); err != nil {
+			return report(err)
+		}
+		users, err := models.GetAllUsers()
+		if err != nil {
+			return report(err)
+		}
+		for _, user := range users {
+			fmt.Printf("%d\t%s\t%s\t%d\n", user.ID, user.Name, user.Role, user.Team)
+		}
+		return 0
+	case flags.Arg(0) == "add" && (flags.NArg() == 3 || flags.NArg() == 4):
+		var team int64
+		if flags.NArg() == 4 {
+			id, err := strconv.ParseInt(flags.Arg(3), 10, 64)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, usage)
+				return 2
+			}
+			team = id
+		}
+		if err := models.OpenDatabase(*driver, *source); err != nil {
+			return report(err)
+		}
+		user, err := models.AddUser(flags.Arg(1), flags.Arg(2), team)
+		if err != nil {
+			return report(err)
+		}
+		fmt.Fprintf(os.Stderr, "added %s user %s, its token is only shown once\n", user.Role, user.Name)
+		fmt.Println(user.Token)
+		return 0
+	}
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Registry administration
+type AdminController struct {
+	authController
+}
+
+func (a *AdminController) URLMapping() {
+	a.Mapping("Export", a.Export)
+	a.Mapping("Backups", a.Backups)
+	a.Mapping("Backup", a.Backup)
+	a.Mapping("Roles", a.Roles)
+	a.Mapping("Users", a.Users)
+	a.Mapping("AddUser", a.AddUser)
+	a.Mapping("GetUser", a.GetUser)
+	a.Mapping("UpdateUser", a.UpdateUser)
+	a.Mapping("ResetToken", a.ResetToken)
+	a.Mapping("DeleteUser", a.DeleteUser)
+	a.Mapping("Teams", a.Teams)
+	a.Mapping("AddTeam", a.AddTeam)
+	a.Mapping("UpdateTeam", a.UpdateTeam)
+	a.Mapping("DeleteTeam", a.DeleteTeam)
+	a.Mapping("EnrollmentTokens", a.EnrollmentTokens)
+	a.Mapping("AddEnrollmentToken", a.AddEnrollmentToken)
+	a.Mapping("DeleteEnrollmentToken", a.DeleteEnrollmentToken)
+}
+
+// @Title Export the registry
+// @Description consistent snapshot of the whole registry, restored with the import subcommand
+// @Success 200 {string} application/gzip snapshot
+// @Param  passphrase  body string true "passphrase the ssh private keys and webhook secrets are encrypted with"
+// @router /export [post]
+func (a *AdminController) Export() {
+	var request struct {
+		Passphrase string `json:"passphrase"`
+	}
+	if err := json.Unmarshal(a.Ctx.Input.RequestBody, &request); err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(400)
+		a.ServeJSON()
+		return
+	}
+	var buf bytes.Buffer
+	manifest, err := models.ExportSnapshot(&buf, request.Passphrase)
+	if err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(400)
+		a.ServeJSON()
+		return
+	}
+	log.Infof("[controllers.admin.Export]: exported snapshot %v", manifest.Counts)
+	filename := fmt.Sprintf("sinker-registry-%s.tar.gz", manifest.CreatedAt.Format("20060102T150405Z"))
+	a.Ctx.Output.Header("Content-Type", "application/gzip")
+	a.Ctx.Output.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	a.Ctx.Output.Body(buf.Bytes())
+}
+
+// @Title Backups
+// @Description online backups of the registry database, newest first
+// @Success 200 {object} []models.Backup
+// @router /backups [get]
+func (a *AdminController) Backups() {
+	obs, err := models.GetBackups()
+	if err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(500)
+	} else {
+		a.Data["json"] = obs
+	}
+	a.ServeJSON()
+}
+
+// @Title Take a backup
+// @Description backs up the registry database now, rotating the oldest backups
+// @Success 201 {object} models.Backup
+// @router /backups [post]
+func (a *AdminController) Backup() {
+	ob, err := models.TakeBackup()
+	if err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(500)
+	} else {
+		a.Data["json"] = ob
+		a.Ctx.Output.SetStatus(201)
+	}
+	a.ServeJSON()
+}
@@ -0,0 +1,243 @@
+package controllers
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+)
+
+// actionPermissions maps every controller action to the permission it
+// requires, actions missing here require the admin permission
+var actionPermissions = map[string]string{
+	"ProbeController.Post":              models.PermProbeWrite,
+	"ProbeController.Put":               models.PermProbeWrite,
+	"ProbeController.Import":            models.PermProbeWrite,
+	"ProbeController.Get":               models.PermProbeRead,
+	"ProbeController.GetAll":            models.PermProbeRead,
+	"ProbeController.GetByIP":           models.PermProbeRead,
+	"ProbeController.GetByFQDN":         models.PermProbeRead,
+	"ProbeController.Near":              models.PermProbeRead,
+	"ProbeController.Within":            models.PermProbeRead,
+	"ProbeController.Disable":           models.PermProbeWrite,
+	"ProbeController.Enable":            models.PermProbeWrite,
+	"ProbeController.UpdateTracesPath":  models.PermProbeWrite,
+	"ProbeController.UploadSSH":         models.PermProbeSSH,
+	"ProbeController.VerifyDNS":         models.PermProbeWrite,
+	"ProbeController.SetHostKeys":       models.PermProbeWrite,
+	"ProbeController.GetHostKeys":       models.PermProbeRead,
+	"ProbeController.GetHostKeyChanges": models.PermProbeRead,
+	"ProbeController.ScanHostKeys":      models.PermProbeWrite,
+	"ProbeController.StageKey":          models.PermProbeSSH,
+	"ProbeController.GetKeyRotation":    models.PermProbeRead,
+	"ProbeController.PromoteKey":        models.PermProbeSSH,
+	"ProbeController.CancelKeyRotation": models.PermProbeSSH,
+	"ProbeController.StaleKeys":         models.PermProbeRead,
+	"ProbeController.GetSSH":            models.PermProbeSSH,
+	"ProbeController.Delete":            models.PermProbeDelete,
+
+	"ProviderController.GetAll": models.PermProbeRead,
+	"ProviderController.Get":    models.PermProbeRead,
+
+	"StatsController.Get":                   models.PermProbeRead,
+	"InventoryController.Ansible":           models.PermProbeRead,
+	"ServiceDiscoveryController.Prometheus": models.PermProbeRead,
+	"ExportController.SSHConfig":            models.PermProbeRead,
+	"ExportController.KnownHosts":           models.PermProbeRead,
+	"DNSController.Zone":                    models.PermProbeRead,
+	"DNSController.Sync":                    models.PermProbeWrite,
+	"EventsController.Stream":               models.PermProbeRead,
+
+	"SSHController.CAPublicKey": models.PermProbeRead,
+	"SSHController.Issue":       models.PermProbeSSH,
+
+	"OIDCController.Me": models.PermProbeRead,
+
+	"ProbeController.IssueCertificate":   models.PermProbeWrite,
+	"ProbeController.GetCertificates":    models.PermProbeRead,
+	"ProbeController.RevokeCertificates": models.PermProbeWrite,
+
+	"ProbeController.SetLabels":    models.PermProbeWrite,
+	"ProbeController.RemoveLabels": models.PermProbeWrite,
+
+	"ProbeController.BulkEnable":        models.PermProbeWrite,
+	"ProbeController.BulkDisable":       models.PermProbeWrite,
+	"ProbeController.BulkSetTracesPath": models.PermProbeWrite,
+	"ProbeController.BulkDelete":        models.PermProbeDelete,
+
+	"GroupController.GetAll":        models.PermProbeRead,
+	"GroupController.Get":           models.PermProbeRead,
+	"GroupController.Probes":        models.PermProbeRead,
+	"GroupController.Post":          models.PermProbeWrite,
+	"GroupController.Put":           models.PermProbeWrite,
+	"GroupController.Delete":        models.PermProbeWrite,
+	"GroupController.AddMembers":    models.PermProbeWrite,
+	"GroupController.RemoveMembers": models.PermProbeWrite,
+}
+
+// probeActions are the actions probes authenticated by their client
+// certificate may call, on their own record only
+var probeActions = map[string]bool{
+	"ProbeController.Get":              true,
+	"ProbeController.GetSSH":           true,
+	"ProbeController.GetHostKeys":      true,
+	"ProbeController.GetKeyRotation":   true,
+	"ProbeController.UploadSSH":        true,
+	"ProbeController.UpdateTracesPath": true,
+	"ProbeController.SetHostKeys":      true,
+}
+
+// publicActions are the actions anyone may call, the ones logging in, the
+// ones publishing the probe CA and enrollment, authenticated by its token
+var publicActions = map[string]bool{
+	"OIDCController.Login":        true,
+	"OIDCController.Callback":     true,
+	"OIDCController.Logout":       true,
+	"PKIController.CACertificate": true,
+	"PKIController.CRL":           true,
+	"EnrollController.Enroll":     true,
+}
+
+// TrustProxy takes the source IP of calls from the X-Forwarded-For header,
+// only set it behind a proxy setting it
+var TrustProxy = false
+
+// sourceIP returns the address a call comes from
+func sourceIP(ctx *context.Context) string {
+	if !TrustProxy {
+		if host, _, err := net.SplitHostPort(ctx.Request.RemoteAddr); err == nil {
+			return host
+		}
+	}
+	return ctx.Input.IP()
+}
+
+// authController authenticates the caller of every action by its bearer
+// token, or the session cookie of browsers, and checks its role grants the
+// permission of the action, and that the probe the action is about is
+// within the scope of its team. Probes presenting a client certificate of
+// the probe CA are only allowed their own record.
+type authController struct {
+	beego.Controller
+	user *models.User
+	// probe is the ProbeID of the probe calling, when authenticated by its
+	// client certificate
+	probe string
+}
+
+// targetProbe returns the ProbeID the action is about, if any, taken from
+// the path first. It is false when the path and the id query parameter name
+// different probes, actions may read either.
+func (c *authController) targetProbe() (string, bool) {
+	ProbeID := c.Ctx.Input.Param(":id")
+	query := c.GetString("id")
+	if ProbeID != "" && query != "" && query != ProbeID {
+		return "", false
+	}
+	if ProbeID == "" {
+		ProbeID = query
+	}
+	return ProbeID, true
+}
+
+func (c *authController) Prepare() {
+	controller, action := c.GetControllerAndAction()
+	if publicActions[controller+"."+action] {
+		return
+	}
+	if _, ok := c.targetProbe(); controller == "ProbeController" && !ok {
+		c.refuse(400, "the probe id of the path and of the query differ")
+		return
+	}
+	if state := c.Ctx.Request.TLS; state != nil && len(state.VerifiedChains) > 0 {
+		c.prepareProbe(controller, action, state.VerifiedChains[0][0])
+		return
+	}
+	if !models.AuthEnabled() {
+		return
+	}
+	token := strings.TrimPrefix(c.Ctx.Input.Header("Authorization"), "Bearer ")
+	if token == "" {
+		token = c.Ctx.GetCookie(sessionCookie)
+	}
+	user, err := models.Authenticate(token)
+	if err != nil {
+		c.Ctx.Output.Header("WWW-Authenticate", `Bearer realm="sinker_registry_api"`)
+		c.refuse(401, "missing or invalid bearer token")
+		return
+	}
+	perm, ok := actionPermissions[controller+"."+action]
+	if !ok {
+		perm = models.PermAdmin
+	}
+	if !user.Can(perm) {
+		log.Warningf("[controllers.auth.Prepare]: %s user %s denied %s.%s", user.Role, user.Name, controller, action)
+		c.refuse(403, fmt.Sprintf("role %s lacks the %s permission", user.Role, perm))
+		return
+	}
+	if controller == "ProbeController" {
+		ProbeID, _ := c.targetProbe()
+		if probe, err := models.GetByID(ProbeID); err == nil && !user.Sees(probe) {
+			c.refuse(403, fmt.Sprintf("probe %s is outside the scope of user %s", ProbeID, user.Name))
+			return
+		}
+	}
+	c.user = user
+	c.Ctx.Input.SetData("user", user)
+}
+
+// prepareProbe only lets a probe call the probe actions on its own record
+func (c *authController) prepareProbe(controller string, action string, cert *x509.Certificate) {
+	ProbeID, err := models.AuthenticateProbe(cert)
+	if err != nil {
+		log.Warningf("[controllers.auth.prepareProbe]: refusing client certificate from %s: %s", c.Ctx.Input.IP(), err)
+		c.refuse(401, "invalid client certificate")
+		return
+	}
+	if target, _ := c.targetProbe(); !probeActions[controller+"."+action] || target != ProbeID {
+		log.Warningf("[controllers.auth.prepareProbe]: probe %s denied %s.%s on %s", ProbeID, controller, action, target)
+		c.refuse(403, fmt.Sprintf("probe %s may only access its own record", ProbeID))
+		return
+	}
+	c.probe = ProbeID
+	c.Ctx.Input.SetData("probe", ProbeID)
+}
+
+func (c *authController) refuse(status int, msg string) {
+	c.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", msg)
+	c.Ctx.Output.SetStatus(status)
+	c.ServeJSON()
+	c.StopRun()
+}
+
+// sees reports whether probe is within the scope of the caller
+func (c *authController) sees(probe *models.Probe) bool {
+	return c.user == nil || c.user.Sees(probe)
+}
+
+// requireScope refuses the call when the probe it registers is outside the
+// scope of the caller
+func (c *authController) requireScope(probe *models.Probe) {
+	if c.user == nil {
+		return
+	}
+	if provider, err := models.LookupProvider(probe.Provider); err == nil {
+		probe.Provider = provider.Name
+	}
+	if !c.user.Sees(probe) {
+		c.refuse(403, fmt.Sprintf("probe %s is outside the scope of user %s", probe.FQDN, c.user.Name))
+	}
+}
+
+// scope returns the probes the caller sees, nil for all of them
+func scope(c *beego.Controller) *models.Scope {
+	if user, ok := c.Ctx.Input.GetData("user").(*models.User); ok {
+		return user.Scope()
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"fmt"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+	"github.com/astaxie/beego"
+)
+
+// DNS records of the probes
+type DNSController struct {
+	authController
+}
+
+func (d *DNSController) URLMapping() {
+	d.Mapping("Zone", d.Zone)
+	d.Mapping("Sync", d.Sync)
+}
+
+// @Title Zone file
+// @Description A and AAAA records of the enabled probes in BIND master file format
+// @Success 200 {string} zone file
+// @Param  zone  query string false "zone to render, dnszone from app.conf by default"
+// @router /zone [get]
+func (d *DNSController) Zone() {
+	zone := d.GetString("zone", beego.AppConfig.String("dnszone"))
+	if zone == "" {
+		d.Data["json"] = "{ 'msg': 'no zone given and dnszone is not configured' }"
+		d.Ctx.Output.SetStatus(400)
+		d.ServeJSON()
+		return
+	}
+	obs, err := models.Find(models.ProbeFilter{State: models.StateEnabled, Scope: scope(&d.Controller)})
+	if err != nil {
+		d.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		d.Ctx.Output.SetStatus(500)
+		d.ServeJSON()
+		return
+	}
+	ttl := uint32(beego.AppConfig.DefaultInt("dnsttl", 300))
+	d.Ctx.Output.Header("Content-Type", "text/plain; charset=utf-8")
+	d.Ctx.Output.Body([]byte(models.ToZoneFile(obs, zone, ttl)))
+}
+
+// @Title Push DNS records
+// @Description publishes the records of every probe through dynamic DNS updates
+// @Success 200 {string} number of names published
+// @router /sync [post]
+func (d *DNSController) Sync() {
+	count, err := models.SyncDNS()
+	if err != nil {
+		d.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		d.Ctx.Output.SetStatus(500)
+	} else {
+		d.Data["json"] = map[string]int{"published": count}
+	}
+	d.ServeJSON()
+}
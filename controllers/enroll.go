@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Probe self-registration
+type EnrollController struct {
+	authController
+}
+
+func (e *EnrollController) URLMapping() {
+	e.Mapping("Enroll", e.Enroll)
+}
+
+// @Title Enroll a probe
+// @Description registers the probe calling with an enrollment token, the source address of the call is its ipv4 when missing. Responds like probe creation.
+// @Success 201 {object} map[string]string
+// @Param  token  body string true "enrollment token, may be sent as bearer token instead"
+// @Param  fqdn  body string true "fqdn address of the probe"
+// @Param  ipv4  body string false "ipv4 address of the probe"
+// @Param  provider  body string false "cloud provider of the probe, the one of the token by default"
+// @router / [post]
+func (e *EnrollController) Enroll() {
+	var pr models.Probe
+	pr.SetDefaults()
+	var request struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(e.Ctx.Input.RequestBody, &request); err != nil {
+		e.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		e.Ctx.Output.SetStatus(400)
+		e.ServeJSON()
+		return
+	}
+	json.Unmarshal(e.Ctx.Input.RequestBody, &pr)
+	if request.Token == "" {
+		request.Token = strings.TrimPrefix(e.Ctx.Input.Header("Authorization"), "Bearer ")
+	}
+	source := sourceIP(e.Ctx)
+	probeid, err := models.Enroll(request.Token, pr, source)
+	if err != nil {
+		log.Warningf("[controllers.enroll.Enroll]: enrollment from %s refused: %s", source, err)
+		e.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		if _, refused := err.(*models.EnrollmentRefused); refused {
+			e.Ctx.Output.SetStatus(403)
+		} else {
+			e.Ctx.Output.SetStatus(400)
+		}
+		e.ServeJSON()
+		return
+	}
+	e.Data["json"] = registered(probeid)
+	e.Ctx.Output.SetStatus(201)
+	e.ServeJSON()
+}
+
+// @Title Enrollment tokens
+// @Description every enrollment token, used or not
+// @Success 200 {object} []models.EnrollmentToken
+// @router /enrollments [get]
+func (a *AdminController) EnrollmentTokens() {
+	obs, err := models.GetEnrollmentTokens()
+	if err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(500)
+	} else {
+		a.Data["json"] = obs
+	}
+	a.ServeJSON()
+}
+
+// @Title Mint an enrollment token
+// @Description single use token a probe registers itself with, only shown in the response
+// @Success 201 {object} models.NewEnrollmentToken
+// @Param  description  body string false "what the token is for"
+// @Param  provider  body string false "provider the probe must belong to"
+// @Param  cidr  body string false "network the probe must enroll from"
+// @Param  enable  body bool false "enable the probe once enrolled"
+// @Param  ttl  body int false "minutes the token lasts"
+// @router /enrollments [post]
+func (a *AdminController) AddEnrollmentToken() {
+	var request struct {
+		models.EnrollmentToken
+		TTL int `json:"ttl"`
+	}
+	if err := json.Unmarshal(a.Ctx.Input.RequestBody, &request); err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(400)
+		a.ServeJSON()
+		return
+	}
+	createdBy, team := "", int64(0)
+	if a.user != nil {
+		createdBy, team = a.user.Name, a.user.Team
+	}
+	ob, err := models.AddEnrollmentToken(request.EnrollmentToken, time.Duration(request.TTL)*time.Minute, createdBy, team)
+	if err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(400)
+	} else {
+		a.Data["json"] = ob
+		a.Ctx.Output.SetStatus(201)
+	}
+	a.ServeJSON()
+}
+
+// @Title Delete an enrollment token
+// @router /enrollments/:id [delete]
+func (a *AdminController) DeleteEnrollmentToken() {
+	id, err := a.GetInt64(":id")
+	if err == nil {
+		err = models.DeleteEnrollmentToken(id)
+	}
+	if err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(400)
+	} else {
+		a.Data["json"] = "delete success!"
+	}
+	a.ServeJSON()
+}
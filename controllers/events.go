@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	replayBatchSize   = 500
+	keepaliveInterval = 30 * time.Second
+)
+
+// Feed of probe changes
+type EventsController struct {
+	authController
+}
+
+func (e *EventsController) URLMapping() {
+	e.Mapping("Stream", e.Stream)
+}
+
+// changeData is the data field of every server sent event
+type changeData struct {
+	ID        int64           `json:"id"`
+	Event     string          `json:"event"`
+	Detail    string          `json:"detail,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	Probe     json.RawMessage `json:"probe"`
+}
+
+// @Title Change feed
+// @Description streams probe changes as server sent events, replaying those after Last-Event-ID first, clients naming no last event only get the changes from now on
+// @Success 200 {string} text/event-stream
+// @Param  Last-Event-ID  header int false "id of the last change received"
+// @Param  lastEventId  query int false "same as Last-Event-ID, for clients unable to set headers"
+// @router / [get]
+func (e *EventsController) Stream() {
+	lastID, err := e.lastEventID()
+	if err != nil {
+		e.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		e.Ctx.Output.SetStatus(400)
+		e.ServeJSON()
+		return
+	}
+	// subscribing before replaying ensures no change is missed in between,
+	// those received twice are skipped by id
+	changes, unsubscribe := models.SubscribeChanges()
+	defer unsubscribe()
+	if lastID < 0 {
+		if lastID, err = models.LatestChangeID(); err != nil {
+			log.Errorf("[controllers.events.Stream]: unable to find the last change: %s", err)
+			e.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+			e.Ctx.Output.SetStatus(500)
+			e.ServeJSON()
+			return
+		}
+	}
+
+	w := e.Ctx.ResponseWriter
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+	fmt.Fprintf(w, "retry: 5000\n\n")
+
+	for {
+		missed, err := models.GetChangesSince(lastID, replayBatchSize)
+		if err != nil {
+			log.Errorf("[controllers.events.Stream]: unable to replay changes after %d: %s", lastID, err)
+			return
+		}
+		for _, change := range missed {
+			if !e.writeChange(change) {
+				return
+			}
+			lastID = change.ID
+		}
+		if len(missed) < replayBatchSize {
+			break
+		}
+	}
+	w.Flush()
+
+	done := e.Ctx.Request.Context().Done()
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-keepalive.C:
+			fmt.Fprintf(w, ": keepalive\n\n")
+			w.Flush()
+		case change, ok := <-changes:
+			if !ok {
+				// too far behind, the client reconnects and replays
+				return
+			}
+			if change.ID <= lastID {
+				continue
+			}
+			if !e.writeChange(change) {
+				return
+			}
+			lastID = change.ID
+			w.Flush()
+		}
+	}
+}
+
+// lastEventID returns the id of the last change the client received, -1
+// when it names none
+func (e *EventsController) lastEventID() (int64, error) {
+	value := e.Ctx.Input.Header("Last-Event-ID")
+	if value == "" {
+		value = e.GetString("lastEventId")
+	}
+	if value == "" {
+		return -1, nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("invalid last event id %s", value)
+	}
+	return id, nil
+}
+
+func (e *EventsController) writeChange(change *models.ProbeChange) bool {
+	if e.user != nil {
+		// the payload is the probe as it was, it may be deleted since
+		var probe models.Probe
+		if json.Unmarshal([]byte(change.Payload), &probe) != nil || !e.sees(&probe) {
+			return true
+		}
+	}
+	data, err := json.Marshal(changeData{
+		ID:        change.ID,
+		Event:     change.Event,
+		Detail:    change.Detail,
+		CreatedAt: change.CreatedAt,
+		Probe:     json.RawMessage(change.Payload),
+	})
+	if err != nil {
+		log.Errorf("[controllers.events.writeChange]: unable to encode change %d: %s", change.ID, err)
+		return false
+	}
+	_, err = fmt.Fprintf(e.Ctx.ResponseWriter, "id: %d\nevent: %s\ndata: %s\n\n", change.ID, change.Event, data)
+	return err == nil
+}
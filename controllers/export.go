@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"fmt"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+	"github.com/astaxie/beego"
+)
+
+// Exports of the probe fleet for third party tools
+type ExportController struct {
+	authController
+}
+
+func (e *ExportController) URLMapping() {
+	e.Mapping("SSHConfig", e.SSHConfig)
+	e.Mapping("KnownHosts", e.KnownHosts)
+}
+
+// @Title OpenSSH client config
+// @Description a Host block per enabled probe
+// @Success 200 {string} ssh_config
+// @Param  user  query string false "remote user, sshuser from app.conf by default"
+// @Param  identityfile  query string false "identity file, %n is replaced by the host alias"
+// @Param  provider  query string false "only probes of this provider"
+// @Param  country  query string false "only probes located in this country"
+// @Param  selector  query string false "only probes matching this label selector"
+// @router /ssh_config [get]
+func (e *ExportController) SSHConfig() {
+	obs, ok := e.findProbes()
+	if !ok {
+		return
+	}
+	user := e.GetString("user", beego.AppConfig.DefaultString("sshuser", "root"))
+	identityFile := e.GetString("identityfile", "~/.ssh/sinker/%n")
+	e.serveText(models.ToSSHConfig(obs, user, identityFile))
+}
+
+// @Title OpenSSH known_hosts
+// @Description host keys pinned for the enabled probes, or registered by them
+// @Success 200 {string} known_hosts
+// @Param  provider  query string false "only probes of this provider"
+// @Param  country  query string false "only probes located in this country"
+// @Param  selector  query string false "only probes matching this label selector"
+// @router /known_hosts [get]
+func (e *ExportController) KnownHosts() {
+	obs, ok := e.findProbes()
+	if !ok {
+		return
+	}
+	pinned, err := models.GetAllHostKeys()
+	if err != nil {
+		e.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		e.Ctx.Output.SetStatus(500)
+		e.ServeJSON()
+		return
+	}
+	e.serveText(models.ToKnownHosts(obs, pinned))
+}
+
+func (e *ExportController) findProbes() ([]*models.Probe, bool) {
+	obs, err := models.Find(getProbeFilter(&e.Controller))
+	if err != nil {
+		e.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		e.Ctx.Output.SetStatus(400)
+		e.ServeJSON()
+		return nil, false
+	}
+	return obs, true
+}
+
+func (e *ExportController) serveText(content string) {
+	e.Ctx.Output.Header("Content-Type", "text/plain; charset=utf-8")
+	e.Ctx.Output.Body([]byte(content))
+}
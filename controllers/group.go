@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+)
+
+// Operations about probe groups
+type GroupController struct {
+	authController
+}
+
+func (g *GroupController) URLMapping() {
+	g.Mapping("Post", g.Post)
+	g.Mapping("Get", g.Get)
+	g.Mapping("GetAll", g.GetAll)
+	g.Mapping("Put", g.Put)
+	g.Mapping("Delete", g.Delete)
+	g.Mapping("Probes", g.Probes)
+	g.Mapping("AddMembers", g.AddMembers)
+	g.Mapping("RemoveMembers", g.RemoveMembers)
+}
+
+// requireGroupScope refuses changes to a group holding probes outside the
+// scope of the caller
+func (g *GroupController) requireGroupScope() {
+	name := g.GetString(":name")
+	within, err := models.GroupWithinScope(name, scope(&g.Controller))
+	if err != nil {
+		g.refuse(404, err.Error())
+	} else if !within {
+		g.refuse(403, fmt.Sprintf("group %s holds probes outside the scope of the caller", name))
+	}
+}
+
+// @Title Create a group
+// @Description creates a static group listing its members, or a dynamic one holding the probes matching its filter
+// @Success 201 {object} models.ProbeGroup
+// @Param  name  body string true "name of the group"
+// @Param  description  body string false "what the group is for"
+// @Param  filter  body models.ProbeFilter false "filter of a dynamic group, like {\"provider\": \"vultr\", \"selector\": \"env=prod\"}"
+// @Param  members  body []string false "probe ids of a static group"
+// @router / [post]
+func (g *GroupController) Post() {
+	var group models.ProbeGroup
+	if err := json.Unmarshal(g.Ctx.Input.RequestBody, &group); err != nil {
+		g.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		g.Ctx.Output.SetStatus(400)
+		g.ServeJSON()
+		return
+	}
+	ob, err := models.AddGroup(group, scope(&g.Controller))
+	if err != nil {
+		g.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		g.Ctx.Output.SetStatus(400)
+	} else {
+		g.Data["json"] = ob
+		g.Ctx.Output.SetStatus(201)
+	}
+	g.ServeJSON()
+}
+
+// @Title Groups
+// @Success 200 {object} []models.ProbeGroup
+// @router / [get]
+func (g *GroupController) GetAll() {
+	obs, err := models.GetAllGroups(scope(&g.Controller))
+	if err != nil {
+		g.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		g.Ctx.Output.SetStatus(500)
+	} else {
+		g.Data["json"] = obs
+	}
+	g.ServeJSON()
+}
+
+// @Title Get a group
+// @Success 200 {object} models.ProbeGroup
+// @Param  name  path string true "name of the group"
+// @router /:name [get]
+func (g *GroupController) Get() {
+	ob, err := models.GetGroup(g.GetString(":name"), scope(&g.Controller))
+	if err != nil {
+		g.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		g.Ctx.Output.SetStatus(404)
+	} else {
+		g.Data["json"] = ob
+	}
+	g.ServeJSON()
+}
+
+// @Title Update a group
+// @Description replaces the description of a group, and the filter of a dynamic one
+// @Success 200 {object} models.ProbeGroup
+// @Param  name  path string true "name of the group"
+// @router /:name [put]
+func (g *GroupController) Put() {
+	g.requireGroupScope()
+	var group models.ProbeGroup
+	if err := json.Unmarshal(g.Ctx.Input.RequestBody, &group); err != nil {
+		g.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		g.Ctx.Output.SetStatus(400)
+		g.ServeJSON()
+		return
+	}
+	ob, err := models.UpdateGroup(g.GetString(":name"), group)
+	if err != nil {
+		g.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		g.Ctx.Output.SetStatus(400)
+	} else {
+		g.Data["json"] = ob
+	}
+	g.ServeJSON()
+}
+
+// @Title Delete a group
+// @Description removes a group, leaving its probes alone
+// @Param  name  path string true "name of the group"
+// @router /:name [delete]
+func (g *GroupController) Delete() {
+	g.requireGroupScope()
+	if err := models.DeleteGroup(g.GetString(":name")); err != nil {
+		g.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		g.Ctx.Output.SetStatus(400)
+	} else {
+		g.Data["json"] = "delete success!"
+	}
+	g.ServeJSON()
+}
+
+// @Title Probes of a group
+// @Description the probes of a group, whatever their state unless the filter of a dynamic group has one
+// @Success 200 {object} []models.Probe
+// @Param  name  path string true "name of the group"
+// @router /:name/probes [get]
+func (g *GroupController) Probes() {
+	obs, err := models.GroupProbes(g.GetString(":name"), scope(&g.Controller))
+	if err != nil {
+		g.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		g.Ctx.Output.SetStatus(400)
+	} else {
+		g.Data["json"] = obs
+	}
+	g.ServeJSON()
+}
+
+// @Title Add members
+// @Description adds probes to a static group
+// @Success 200 {object} models.ProbeGroup
+// @Param  name  path string true "name of the group"
+// @Param  ids  body []string true "probe ids to add"
+// @router /:name/members [post]
+func (g *GroupController) AddMembers() {
+	g.requireGroupScope()
+	var request struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.Unmarshal(g.Ctx.Input.RequestBody, &request); err != nil {
+		g.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		g.Ctx.Output.SetStatus(400)
+		g.ServeJSON()
+		return
+	}
+	ob, err := models.AddGroupMembers(g.GetString(":name"), request.IDs, scope(&g.Controller))
+	if err != nil {
+		g.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		g.Ctx.Output.SetStatus(400)
+	} else {
+		g.Data["json"] = ob
+	}
+	g.ServeJSON()
+}
+
+// @Title Remove members
+// @Description removes probes from a static group
+// @Success 200 {object} models.ProbeGroup
+// @Param  name  path string true "name of the group"
+// @Param  ids  query string true "probe ids to remove, separated by commas"
+// @router /:name/members [delete]
+func (g *GroupController) RemoveMembers() {
+	g.requireGroupScope()
+	var ids []string
+	for _, id := range strings.Split(g.GetString("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	ob, err := models.RemoveGroupMembers(g.GetString(":name"), ids, scope(&g.Controller))
+	if err != nil {
+		g.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		g.Ctx.Output.SetStatus(400)
+	} else {
+		g.Data["json"] = ob
+	}
+	g.ServeJSON()
+}
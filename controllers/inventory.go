@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"fmt"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+)
+
+// Inventories for configuration management tools
+type InventoryController struct {
+	authController
+}
+
+func (i *InventoryController) URLMapping() {
+	i.Mapping("Ansible", i.Ansible)
+}
+
+// @Title Ansible dynamic inventory
+// @Description enabled probes grouped by provider and country, in the Ansible dynamic inventory format
+// @Success 200 {object} models.AnsibleInventory
+// @Param  provider  query string false "only probes of this provider"
+// @Param  country  query string false "only probes located in this country"
+// @Param  selector  query string false "only probes matching this label selector"
+// @Param  region  query string false "only probes in this provider region"
+// @router /ansible [get]
+func (i *InventoryController) Ansible() {
+	filter := getProbeFilter(&i.Controller)
+	obs, err := models.Find(filter)
+	if err != nil {
+		i.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		i.Ctx.Output.SetStatus(400)
+		i.ServeJSON()
+		return
+	}
+	i.Data["json"] = models.ToAnsibleInventory(obs)
+	i.ServeJSON()
+}
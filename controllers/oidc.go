@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// sessionCookie holds the ID token of browser sessions
+	sessionCookie = "sinker_session"
+	// loginCookie holds the state of the login a browser started, the
+	// callback must come back with it
+	loginCookie = "sinker_login"
+)
+
+// OpenID Connect login
+type OIDCController struct {
+	authController
+}
+
+func (a *OIDCController) URLMapping() {
+	a.Mapping("Login", a.Login)
+	a.Mapping("Callback", a.Callback)
+	a.Mapping("Logout", a.Logout)
+	a.Mapping("Me", a.Me)
+}
+
+// localPath keeps redirections within the registry
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	return path
+}
+
+func (a *OIDCController) setCookie(name string, value string, expires time.Time) {
+	http.SetCookie(a.Ctx.ResponseWriter, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   a.Ctx.Input.IsSecure(),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// @Title Log in
+// @Description sends the browser to the oidc issuer, it comes back to return once logged in
+// @Param  return  query string false "registry path to return to, / by default"
+// @router /login [get]
+func (a *OIDCController) Login() {
+	url, state, err := models.OIDCLoginURL(localPath(a.GetString("return", "/")))
+	if err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(404)
+		a.ServeJSON()
+		return
+	}
+	a.setCookie(loginCookie, state, time.Now().Add(models.OIDCLoginTimeout))
+	a.Redirect(url, 302)
+}
+
+// @Title Login callback
+// @Description the oidc issuer sends the browser back here, only the browser that started the login completes it, the session cookie lasts as long as the ID token
+// @Param  code  query string true "authorization code"
+// @Param  state  query string true "login state"
+// @router /callback [get]
+func (a *OIDCController) Callback() {
+	if reason := a.GetString("error"); reason != "" {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': 'login refused by the oidc issuer: %s' }", reason)
+		a.Ctx.Output.SetStatus(401)
+		a.ServeJSON()
+		return
+	}
+	state := a.GetString("state")
+	started := a.Ctx.GetCookie(loginCookie)
+	a.setCookie(loginCookie, "", time.Unix(1, 0))
+	if state == "" || subtle.ConstantTimeCompare([]byte(started), []byte(state)) != 1 {
+		log.Warningf("[controllers.oidc.Callback]: login from %s does not match the state of the browser", a.Ctx.Input.IP())
+		a.Data["json"] = "{ 'msg': 'login not started by this browser, start again' }"
+		a.Ctx.Output.SetStatus(401)
+		a.ServeJSON()
+		return
+	}
+	login, err := models.CompleteOIDCLogin(a.GetString("code"), state)
+	if err != nil {
+		log.Warningf("[controllers.oidc.Callback]: login from %s failed: %s", a.Ctx.Input.IP(), err)
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(401)
+		a.ServeJSON()
+		return
+	}
+	a.setCookie(sessionCookie, login.IDToken, login.ExpiresAt)
+	a.Redirect(localPath(login.ReturnTo), 302)
+}
+
+// @Title Log out
+// @Description ends the browser session
+// @router /logout [get,post]
+func (a *OIDCController) Logout() {
+	a.setCookie(sessionCookie, "", time.Unix(1, 0))
+	a.Data["json"] = "logged out"
+	a.ServeJSON()
+}
+
+// @Title Current user
+// @Description the user the call is authenticated as
+// @Success 200 {object} models.User
+// @router /me [get]
+func (a *OIDCController) Me() {
+	if a.user == nil {
+		a.Data["json"] = "{ 'msg': 'authentication is disabled' }"
+		a.Ctx.Output.SetStatus(404)
+	} else {
+		a.Data["json"] = a.user
+	}
+	a.ServeJSON()
+}
@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"fmt"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+)
+
+// Probe certificate authority
+type PKIController struct {
+	authController
+}
+
+func (k *PKIController) URLMapping() {
+	k.Mapping("CACertificate", k.CACertificate)
+	k.Mapping("CRL", k.CRL)
+}
+
+// @Title CA certificate
+// @Description certificate of the probe CA, client certificates of probes are verified with it
+// @Success 200 {string} ca.crt
+// @router /ca.crt [get]
+func (k *PKIController) CACertificate() {
+	cert, err := models.ProbeCACertificate()
+	if err != nil {
+		k.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		k.Ctx.Output.SetStatus(404)
+		k.ServeJSON()
+		return
+	}
+	k.Ctx.Output.Header("Content-Type", "application/x-pem-file")
+	k.Ctx.Output.Body([]byte(cert))
+}
+
+// @Title Certificate revocation list
+// @Description client certificates of probes revoked and not expired yet, in DER
+// @Success 200 {string} crl
+// @router /crl [get]
+func (k *PKIController) CRL() {
+	crl, err := models.ProbeCRL()
+	if err != nil {
+		k.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		k.Ctx.Output.SetStatus(404)
+		k.ServeJSON()
+		return
+	}
+	k.Ctx.Output.Header("Content-Type", "application/pkix-crl")
+	k.Ctx.Output.Body(crl)
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 
 	"fmt"
+	"strings"
+	"time"
 
 	"bitbucket.org/fseros/sinker_registry_api/models"
 	log "github.com/Sirupsen/logrus"
@@ -12,7 +14,7 @@ import (
 
 // Operations about probe
 type ProbeController struct {
-	beego.Controller
+	authController
 }
 
 func (p *ProbeController) URLMapping() {
@@ -24,7 +26,7 @@ func (p *ProbeController) URLMapping() {
 }
 
 // @Title Create Probe
-// @Description create new probe
+// @Description create new probe, along with its client certificate and private key when the probe CA is configured
 // @Success 201 {object} models.Probe
 // @Param  fqdn  body string true "fqdn address of the probe"
 // @Param  ipv4  body string true "ipv4 address of the probe"
@@ -34,13 +36,21 @@ func (p *ProbeController) URLMapping() {
 // @Param  geolatitude  body float false "geolongitude of the probe"
 // @Param  sshprivatekey  body string false "ssh private key of the probe"
 // @Param  sshpublickey  body string false "ssh public key of the probe"
+// @Param  sshhostkey  body string false "ssh host public key of the probe, in authorized_keys format"
 // @Param  enabled  body bool false "probe status" "true"
+// @Param  region  body string false "provider region of the probe"
+// @Param  zone  body string false "provider zone of the probe"
+// @Param  instanceid  body string false "cloud instance id of the probe"
+// @Param  instancetype  body string false "cloud instance type of the probe"
+// @Param  monthlycost  body float false "monthly cost of the probe instance"
+// @Param  labels  body object false "labels of the probe, as key/value pairs"
 // @router / [post]
 func (p *ProbeController) Post() {
 	var pr models.Probe
 	pr.SetDefaults()
 	json.Unmarshal(p.Ctx.Input.RequestBody, &pr)
 	log.Debugf(" received %v via POST", pr)
+	p.requireScope(&pr)
 	probeid, err := models.AddOne(pr)
 	if err != nil {
 		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
@@ -48,11 +58,144 @@ func (p *ProbeController) Post() {
 		p.ServeJSON()
 		return
 	}
-	p.Data["json"] = map[string]string{"ProbeId": probeid}
+	p.Data["json"] = registered(probeid)
 	p.Ctx.Output.SetStatus(201)
 	p.ServeJSON()
 }
 
+// registered is the response to the registration of a probe, its ID along
+// with its client certificate when the probe CA is configured
+func registered(ProbeID string) map[string]string {
+	ob := map[string]string{"ProbeId": ProbeID}
+	if models.ProbeCAConfigured() {
+		credentials, err := models.IssueProbeCertificate(ProbeID)
+		if err != nil {
+			log.Errorf("[controllers.probe.registered]: unable to issue a certificate to probe %s: %s", ProbeID, err)
+		} else {
+			ob["certificate"] = credentials.Certificate
+			ob["privatekey"] = credentials.PrivateKey
+			ob["cacertificate"] = credentials.CACertificate
+		}
+	}
+	return ob
+}
+
+// @Title Import Probes
+// @Description registers many probes at once, all of them or none
+// @Success 201 {object} models.ImportResult
+// @Failure 422 {object} models.ImportResult with the rows that failed
+// @Param  body  body string true "probes as csv with a header row, json array or ndjson"
+// @Param  format  query string false "csv, json or ndjson, guessed from Content-Type and content by default"
+// @Param  dryrun  query bool false "only validate the probes"
+// @router /import [post]
+func (p *ProbeController) Import() {
+	body := p.Ctx.Input.RequestBody
+	format := p.GetString("format", models.DetectImportFormat(p.Ctx.Input.Header("Content-Type"), body))
+	dryRun, _ := p.GetBool("dryrun", false)
+	rows, err := models.ParseImport(format, body)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	}
+	result, err := models.Import(rows, dryRun, scope(&p.Controller))
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(500)
+		p.ServeJSON()
+		return
+	}
+	p.Data["json"] = result
+	switch {
+	case result.OutOfScope > 0:
+		p.Ctx.Output.SetStatus(403)
+	case result.Failed > 0:
+		p.Ctx.Output.SetStatus(422)
+	case !dryRun:
+		p.Ctx.Output.SetStatus(201)
+	}
+	p.ServeJSON()
+}
+
+// @Title Enable probes
+// @Description enables the probes given by id, group or filter, reporting which ones changed
+// @Success 200 {object} models.BulkResult
+// @Param  ids  body []string false "probe ids"
+// @Param  group  body string false "name of a group"
+// @Param  filter  body models.ProbeFilter false "filter of the probes, of every state unless given one"
+// @Param  atomic  body bool false "change every probe or none"
+// @router /bulk/enable [post]
+func (p *ProbeController) BulkEnable() {
+	p.bulk(models.BulkEnable)
+}
+
+// @Title Disable probes
+// @Description disables the probes given by id, group or filter, reporting which ones changed
+// @Success 200 {object} models.BulkResult
+// @Param  ids  body []string false "probe ids"
+// @Param  group  body string false "name of a group"
+// @Param  filter  body models.ProbeFilter false "filter of the probes, of every state unless given one"
+// @Param  atomic  body bool false "change every probe or none"
+// @router /bulk/disable [post]
+func (p *ProbeController) BulkDisable() {
+	p.bulk(models.BulkDisable)
+}
+
+// @Title Delete probes
+// @Description deletes the probes given by id, group or filter, reporting which ones changed
+// @Success 200 {object} models.BulkResult
+// @Param  ids  body []string false "probe ids"
+// @Param  group  body string false "name of a group"
+// @Param  filter  body models.ProbeFilter false "filter of the probes, of every state unless given one"
+// @Param  atomic  body bool false "change every probe or none"
+// @router /bulk/delete [post]
+func (p *ProbeController) BulkDelete() {
+	p.bulk(models.BulkDelete)
+}
+
+// @Title Set the traces path of probes
+// @Description sets the traces path of the probes given by id, group or filter, reporting which ones changed
+// @Success 200 {object} models.BulkResult
+// @Param  tracespath  body string true "traces path"
+// @Param  ids  body []string false "probe ids"
+// @Param  group  body string false "name of a group"
+// @Param  filter  body models.ProbeFilter false "filter of the probes, of every state unless given one"
+// @Param  atomic  body bool false "change every probe or none"
+// @router /bulk/set-tracespath [post]
+func (p *ProbeController) BulkSetTracesPath() {
+	p.bulk(models.BulkSetTracesPath)
+}
+
+// bulk runs a bulk action, responding 207 when some probes failed and 422
+// when an atomic action was rolled back
+func (p *ProbeController) bulk(action string) {
+	var request models.BulkRequest
+	if err := json.Unmarshal(p.Ctx.Input.RequestBody, &request); err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	}
+	request.Scope = scope(&p.Controller)
+	result, err := models.Bulk(action, request)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	}
+	log.Infof("[controllers.probe.bulk]: %s changed %d probes, %d failed", action, len(result.Changed), result.Failed)
+	p.Data["json"] = result
+	switch {
+	case result.RolledBack:
+		p.Ctx.Output.SetStatus(422)
+	case result.Failed > 0:
+		p.Ctx.Output.SetStatus(207)
+	}
+	p.ServeJSON()
+}
+
 // @router /:id [get]
 func (p *ProbeController) Get() {
 	ProbeID := getIDbyQueryParamOrAsAParam(p)
@@ -67,16 +210,63 @@ func (p *ProbeController) Get() {
 	p.ServeJSON()
 }
 
+// @Title List probes
+// @Description list probes, optionally as a GeoJSON FeatureCollection
+// @Success 200 {object} models.Probe
+// @Param  provider  query string false "only probes of this provider"
+// @Param  country  query string false "only probes located in this country"
+// @Param  state  query string false "enabled (default), disabled or all"
+// @Param  region  query string false "only probes in this provider region"
+// @Param  zone  query string false "only probes in this provider zone"
+// @Param  instancetype  query string false "only probes of this instance type"
+// @Param  selector  query string false "only probes matching this label selector, like env=prod,flavour in (ssh,telnet),!legacy"
+// @Param  format  query string false "json (default) or geojson"
 // @router / [get]
 func (p *ProbeController) GetAll() {
-	obs := models.GetAll()
-	p.Data["json"] = obs
+	filter := getProbeFilter(&p.Controller)
+	obs, err := models.Find(filter)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	}
+	switch p.GetString("format") {
+	case "", "json":
+		p.Data["json"] = obs
+	case "geojson":
+		p.Data["json"] = models.ToGeoJSON(obs)
+	default:
+		p.Data["json"] = fmt.Sprintf("{ 'msg': 'unknown format %s' }", p.GetString("format"))
+		p.Ctx.Output.SetStatus(400)
+	}
 	p.ServeJSON()
 }
 
+func getProbeFilter(c *beego.Controller) models.ProbeFilter {
+	return models.ProbeFilter{
+		Provider:     c.GetString("provider"),
+		Country:      c.GetString("country"),
+		State:        c.GetString("state"),
+		Region:       c.GetString("region"),
+		Zone:         c.GetString("zone"),
+		InstanceType: c.GetString("instancetype"),
+		Selector:     c.GetString("selector"),
+		Scope:        scope(c),
+	}
+}
+
+// @Param  selector  query string false "only probes matching this label selector"
 // @router /ip/:ip [get]
 func (p *ProbeController) GetByIP() {
 	probeIP := p.Ctx.Input.Param(":ip")
+	selector, err := models.ParseLabelSelector(p.GetString("selector"))
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	}
 	fmt.Printf("Looking for probes with ip %s", probeIP)
 	if probeIP != "" {
 		obs, err := models.GetByIPv4(probeIP)
@@ -84,7 +274,7 @@ func (p *ProbeController) GetByIP() {
 			fmt.Printf("Found \n %+v", obs)
 			newobs := make([]models.Probe, 0)
 			for _, ob := range obs {
-				if ob.Enabled {
+				if ob.Enabled && p.sees(&ob) && selector.Matches(ob.Labels) {
 					newobs = append(newobs, ob)
 				}
 			}
@@ -101,9 +291,17 @@ func (p *ProbeController) GetByIP() {
 	}
 }
 
+// @Param  selector  query string false "only probes matching this label selector"
 // @router /name/:fqdn [get]
 func (p *ProbeController) GetByFQDN() {
 	probeName := p.Ctx.Input.Param(":fqdn")
+	selector, err := models.ParseLabelSelector(p.GetString("selector"))
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	}
 	fmt.Printf("Looking for probes with name %s", probeName)
 	if probeName != "" {
 		obs, err := models.GetByFQDN(probeName)
@@ -111,7 +309,7 @@ func (p *ProbeController) GetByFQDN() {
 			fmt.Printf("Found \n %+v", obs)
 			newobs := make([]models.Probe, 0)
 			for _, ob := range obs {
-				if ob.Enabled {
+				if ob.Enabled && p.sees(&ob) && selector.Matches(ob.Labels) {
 					newobs = append(newobs, ob)
 				}
 			}
@@ -128,6 +326,85 @@ func (p *ProbeController) GetByFQDN() {
 	}
 }
 
+// @Title Nearest probes
+// @Description enabled probes ordered by distance to a point
+// @Success 200 {object} models.ProbeDistance
+// @Param  lat  query float true "latitude of the point"
+// @Param  lon  query float true "longitude of the point"
+// @Param  radius_km  query float false "maximum distance in kilometers"
+// @Param  limit  query int false "maximum number of probes returned"
+// @router /near [get]
+func (p *ProbeController) Near() {
+	lat, errLat := p.GetFloat("lat")
+	lon, errLon := p.GetFloat("lon")
+	if errLat != nil || errLon != nil {
+		p.Data["json"] = "{ 'msg': 'lat and lon query parameters are required' }"
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	}
+	radius, err := p.GetFloat("radius_km", 0)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': 'invalid radius_km %s' }", p.GetString("radius_km"))
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	}
+	limit, err := p.GetInt("limit", 0)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': 'invalid limit %s' }", p.GetString("limit"))
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	}
+	// probes out of scope are dropped before limiting the result
+	all, err := models.GetNear(lat, lon, radius, 0)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	}
+	obs := make([]models.ProbeDistance, 0)
+	for _, ob := range all {
+		if p.sees(&ob.Probe) && (limit <= 0 || len(obs) < limit) {
+			obs = append(obs, ob)
+		}
+	}
+	p.Data["json"] = obs
+	p.ServeJSON()
+}
+
+// @Title Probes within a bounding box
+// @Description enabled probes located inside a map viewport
+// @Success 200 {object} models.Probe
+// @Param  bbox  query string true "minLon,minLat,maxLon,maxLat"
+// @router /within [get]
+func (p *ProbeController) Within() {
+	box, err := models.ParseBoundingBox(p.GetString("bbox"))
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	}
+	within, err := models.GetWithin(box)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(500)
+		p.ServeJSON()
+		return
+	}
+	obs := make([]models.Probe, 0)
+	for _, ob := range within {
+		if p.sees(&ob) {
+			obs = append(obs, ob)
+		}
+	}
+	p.Data["json"] = obs
+	p.ServeJSON()
+}
+
 // @router /disable/?:id [put]
 func (p *ProbeController) Disable() {
 	ProbeID := getIDbyQueryParamOrAsAParam(p)
@@ -211,6 +488,350 @@ func (p *ProbeController) UploadSSH() {
 	p.ServeJSON()
 }
 
+// @Title Verifies DNS records
+// @Description checks that the probe FQDN resolves to its addresses and that they point back to it
+// @Success 200 {object} models.DNSCheck
+// @Param  id  path string true "probe id"
+// @router /:id/verify-dns [post]
+func (p *ProbeController) VerifyDNS() {
+	ProbeID := p.GetString(":id")
+	log.Infof("[controllers.probe.VerifyDNS]: verifying dns records of probe %s", ProbeID)
+	ob, verification, err := models.VerifyDNS(ProbeID)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(404)
+	} else {
+		p.Data["json"] = models.DNSCheck{Probe: ob, Verification: verification}
+	}
+	p.ServeJSON()
+}
+
+// hostKeysRequest is the body of PUT /:id/hostkeys
+type hostKeysRequest struct {
+	HostKeys []string `json:"hostkeys"`
+}
+
+// @Title Registers ssh host keys
+// @Description pins the host public keys the probe ssh server presents, replacing those pinned before, changed keys are alerted
+// @Success 200 {object} []models.ProbeHostKey
+// @Param  id  path string true "probe id"
+// @Param  hostkeys  body []string true "ssh host public keys of the probe, in authorized_keys format, one per type"
+// @router /:id/hostkeys [put]
+func (p *ProbeController) SetHostKeys() {
+	ProbeID := p.GetString(":id")
+	log.Infof("[controllers.probe.SetHostKeys]: registering ssh host keys for probe %s", ProbeID)
+	var req hostKeysRequest
+	if err := json.Unmarshal(p.Ctx.Input.RequestBody, &req); err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	}
+	if _, err := models.GetByID(ProbeID); err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(404)
+		p.ServeJSON()
+		return
+	}
+	obs, err := models.SetHostKeys(ProbeID, req.HostKeys)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+	} else {
+		p.Data["json"] = obs
+	}
+	p.ServeJSON()
+}
+
+// @Title Gets ssh host keys
+// @Description host public keys pinned for the probe, with their fingerprints
+// @Success 200 {object} []models.ProbeHostKey
+// @Param  id  path string true "probe id"
+// @router /:id/hostkeys [get]
+func (p *ProbeController) GetHostKeys() {
+	ProbeID := p.GetString(":id")
+	if _, err := models.GetByID(ProbeID); err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(404)
+		p.ServeJSON()
+		return
+	}
+	obs, err := models.GetHostKeys(ProbeID)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(500)
+	} else {
+		if obs == nil {
+			obs = []*models.ProbeHostKey{}
+		}
+		p.Data["json"] = obs
+	}
+	p.ServeJSON()
+}
+
+// @Title Audits ssh host keys
+// @Description host key updates, changes and scan mismatches of the probe, as persisted in the change feed, oldest first
+// @Success 200 {object} []models.ProbeChange
+// @Param  id  path string true "probe id"
+// @router /:id/hostkeys/changes [get]
+func (p *ProbeController) GetHostKeyChanges() {
+	ProbeID := p.GetString(":id")
+	if _, err := models.GetByID(ProbeID); err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(404)
+		p.ServeJSON()
+		return
+	}
+	obs, err := models.GetHostKeyChanges(ProbeID)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(500)
+	} else {
+		p.Data["json"] = obs
+	}
+	p.ServeJSON()
+}
+
+// @Title Scans ssh host keys
+// @Description connects to the probe ssh server and compares the host keys it presents with the pinned ones, pinning unknown types in TOFU mode
+// @Success 200 {object} []models.HostKeyScan
+// @Param  id  path string true "probe id"
+// @router /:id/hostkeys/scan [post]
+func (p *ProbeController) ScanHostKeys() {
+	ProbeID := p.GetString(":id")
+	log.Infof("[controllers.probe.ScanHostKeys]: scanning ssh host keys of probe %s", ProbeID)
+	if _, err := models.GetByID(ProbeID); err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(404)
+		p.ServeJSON()
+		return
+	}
+	obs, err := models.ScanHostKeys(ProbeID)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(502)
+	} else {
+		p.Data["json"] = obs
+	}
+	p.ServeJSON()
+}
+
+// @Title Stages an ssh key rotation
+// @Description stages a new keypair handed out along with the current one until promoted, an ed25519 one is generated when none is given
+// @Success 201 {object} models.KeyRotation
+// @Param  id  path string true "probe id"
+// @Param  sshprivatekey  body string false "base64 encoded ssh private key to stage"
+// @Param  sshpublickey  body string false "base64 encoded ssh public key to stage"
+// @router /:id/ssh/rotation [post]
+func (p *ProbeController) StageKey() {
+	ProbeID := p.GetString(":id")
+	if _, err := models.GetByID(ProbeID); err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(404)
+		p.ServeJSON()
+		return
+	}
+	var pr models.Probe
+	json.Unmarshal(p.Ctx.Input.RequestBody, &pr)
+	ob, err := models.StageKey(ProbeID, pr.SSHPrivateKey, pr.SSHPublicKey)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(409)
+	} else {
+		p.Data["json"] = ob
+		p.Ctx.Output.SetStatus(201)
+	}
+	p.ServeJSON()
+}
+
+// @Title Gets the staged ssh key rotation
+// @Description public key staged for the probe and when it may be promoted
+// @Success 200 {object} models.KeyRotation
+// @Param  id  path string true "probe id"
+// @router /:id/ssh/rotation [get]
+func (p *ProbeController) GetKeyRotation() {
+	ob, err := models.GetKeyRotation(p.GetString(":id"))
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(404)
+	} else {
+		p.Data["json"] = ob
+	}
+	p.ServeJSON()
+}
+
+// @Title Promotes the staged ssh key
+// @Description replaces the probe ssh keys with the staged ones, retiring the former ones
+// @Success 200 {object} models.Probe
+// @Param  id  path string true "probe id"
+// @Param  force  query bool false "promote during the grace period"
+// @router /:id/ssh/rotation/promote [post]
+func (p *ProbeController) PromoteKey() {
+	ProbeID := p.GetString(":id")
+	if _, err := models.GetKeyRotation(ProbeID); err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(404)
+		p.ServeJSON()
+		return
+	}
+	force, _ := p.GetBool("force", false)
+	ob, err := models.PromoteKey(ProbeID, force)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(409)
+	} else {
+		p.Data["json"] = ob
+	}
+	p.ServeJSON()
+}
+
+// @Title Cancels the staged ssh key rotation
+// @Description discards the staged keypair, the current one is kept
+// @Success 204
+// @Param  id  path string true "probe id"
+// @router /:id/ssh/rotation [delete]
+func (p *ProbeController) CancelKeyRotation() {
+	if err := models.CancelKeyRotation(p.GetString(":id")); err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(404)
+		p.ServeJSON()
+		return
+	}
+	p.Ctx.Output.SetStatus(204)
+}
+
+// @Title Label a probe
+// @Description adds labels to the probe, replacing the values of the keys it already has
+// @Success 200 {object} models.Probe
+// @Param  id  path string true "probe id"
+// @Param  body  body models.Labels true "labels as key/value pairs"
+// @router /:id/labels [put]
+func (p *ProbeController) SetLabels() {
+	var labels models.Labels
+	if err := json.Unmarshal(p.Ctx.Input.RequestBody, &labels); err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	}
+	ob, err := models.SetLabels(p.GetString(":id"), labels)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+	} else {
+		p.Data["json"] = ob
+	}
+	p.ServeJSON()
+}
+
+// @Title Remove labels
+// @Description removes labels of the probe by key
+// @Success 200 {object} models.Probe
+// @Param  id  path string true "probe id"
+// @Param  keys  query string true "keys of the labels removed, separated by commas"
+// @router /:id/labels [delete]
+func (p *ProbeController) RemoveLabels() {
+	var keys []string
+	for _, key := range strings.Split(p.GetString("keys"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		p.Data["json"] = "{ 'msg': 'keys is missing' }"
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	}
+	ob, err := models.RemoveLabels(p.GetString(":id"), keys)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+	} else {
+		p.Data["json"] = ob
+	}
+	p.ServeJSON()
+}
+
+// @Title Issues a client certificate
+// @Description issues a client certificate of the probe CA to the probe, revoking the former ones, its private key is only shown in the response
+// @Success 201 {object} models.ProbeCredentials
+// @Param  id  path string true "probe id"
+// @router /:id/certificate [post]
+func (p *ProbeController) IssueCertificate() {
+	ob, err := models.IssueProbeCertificate(p.GetString(":id"))
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+	} else {
+		p.Data["json"] = ob
+		p.Ctx.Output.SetStatus(201)
+	}
+	p.ServeJSON()
+}
+
+// @Title Client certificates
+// @Description client certificates issued to the probe, newest first
+// @Success 200 {object} []models.ProbeCertificate
+// @Param  id  path string true "probe id"
+// @router /:id/certificates [get]
+func (p *ProbeController) GetCertificates() {
+	obs, err := models.GetProbeCertificates(p.GetString(":id"))
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(500)
+	} else {
+		p.Data["json"] = obs
+	}
+	p.ServeJSON()
+}
+
+// @Title Revokes the client certificates
+// @Description revokes the client certificates of the probe, a new one must be issued for it to call the registry again
+// @Success 204
+// @Param  id  path string true "probe id"
+// @router /:id/certificate [delete]
+func (p *ProbeController) RevokeCertificates() {
+	if _, err := models.RevokeProbeCertificates(p.GetString(":id"), models.RevokedManually); err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(500)
+		p.ServeJSON()
+		return
+	}
+	p.Ctx.Output.SetStatus(204)
+}
+
+// @Title Stale ssh keys
+// @Description enabled probes whose ssh keys are older than the maximum key age, oldest first
+// @Success 200 {object} []models.StaleKey
+// @Param  maxage  query int false "maximum key age in days, sshkeymaxage by default"
+// @router /keys/stale [get]
+func (p *ProbeController) StaleKeys() {
+	maxAge := models.KeyMaxAge()
+	if days, err := p.GetInt("maxage", 0); err != nil || days < 0 {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': 'invalid maxage %s' }", p.GetString("maxage"))
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	} else if days > 0 {
+		maxAge = time.Duration(days) * 24 * time.Hour
+	}
+	all, err := models.GetStaleKeys(maxAge)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(500)
+	} else {
+		obs := make([]*models.StaleKey, 0)
+		for _, ob := range all {
+			if probe, err := models.GetByID(ob.ProbeID); err == nil && p.sees(probe) {
+				obs = append(obs, ob)
+			}
+		}
+		p.Data["json"] = obs
+	}
+	p.ServeJSON()
+}
+
 // @Title Gets ssh key
 // @Description updates ssh key
 // @Success 200 {object} models.Probe
@@ -225,7 +846,12 @@ func (p *ProbeController) GetSSH() {
 		if err != nil {
 			p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
 		} else {
-			p.Data["json"] = map[string]string{"ProbeId": ProbeID, "SSHPrivateKey": keys.Private, "SSHPublicKey": keys.Public}
+			ob := map[string]string{"ProbeId": ProbeID, "SSHPrivateKey": keys.Private, "SSHPublicKey": keys.Public}
+			if keys.StagedPublic != "" {
+				ob["StagedSSHPrivateKey"] = keys.StagedPrivate
+				ob["StagedSSHPublicKey"] = keys.StagedPublic
+			}
+			p.Data["json"] = ob
 
 		}
 	}
@@ -238,6 +864,7 @@ func (p *ProbeController) Put() {
 
 	json.Unmarshal(p.Ctx.Input.RequestBody, &pr)
 	log.Debugf(" received %v via POST", pr)
+	p.requireScope(&pr)
 	probeid, err := models.AddOne(pr)
 	if err != nil {
 		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Operations about providers
+type ProviderController struct {
+	authController
+}
+
+func (p *ProviderController) URLMapping() {
+	p.Mapping("Post", p.Post)
+	p.Mapping("Get", p.Get)
+	p.Mapping("GetAll", p.GetAll)
+	p.Mapping("Put", p.Put)
+	p.Mapping("Delete", p.Delete)
+}
+
+// @Title Create Provider
+// @Description create new provider
+// @Success 201 {object} models.Provider
+// @Param  slug  body string true "short identifier of the provider"
+// @Param  name  body string true "display name of the provider"
+// @Param  asns  body []int false "autonomous systems of the provider"
+// @Param  regions  body []string false "regions of the provider"
+// @router / [post]
+func (p *ProviderController) Post() {
+	var pr models.Provider
+	if err := json.Unmarshal(p.Ctx.Input.RequestBody, &pr); err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	}
+	log.Debugf(" received %v via POST", pr)
+	ob, err := models.AddProvider(pr)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	}
+	p.Data["json"] = ob
+	p.Ctx.Output.SetStatus(201)
+	p.ServeJSON()
+}
+
+// @router / [get]
+func (p *ProviderController) GetAll() {
+	obs, err := models.GetAllProviders()
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(500)
+	} else {
+		p.Data["json"] = obs
+	}
+	p.ServeJSON()
+}
+
+// @router /:slug [get]
+func (p *ProviderController) Get() {
+	slug := p.Ctx.Input.Param(":slug")
+	ob, err := models.GetProvider(slug)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(404)
+	} else {
+		p.Data["json"] = ob
+	}
+	p.ServeJSON()
+}
+
+// @Title Update Provider
+// @Description replaces name, asns and regions of a provider
+// @Success 200 {object} models.Provider
+// @Param  name  body string true "display name of the provider"
+// @Param  asns  body []int false "autonomous systems of the provider"
+// @Param  regions  body []string false "regions of the provider"
+// @router /:slug [put]
+func (p *ProviderController) Put() {
+	slug := p.Ctx.Input.Param(":slug")
+	log.Infof("[controllers.provider.Put]: updating provider %s", slug)
+	var pr models.Provider
+	if err := json.Unmarshal(p.Ctx.Input.RequestBody, &pr); err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+		p.ServeJSON()
+		return
+	}
+	ob, err := models.UpdateProvider(slug, pr)
+	if err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+	} else {
+		p.Data["json"] = ob
+	}
+	p.ServeJSON()
+}
+
+// @router /:slug [delete]
+func (p *ProviderController) Delete() {
+	slug := p.Ctx.Input.Param(":slug")
+	log.Infof("[controllers.provider.Delete]: deleting provider %s", slug)
+	if err := models.DeleteProvider(slug); err != nil {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		p.Ctx.Output.SetStatus(400)
+	} else {
+		p.Data["json"] = fmt.Sprintf("{ 'msg': 'deleted provider %s' }", slug)
+	}
+	p.ServeJSON()
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego/context"
+)
+
+// secretRoutes are the routes returning probe secrets or credentials, by
+// method, they are given the secret budget. Probe reads are not, the ssh
+// private key of a probe is only returned by its ssh route.
+var secretRoutes = map[string][]*regexp.Regexp{
+	"GET": {
+		regexp.MustCompile(`^/v1/probe/ssh(/|$)`),
+		regexp.MustCompile(`^/v1/auth/callback$`),
+	},
+	"POST": {
+		regexp.MustCompile(`^/v1/probe/[^/]+/certificate$`),
+		regexp.MustCompile(`^/v1/ssh/certificates$`),
+		regexp.MustCompile(`^/v1/admin/users/?$`),
+		regexp.MustCompile(`^/v1/admin/users/[^/]+/token$`),
+		regexp.MustCompile(`^/v1/admin/export$`),
+		regexp.MustCompile(`^/v1/admin/enrollments$`),
+		regexp.MustCompile(`^/v1/webhooks/?$`),
+		regexp.MustCompile(`^/v1/enroll/?$`),
+	},
+}
+
+// RateLimitIPFactor is how many times the budget of an API key the budget of
+// a source IP is, several callers may share one
+var RateLimitIPFactor = 1
+
+// rateClass returns the budget a call is charged to
+func rateClass(method string, path string) string {
+	for _, route := range secretRoutes[method] {
+		if route.MatchString(path) {
+			return models.RateSecret
+		}
+	}
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return models.RateRead
+	}
+	return models.RateWrite
+}
+
+// rateKeys returns the buckets a call is charged to, the one of its source IP
+// and the one of its API key, session or client certificate if any
+func rateKeys(ctx *context.Context) []string {
+	keys := []string{"ip:" + sourceIP(ctx)}
+	if state := ctx.Request.TLS; state != nil && len(state.VerifiedChains) > 0 {
+		return append(keys, "probe:"+state.VerifiedChains[0][0].Subject.CommonName)
+	}
+	token := strings.TrimPrefix(ctx.Input.Header("Authorization"), "Bearer ")
+	if token == "" {
+		token = ctx.GetCookie(sessionCookie)
+	}
+	if token != "" {
+		sum := sha256.Sum256([]byte(token))
+		keys = append(keys, "key:"+hex.EncodeToString(sum[:8]))
+	}
+	return keys
+}
+
+// RateLimit is a filter refusing the calls of callers who spent the budget
+// of the class of the call, the ones of a source IP share a budget
+// RateLimitIPFactor times larger. Calls are let through when the buckets
+// cannot be read.
+func RateLimit(ctx *context.Context) {
+	if !models.RateLimitEnabled() {
+		return
+	}
+	class := rateClass(ctx.Input.Method(), ctx.Input.URL())
+	keys := rateKeys(ctx)
+	charges := make([]models.RateCharge, len(keys))
+	for i, key := range keys {
+		charges[i] = models.RateCharge{Key: key, Scale: 1}
+		if strings.HasPrefix(key, "ip:") {
+			charges[i].Scale = RateLimitIPFactor
+		}
+	}
+	// a refused call is charged to none of its buckets
+	allowed, wait, err := models.TakeRates(class, charges)
+	if err != nil {
+		log.Errorf("[controllers.ratelimit.RateLimit]: %s", err)
+		return
+	}
+	if allowed {
+		return
+	}
+	log.Warningf("[controllers.ratelimit.RateLimit]: %v spent their %s budget, refusing %s %s", keys, class, ctx.Input.Method(), ctx.Input.URL())
+	seconds := int(math.Ceil(wait.Seconds()))
+	ctx.Output.Header("Retry-After", strconv.Itoa(seconds))
+	ctx.Output.SetStatus(429)
+	ctx.Output.JSON(fmt.Sprintf("{ 'msg': 'too many %s calls, retry in %d seconds' }", class, seconds), false, false)
+}
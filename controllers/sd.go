@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"fmt"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+	"github.com/astaxie/beego"
+)
+
+// Service discovery for monitoring systems
+type ServiceDiscoveryController struct {
+	authController
+}
+
+func (s *ServiceDiscoveryController) URLMapping() {
+	s.Mapping("Prometheus", s.Prometheus)
+}
+
+// @Title Prometheus service discovery
+// @Description enabled probes as Prometheus http_sd_config targets
+// @Success 200 {object} models.PrometheusTargetGroup
+// @Param  port  query int false "port to scrape, prometheusport from app.conf by default"
+// @Param  provider  query string false "only probes of this provider"
+// @Param  country  query string false "only probes located in this country"
+// @Param  selector  query string false "only probes matching this label selector"
+// @Param  region  query string false "only probes in this provider region"
+// @router /prometheus [get]
+func (s *ServiceDiscoveryController) Prometheus() {
+	port, err := s.GetInt("port", beego.AppConfig.DefaultInt("prometheusport", 9100))
+	if err != nil || port < 1 || port > 65535 {
+		s.Data["json"] = fmt.Sprintf("{ 'msg': 'invalid port %s' }", s.GetString("port"))
+		s.Ctx.Output.SetStatus(400)
+		s.ServeJSON()
+		return
+	}
+	filter := getProbeFilter(&s.Controller)
+	filter.State = models.StateEnabled
+	obs, err := models.Find(filter)
+	if err != nil {
+		s.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		s.Ctx.Output.SetStatus(400)
+		s.ServeJSON()
+		return
+	}
+	s.Data["json"] = models.ToPrometheusTargets(obs, port)
+	s.ServeJSON()
+}
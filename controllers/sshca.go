@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+	log "github.com/Sirupsen/logrus"
+)
+
+// SSH certificate authority
+type SSHController struct {
+	authController
+}
+
+func (s *SSHController) URLMapping() {
+	s.Mapping("CAPublicKey", s.CAPublicKey)
+	s.Mapping("Issue", s.Issue)
+	s.Mapping("Certificates", s.Certificates)
+}
+
+// @Title CA public key
+// @Description public key of the registry CA, for the TrustedUserCAKeys file of the probes
+// @Success 200 {string} ca.pub
+// @router /ca.pub [get]
+func (s *SSHController) CAPublicKey() {
+	key, err := models.CAPublicKey()
+	if err != nil {
+		s.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		s.Ctx.Output.SetStatus(404)
+		s.ServeJSON()
+		return
+	}
+	s.Ctx.Output.Header("Content-Type", "text/plain; charset=utf-8")
+	s.Ctx.Output.Body([]byte(key + "\n"))
+}
+
+// @Title Issue a certificate
+// @Description signs a short lived user certificate whose principals are the FQDNs of the requested probes, probes list their FQDN in their AuthorizedPrincipalsFile
+// @Success 201 {object} models.SignedCertificate
+// @Param  publickey  body string true "ssh public key to certify, in authorized_keys format"
+// @Param  probes  body []string true "ids of the probes the certificate grants access to"
+// @Param  ttl  body int false "validity in minutes, sshcertttl by default"
+// @Param  requester  body string true "who the certificate is issued to, the authenticated user when auth is enabled"
+// @router /certificates [post]
+func (s *SSHController) Issue() {
+	var request models.CertificateRequest
+	if err := json.Unmarshal(s.Ctx.Input.RequestBody, &request); err != nil {
+		s.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		s.Ctx.Output.SetStatus(400)
+		s.ServeJSON()
+		return
+	}
+	request.RemoteAddr = s.Ctx.Input.IP()
+	if s.user != nil {
+		request.Requester = s.user.Name
+		for _, ProbeID := range request.ProbeIDs {
+			if probe, err := models.GetByID(ProbeID); err == nil && !s.sees(probe) {
+				s.refuse(403, fmt.Sprintf("probe %s is outside the scope of user %s", ProbeID, s.user.Name))
+				return
+			}
+		}
+	}
+	log.Infof("[controllers.sshca.Issue]: %s from %s requests a certificate for %v", request.Requester, request.RemoteAddr, request.ProbeIDs)
+	ob, err := models.IssueCertificate(request)
+	if err != nil {
+		s.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		s.Ctx.Output.SetStatus(400)
+	} else {
+		s.Data["json"] = ob
+		s.Ctx.Output.SetStatus(201)
+	}
+	s.ServeJSON()
+}
+
+// @Title Issuance log
+// @Description latest certificates issued, newest first
+// @Success 200 {object} []models.Certificate
+// @Param  limit  query int false "number of certificates, 50 by default"
+// @router /certificates [get]
+func (s *SSHController) Certificates() {
+	limit, err := s.GetInt("limit", 50)
+	if err != nil || limit < 1 {
+		s.Data["json"] = "{ 'msg': 'invalid limit' }"
+		s.Ctx.Output.SetStatus(400)
+		s.ServeJSON()
+		return
+	}
+	obs, err := models.GetCertificates(limit)
+	if err != nil {
+		s.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		s.Ctx.Output.SetStatus(500)
+	} else {
+		s.Data["json"] = obs
+	}
+	s.ServeJSON()
+}
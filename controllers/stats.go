@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"fmt"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+)
+
+// Fleet statistics
+type StatsController struct {
+	authController
+}
+
+func (s *StatsController) URLMapping() {
+	s.Mapping("Get", s.Get)
+}
+
+// @Title Fleet statistics
+// @Description probe counts by provider, country, region and registration month
+// @Success 200 {object} models.FleetStats
+// @router / [get]
+func (s *StatsController) Get() {
+	stats, err := models.GetStats(scope(&s.Controller))
+	if err != nil {
+		s.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		s.Ctx.Output.SetStatus(500)
+	} else {
+		s.Data["json"] = stats
+	}
+	s.ServeJSON()
+}
@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+)
+
+// @Title Roles
+// @Description roles along with the permissions they grant
+// @Success 200 {object} map[string][]string
+// @router /roles [get]
+func (a *AdminController) Roles() {
+	a.Data["json"] = models.RolePermissions
+	a.ServeJSON()
+}
+
+// @Title Users
+// @Description every user of the registry
+// @Success 200 {object} []models.User
+// @router /users [get]
+func (a *AdminController) Users() {
+	obs, err := models.GetAllUsers()
+	if err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(500)
+	} else {
+		a.Data["json"] = obs
+	}
+	a.ServeJSON()
+}
+
+// @Title Add a user
+// @Description creates a user, its token is only shown in the response
+// @Success 201 {object} models.UserToken
+// @Param  name  body string true "name of the user"
+// @Param  role  body string true "viewer, operator or admin"
+// @Param  team  body int false "team of the user"
+// @router /users [post]
+func (a *AdminController) AddUser() {
+	var user models.User
+	if err := json.Unmarshal(a.Ctx.Input.RequestBody, &user); err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(400)
+		a.ServeJSON()
+		return
+	}
+	ob, err := models.AddUser(user.Name, user.Role, user.Team)
+	if err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(400)
+	} else {
+		a.Data["json"] = ob
+		a.Ctx.Output.SetStatus(201)
+	}
+	a.ServeJSON()
+}
+
+// @Title Get a user
+// @Success 200 {object} models.User
+// @router /users/:id [get]
+func (a *AdminController) GetUser() {
+	id, err := a.GetInt64(":id")
+	if err == nil {
+		var ob *models.User
+		if ob, err = models.GetUser(id); err == nil {
+			a.Data["json"] = ob
+			a.ServeJSON()
+			return
+		}
+	}
+	a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+	a.Ctx.Output.SetStatus(404)
+	a.ServeJSON()
+}
+
+// @Title Assign a role
+// @Description assigns the role and team of a user
+// @Success 200 {object} models.User
+// @Param  role  body string true "viewer, operator or admin"
+// @Param  team  body int false "team of the user, none when missing"
+// @router /users/:id [put]
+func (a *AdminController) UpdateUser() {
+	var user models.User
+	id, err := a.GetInt64(":id")
+	if err == nil {
+		err = json.Unmarshal(a.Ctx.Input.RequestBody, &user)
+	}
+	if err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(400)
+		a.ServeJSON()
+		return
+	}
+	ob, err := models.UpdateUser(id, user.Role, user.Team)
+	if err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(400)
+	} else {
+		a.Data["json"] = ob
+	}
+	a.ServeJSON()
+}
+
+// @Title Reset a token
+// @Description issues a new token to a user, the former one stops working
+// @Success 200 {object} models.UserToken
+// @router /users/:id/token [post]
+func (a *AdminController) ResetToken() {
+	id, err := a.GetInt64(":id")
+	if err == nil {
+		var ob *models.UserToken
+		if ob, err = models.ResetToken(id); err == nil {
+			a.Data["json"] = ob
+			a.ServeJSON()
+			return
+		}
+	}
+	a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+	a.Ctx.Output.SetStatus(400)
+	a.ServeJSON()
+}
+
+// @Title Delete a user
+// @router /users/:id [delete]
+func (a *AdminController) DeleteUser() {
+	id, err := a.GetInt64(":id")
+	if err == nil {
+		err = models.DeleteUser(id)
+	}
+	if err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(400)
+	} else {
+		a.Data["json"] = "delete success!"
+	}
+	a.ServeJSON()
+}
+
+// @Title Teams
+// @Description every team along with the providers it is restricted to
+// @Success 200 {object} []models.Team
+// @router /teams [get]
+func (a *AdminController) Teams() {
+	obs, err := models.GetAllTeams()
+	if err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(500)
+	} else {
+		a.Data["json"] = obs
+	}
+	a.ServeJSON()
+}
+
+// @Title Add a team
+// @Description creates a team, its users only see the probes of its providers and matching its label selector when there are some
+// @Success 201 {object} models.Team
+// @Param  name  body string true "name of the team"
+// @Param  providers  body []string false "providers the team is restricted to"
+// @Param  selector  body string false "label selector the team is restricted to, like env=prod"
+// @router /teams [post]
+func (a *AdminController) AddTeam() {
+	var team models.Team
+	if err := json.Unmarshal(a.Ctx.Input.RequestBody, &team); err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(400)
+		a.ServeJSON()
+		return
+	}
+	ob, err := models.AddTeam(team)
+	if err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(400)
+	} else {
+		a.Data["json"] = ob
+		a.Ctx.Output.SetStatus(201)
+	}
+	a.ServeJSON()
+}
+
+// @Title Update a team
+// @Description renames a team and replaces the providers and label selector it is restricted to
+// @Success 200 {object} models.Team
+// @router /teams/:id [put]
+func (a *AdminController) UpdateTeam() {
+	var team models.Team
+	id, err := a.GetInt64(":id")
+	if err == nil {
+		err = json.Unmarshal(a.Ctx.Input.RequestBody, &team)
+	}
+	if err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(400)
+		a.ServeJSON()
+		return
+	}
+	ob, err := models.UpdateTeam(id, team)
+	if err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(400)
+	} else {
+		a.Data["json"] = ob
+	}
+	a.ServeJSON()
+}
+
+// @Title Delete a team
+// @Description removes a team that has no users left
+// @router /teams/:id [delete]
+func (a *AdminController) DeleteTeam() {
+	id, err := a.GetInt64(":id")
+	if err == nil {
+		err = models.DeleteTeam(id)
+	}
+	if err != nil {
+		a.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		a.Ctx.Output.SetStatus(400)
+	} else {
+		a.Data["json"] = "delete success!"
+	}
+	a.ServeJSON()
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Webhook subscriptions to probe lifecycle events
+type WebhookController struct {
+	authController
+}
+
+func (w *WebhookController) URLMapping() {
+	w.Mapping("Post", w.Post)
+	w.Mapping("Get", w.Get)
+	w.Mapping("GetAll", w.GetAll)
+	w.Mapping("Put", w.Put)
+	w.Mapping("Delete", w.Delete)
+	w.Mapping("Deliveries", w.Deliveries)
+	w.Mapping("Redeliver", w.Redeliver)
+}
+
+// @Title Create Webhook
+// @Description subscribes an url to probe events, the response is the only one showing the secret
+// @Success 201 {object} models.Webhook
+// @Param  url  body string true "http or https url events are POSTed to"
+// @Param  secret  body string false "key of the X-Sinker-Signature HMAC-SHA256, generated when empty"
+// @Param  events  body []string false "events to deliver, all of them when empty"
+// @Param  active  body bool false "whether events are delivered, true when missing"
+// @router / [post]
+func (w *WebhookController) Post() {
+	var wh models.Webhook
+	wh.SetDefaults()
+	if err := json.Unmarshal(w.Ctx.Input.RequestBody, &wh); err != nil {
+		w.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		w.Ctx.Output.SetStatus(400)
+		w.ServeJSON()
+		return
+	}
+	ob, err := models.AddWebhook(wh)
+	if err != nil {
+		w.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		w.Ctx.Output.SetStatus(400)
+		w.ServeJSON()
+		return
+	}
+	w.Data["json"] = ob
+	w.Ctx.Output.SetStatus(201)
+	w.ServeJSON()
+}
+
+// @router / [get]
+func (w *WebhookController) GetAll() {
+	obs, err := models.GetAllWebhooks()
+	if err != nil {
+		w.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		w.Ctx.Output.SetStatus(500)
+	} else {
+		redacted := make([]*models.Webhook, len(obs))
+		for i, ob := range obs {
+			redacted[i] = ob.Redacted()
+		}
+		w.Data["json"] = redacted
+	}
+	w.ServeJSON()
+}
+
+// @router /:id [get]
+func (w *WebhookController) Get() {
+	id, _ := w.GetInt64(":id")
+	ob, err := models.GetWebhook(id)
+	if err != nil {
+		w.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		w.Ctx.Output.SetStatus(404)
+	} else {
+		w.Data["json"] = ob.Redacted()
+	}
+	w.ServeJSON()
+}
+
+// @Title Update Webhook
+// @Description replaces url, events and active flag of a webhook, and its secret when given
+// @Success 200 {object} models.Webhook
+// @Param  url  body string true "http or https url events are POSTed to"
+// @Param  secret  body string false "new key of the X-Sinker-Signature HMAC-SHA256"
+// @Param  events  body []string false "events to deliver, all of them when empty"
+// @Param  active  body bool false "whether events are delivered, unchanged when missing"
+// @router /:id [put]
+func (w *WebhookController) Put() {
+	id, _ := w.GetInt64(":id")
+	log.Infof("[controllers.webhook.Put]: updating webhook %d", id)
+	current, err := models.GetWebhook(id)
+	if err != nil {
+		w.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		w.Ctx.Output.SetStatus(404)
+		w.ServeJSON()
+		return
+	}
+	// the active flag is kept unless the request sets it
+	wh := models.Webhook{Active: current.Active}
+	if err := json.Unmarshal(w.Ctx.Input.RequestBody, &wh); err != nil {
+		w.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		w.Ctx.Output.SetStatus(400)
+		w.ServeJSON()
+		return
+	}
+	ob, err := models.UpdateWebhook(id, wh)
+	if err != nil {
+		w.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		w.Ctx.Output.SetStatus(400)
+	} else {
+		w.Data["json"] = ob.Redacted()
+	}
+	w.ServeJSON()
+}
+
+// @router /:id [delete]
+func (w *WebhookController) Delete() {
+	id, _ := w.GetInt64(":id")
+	log.Infof("[controllers.webhook.Delete]: deleting webhook %d", id)
+	if err := models.DeleteWebhook(id); err != nil {
+		w.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		w.Ctx.Output.SetStatus(404)
+	} else {
+		w.Data["json"] = fmt.Sprintf("{ 'msg': 'deleted webhook %d' }", id)
+	}
+	w.ServeJSON()
+}
+
+// @Title Delivery log
+// @Description latest deliveries of a webhook, newest first
+// @Success 200 {object} []models.WebhookDelivery
+// @Param  limit  query int false "number of deliveries, 50 by default"
+// @router /:id/deliveries [get]
+func (w *WebhookController) Deliveries() {
+	id, _ := w.GetInt64(":id")
+	limit, err := w.GetInt("limit", 50)
+	if err != nil || limit < 1 {
+		w.Data["json"] = "{ 'msg': 'invalid limit' }"
+		w.Ctx.Output.SetStatus(400)
+		w.ServeJSON()
+		return
+	}
+	obs, err := models.GetDeliveries(id, limit)
+	if err != nil {
+		w.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		w.Ctx.Output.SetStatus(404)
+	} else {
+		w.Data["json"] = obs
+	}
+	w.ServeJSON()
+}
+
+// @Title Redeliver
+// @Description sends the payload of a past delivery again
+// @Success 202 {object} models.WebhookDelivery
+// @router /:id/deliveries/:delivery/redeliver [post]
+func (w *WebhookController) Redeliver() {
+	id, _ := w.GetInt64(":id")
+	deliveryID, _ := w.GetInt64(":delivery")
+	log.Infof("[controllers.webhook.Redeliver]: redelivering %d to webhook %d", deliveryID, id)
+	ob, err := models.Redeliver(id, deliveryID)
+	if err != nil {
+		w.Data["json"] = fmt.Sprintf("{ 'msg': '%s' }", err.Error())
+		w.Ctx.Output.SetStatus(404)
+	} else {
+		w.Data["json"] = ob
+		w.Ctx.Output.SetStatus(202)
+	}
+	w.ServeJSON()
+}
@@ -0,0 +1,278 @@
+// Package ddns publishes address records through RFC 2136 dynamic updates,
+// signed with TSIG (RFC 8945) when a key is configured.
+package ddns
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	typeA    = 1
+	typeSOA  = 6
+	typeAAAA = 28
+	typeTSIG = 250
+
+	classIN  = 1
+	classANY = 255
+
+	opcodeUpdate = 5
+	tsigFudge    = 300
+)
+
+var rcodeNames = map[int]string{
+	1: "FORMERR", 2: "SERVFAIL", 3: "NXDOMAIN", 4: "NOTIMP", 5: "REFUSED",
+	6: "YXDOMAIN", 7: "YXRRSET", 8: "NXRRSET", 9: "NOTAUTH", 10: "NOTZONE",
+	16: "BADSIG", 17: "BADKEY", 18: "BADTIME",
+}
+
+var algorithms = map[string]func() hash.Hash{
+	"hmac-sha256.": sha256.New,
+	"hmac-sha512.": sha512.New,
+}
+
+// Key is a TSIG key shared with the DNS server
+type Key struct {
+	Name      string
+	Algorithm string
+	Secret    []byte
+}
+
+// Client sends dynamic updates for names of a single zone
+type Client struct {
+	Server  string
+	Zone    string
+	Key     *Key
+	Timeout time.Duration
+}
+
+// RRSet is the set of addresses a name should resolve to, an empty set
+// removes the name A and AAAA records
+type RRSet struct {
+	Name string
+	TTL  uint32
+	IPv4 []net.IP
+	IPv6 []net.IP
+}
+
+// Fqdn returns name with a trailing dot
+func Fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// InZone reports whether name belongs to the client zone
+func (c *Client) InZone(name string) bool {
+	name = strings.ToLower(Fqdn(name))
+	zone := strings.ToLower(Fqdn(c.Zone))
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// Replace sets the A and AAAA records of every set, removing whatever
+// addresses those names had before
+func (c *Client) Replace(sets ...RRSet) error {
+	for _, set := range sets {
+		if !c.InZone(set.Name) {
+			return fmt.Errorf("%s is not in zone %s", set.Name, c.Zone)
+		}
+	}
+	msg, id, err := c.buildUpdate(sets)
+	if err != nil {
+		return err
+	}
+	var mac []byte
+	if c.Key != nil {
+		if msg, mac, err = sign(msg, c.Key, nil, time.Now()); err != nil {
+			return err
+		}
+	}
+	resp, err := c.exchange(msg)
+	if err != nil {
+		return err
+	}
+	return c.checkResponse(resp, id, mac)
+}
+
+func (c *Client) buildUpdate(sets []RRSet) ([]byte, uint16, error) {
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return nil, 0, err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	updates := 0
+	var body []byte
+	for _, set := range sets {
+		name, err := packName(set.Name)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, rrtype := range []uint16{typeA, typeAAAA} {
+			body = appendRR(body, name, rrtype, classANY, 0, nil)
+			updates++
+		}
+		for _, ip := range set.IPv4 {
+			if ip.To4() == nil {
+				return nil, 0, fmt.Errorf("invalid ipv4 address %s", ip)
+			}
+			body = appendRR(body, name, typeA, classIN, set.TTL, ip.To4())
+			updates++
+		}
+		for _, ip := range set.IPv6 {
+			if ip.To16() == nil || ip.To4() != nil {
+				return nil, 0, fmt.Errorf("invalid ipv6 address %s", ip)
+			}
+			body = appendRR(body, name, typeAAAA, classIN, set.TTL, ip.To16())
+			updates++
+		}
+	}
+
+	zone, err := packName(c.Zone)
+	if err != nil {
+		return nil, 0, err
+	}
+	msg := make([]byte, 12, 12+len(zone)+4+len(body))
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], opcodeUpdate<<11)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	binary.BigEndian.PutUint16(msg[8:], uint16(updates))
+	msg = append(msg, zone...)
+	msg = appendUint16(msg, typeSOA)
+	msg = appendUint16(msg, classIN)
+	msg = append(msg, body...)
+	return msg, id, nil
+}
+
+func (c *Client) exchange(msg []byte) ([]byte, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	conn, err := net.DialTimeout("udp", c.Server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func (c *Client) checkResponse(resp []byte, id uint16, requestMAC []byte) error {
+	if len(resp) < 12 {
+		return errors.New("truncated dns response")
+	}
+	if binary.BigEndian.Uint16(resp) != id {
+		return errors.New("dns response id does not match the update")
+	}
+	flags := binary.BigEndian.Uint16(resp[2:])
+	if flags&0x8000 == 0 || int(flags>>11)&0xF != opcodeUpdate {
+		return errors.New("unexpected dns message in response to the update")
+	}
+	if rcode := int(flags & 0xF); rcode != 0 {
+		return fmt.Errorf("dns update refused with %s", rcodeName(rcode))
+	}
+	if c.Key != nil {
+		if err := verify(resp, c.Key, requestMAC, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rcodeName(rcode int) string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("rcode %d", rcode)
+}
+
+// packName encodes name in uncompressed, lowercase wire format
+func packName(name string) ([]byte, error) {
+	name = strings.ToLower(Fqdn(name))
+	if len(name) > 254 {
+		return nil, fmt.Errorf("domain name too long %s", name)
+	}
+	var wire []byte
+	if name != "." {
+		for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("invalid domain name %s", name)
+			}
+			wire = append(wire, byte(len(label)))
+			wire = append(wire, label...)
+		}
+	}
+	return append(wire, 0), nil
+}
+
+// unpackName decodes the name at off, following compression pointers, and
+// returns it in wire format along with the offset following it
+func unpackName(msg []byte, off int) ([]byte, int, error) {
+	var wire []byte
+	end := -1
+	for hops := 0; hops < 64; hops++ {
+		if off >= len(msg) {
+			return nil, 0, errors.New("truncated domain name")
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			wire = append(wire, 0)
+			if end < 0 {
+				end = off + 1
+			}
+			return wire, end, nil
+		case length&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return nil, 0, errors.New("truncated compression pointer")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		default:
+			if off+1+length > len(msg) {
+				return nil, 0, errors.New("truncated label")
+			}
+			wire = append(wire, byte(length))
+			wire = append(wire, strings.ToLower(string(msg[off+1:off+1+length]))...)
+			off += 1 + length
+		}
+	}
+	return nil, 0, errors.New("too many compression pointers")
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendRR(b []byte, name []byte, rrtype uint16, class uint16, ttl uint32, rdata []byte) []byte {
+	b = append(b, name...)
+	b = appendUint16(b, rrtype)
+	b = appendUint16(b, class)
+	b = appendUint32(b, ttl)
+	b = appendUint16(b, uint16(len(rdata)))
+	return append(b, rdata...)
+}
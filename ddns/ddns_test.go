@@ -0,0 +1,122 @@
+package ddns
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer answers a single update with rcode, signing the response with
+// key when it is not nil, and reports the record types it was asked to add
+// or remove
+func fakeServer(t *testing.T, key *Key, rcode uint16) (string, chan []uint16, chan error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := make(chan []uint16, 1)
+	errs := make(chan error, 1)
+	go func() {
+		defer conn.Close()
+		buf := make([]byte, 65535)
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			errs <- err
+			return
+		}
+		req := buf[:n]
+		var requestMAC []byte
+		if key != nil {
+			if err := verify(req, key, nil, time.Now()); err != nil {
+				errs <- err
+				return
+			}
+			tsig, _ := findTSIG(req)
+			requestMAC = tsig.mac
+		}
+		errs <- nil
+
+		_, off, _ := unpackName(req, 12)
+		off += 4
+		zoneEnd := off
+		var seen []uint16
+		for i := 0; i < int(binary.BigEndian.Uint16(req[8:])); i++ {
+			_, typeOff, _ := unpackName(req, off)
+			seen = append(seen, binary.BigEndian.Uint16(req[typeOff:]))
+			off, _ = skipRR(req, off)
+		}
+		types <- seen
+
+		resp := append([]byte{}, req[:zoneEnd]...)
+		binary.BigEndian.PutUint16(resp[2:], 0x8000|opcodeUpdate<<11|rcode)
+		binary.BigEndian.PutUint16(resp[8:], 0)
+		binary.BigEndian.PutUint16(resp[10:], 0)
+		if key != nil {
+			resp, _, _ = sign(resp, key, requestMAC, time.Now())
+		}
+		conn.WriteTo(resp, addr)
+	}()
+	return conn.LocalAddr().String(), types, errs
+}
+
+func TestReplace(t *testing.T) {
+	key := &Key{Name: "sinker-key", Algorithm: "hmac-sha256", Secret: []byte("0123456789abcdef")}
+	set := RRSet{
+		Name: "probe1.probes.example.com",
+		TTL:  300,
+		IPv4: []net.IP{net.ParseIP("192.0.2.10")},
+		IPv6: []net.IP{net.ParseIP("2001:db8::10")},
+	}
+
+	t.Run("signed update is verified by the server and its response by the client", func(t *testing.T) {
+		server, types, errs := fakeServer(t, key, 0)
+		client := &Client{Server: server, Zone: "probes.example.com", Key: key, Timeout: time.Second}
+		if err := client.Replace(set); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errs; err != nil {
+			t.Fatalf("server rejected the update: %s", err)
+		}
+		if got, want := <-types, []uint16{typeA, typeAAAA, typeA, typeAAAA}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("updated types %v, expected %v", got, want)
+		}
+	})
+	t.Run("empty set only removes records", func(t *testing.T) {
+		server, types, _ := fakeServer(t, nil, 0)
+		client := &Client{Server: server, Zone: "probes.example.com", Timeout: time.Second}
+		if err := client.Replace(RRSet{Name: set.Name}); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := <-types, []uint16{typeA, typeAAAA}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("updated types %v, expected %v", got, want)
+		}
+	})
+	t.Run("refused updates are reported", func(t *testing.T) {
+		server, _, _ := fakeServer(t, key, 5)
+		client := &Client{Server: server, Zone: "probes.example.com", Key: key, Timeout: time.Second}
+		if err := client.Replace(set); err == nil || !strings.Contains(err.Error(), "REFUSED") {
+			t.Fatalf("expected REFUSED, got %v", err)
+		}
+	})
+	t.Run("responses signed with another secret are rejected", func(t *testing.T) {
+		other := &Key{Name: key.Name, Algorithm: key.Algorithm, Secret: []byte("another secret")}
+		server, _, errs := fakeServer(t, other, 0)
+		client := &Client{Server: server, Zone: "probes.example.com", Key: key, Timeout: time.Second}
+		if err := client.Replace(set); err == nil {
+			t.Fatal("expected an error")
+		}
+		if err := <-errs; err == nil {
+			t.Fatal("server accepted a request signed with another secret")
+		}
+	})
+	t.Run("names outside of the zone are not sent", func(t *testing.T) {
+		client := &Client{Server: "127.0.0.1:1", Zone: "probes.example.com"}
+		if err := client.Replace(RRSet{Name: "probe.example.org"}); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
@@ -0,0 +1,206 @@
+package ddns
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// tsigVariables returns the TSIG fields covered by the MAC, besides the
+// message itself
+func tsigVariables(keyName []byte, algName []byte, signed uint64, fudge uint16, tsigError uint16, other []byte) []byte {
+	var vars []byte
+	vars = append(vars, keyName...)
+	vars = appendUint16(vars, classANY)
+	vars = appendUint32(vars, 0)
+	vars = append(vars, algName...)
+	vars = appendUint16(vars, uint16(signed>>32))
+	vars = appendUint32(vars, uint32(signed))
+	vars = appendUint16(vars, fudge)
+	vars = appendUint16(vars, tsigError)
+	vars = appendUint16(vars, uint16(len(other)))
+	return append(vars, other...)
+}
+
+func computeMAC(key *Key, requestMAC []byte, msg []byte, vars []byte) ([]byte, error) {
+	newHash, ok := algorithms[Fqdn(key.Algorithm)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported tsig algorithm %s", key.Algorithm)
+	}
+	m := hmac.New(newHash, key.Secret)
+	if requestMAC != nil {
+		m.Write(appendUint16(nil, uint16(len(requestMAC))))
+		m.Write(requestMAC)
+	}
+	m.Write(msg)
+	m.Write(vars)
+	return m.Sum(nil), nil
+}
+
+// sign appends a TSIG record to msg, requestMAC is the MAC of the request
+// when signing a response
+func sign(msg []byte, key *Key, requestMAC []byte, now time.Time) ([]byte, []byte, error) {
+	keyName, err := packName(key.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	algName, err := packName(key.Algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	signed := uint64(now.Unix())
+	mac, err := computeMAC(key, requestMAC, msg, tsigVariables(keyName, algName, signed, tsigFudge, 0, nil))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rdata []byte
+	rdata = append(rdata, algName...)
+	rdata = appendUint16(rdata, uint16(signed>>32))
+	rdata = appendUint32(rdata, uint32(signed))
+	rdata = appendUint16(rdata, tsigFudge)
+	rdata = appendUint16(rdata, uint16(len(mac)))
+	rdata = append(rdata, mac...)
+	rdata = append(rdata, msg[0:2]...)
+	rdata = appendUint16(rdata, 0)
+	rdata = appendUint16(rdata, 0)
+
+	out := append([]byte{}, msg...)
+	binary.BigEndian.PutUint16(out[10:], binary.BigEndian.Uint16(out[10:])+1)
+	out = appendRR(out, keyName, typeTSIG, classANY, 0, rdata)
+	return out, mac, nil
+}
+
+type tsigRecord struct {
+	start     int
+	keyName   []byte
+	algName   []byte
+	signed    uint64
+	fudge     uint16
+	mac       []byte
+	origID    uint16
+	tsigError uint16
+	other     []byte
+}
+
+func skipRR(msg []byte, off int) (int, error) {
+	_, off, err := unpackName(msg, off)
+	if err != nil {
+		return 0, err
+	}
+	if off+10 > len(msg) {
+		return 0, errors.New("truncated resource record")
+	}
+	end := off + 10 + int(binary.BigEndian.Uint16(msg[off+8:]))
+	if end > len(msg) {
+		return 0, errors.New("truncated resource record data")
+	}
+	return end, nil
+}
+
+// findTSIG returns the TSIG record closing msg, or nil when there is none
+func findTSIG(msg []byte) (*tsigRecord, error) {
+	if len(msg) < 12 {
+		return nil, errors.New("truncated dns message")
+	}
+	qd := int(binary.BigEndian.Uint16(msg[4:]))
+	records := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	if binary.BigEndian.Uint16(msg[10:]) == 0 {
+		return nil, nil
+	}
+	off := 12
+	for i := 0; i < qd; i++ {
+		_, next, err := unpackName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next + 4
+	}
+	var err error
+	for i := 0; i < records-1; i++ {
+		if off, err = skipRR(msg, off); err != nil {
+			return nil, err
+		}
+	}
+
+	tsig := &tsigRecord{start: off}
+	if tsig.keyName, off, err = unpackName(msg, off); err != nil {
+		return nil, err
+	}
+	if off+10 > len(msg) || binary.BigEndian.Uint16(msg[off:]) != typeTSIG {
+		return nil, nil
+	}
+	rdataEnd := off + 10 + int(binary.BigEndian.Uint16(msg[off+8:]))
+	if rdataEnd > len(msg) {
+		return nil, errors.New("truncated tsig record")
+	}
+	if tsig.algName, off, err = unpackName(msg, off+10); err != nil {
+		return nil, err
+	}
+	if off+10 > rdataEnd {
+		return nil, errors.New("truncated tsig record")
+	}
+	tsig.signed = uint64(binary.BigEndian.Uint16(msg[off:]))<<32 | uint64(binary.BigEndian.Uint32(msg[off+2:]))
+	tsig.fudge = binary.BigEndian.Uint16(msg[off+6:])
+	macSize := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	if off+macSize+6 > rdataEnd {
+		return nil, errors.New("truncated tsig record")
+	}
+	tsig.mac = msg[off : off+macSize]
+	off += macSize
+	tsig.origID = binary.BigEndian.Uint16(msg[off:])
+	tsig.tsigError = binary.BigEndian.Uint16(msg[off+2:])
+	otherLen := int(binary.BigEndian.Uint16(msg[off+4:]))
+	off += 6
+	if off+otherLen != rdataEnd {
+		return nil, errors.New("malformed tsig record")
+	}
+	tsig.other = msg[off:rdataEnd]
+	return tsig, nil
+}
+
+// verify checks the TSIG record of msg, requestMAC is the MAC of the
+// request msg answers, or nil when msg is a request
+func verify(msg []byte, key *Key, requestMAC []byte, now time.Time) error {
+	tsig, err := findTSIG(msg)
+	if err != nil {
+		return err
+	}
+	if tsig == nil {
+		return errors.New("dns message is not signed")
+	}
+	if tsig.tsigError != 0 {
+		return fmt.Errorf("tsig signature rejected with %s", rcodeName(int(tsig.tsigError)))
+	}
+	keyName, err := packName(key.Name)
+	if err != nil {
+		return err
+	}
+	algName, err := packName(key.Algorithm)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(tsig.keyName, keyName) || !bytes.Equal(tsig.algName, algName) {
+		return errors.New("dns message signed with an unexpected tsig key")
+	}
+
+	stripped := append([]byte{}, msg[:tsig.start]...)
+	binary.BigEndian.PutUint16(stripped[0:], tsig.origID)
+	binary.BigEndian.PutUint16(stripped[10:], binary.BigEndian.Uint16(stripped[10:])-1)
+	expected, err := computeMAC(key, requestMAC, stripped, tsigVariables(keyName, algName, tsig.signed, tsig.fudge, tsig.tsigError, tsig.other))
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, tsig.mac) {
+		return errors.New("invalid tsig signature")
+	}
+	delta := now.Unix() - int64(tsig.signed)
+	if delta < -int64(tsig.fudge) || delta > int64(tsig.fudge) {
+		return errors.New("tsig signature time out of the allowed window")
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package ddns
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Verification is the outcome of checking a name against the addresses it
+// was registered with
+type Verification struct {
+	// Forward is true when the name resolves to every registered address
+	Forward bool `json:"forward"`
+	// Reverse is true when every registered address points back to the name
+	Reverse bool `json:"reverse"`
+	// Addresses the name resolved to
+	Addresses []string `json:"addresses"`
+	// PTR names of every registered address
+	PTR    map[string][]string `json:"ptr"`
+	Errors []string            `json:"errors,omitempty"`
+}
+
+// NewResolver returns a resolver querying server, host:port, instead of the
+// ones of the system, which are used when server is empty
+func NewResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// Verify checks the forward (A/AAAA) and reverse (PTR) records of name
+// against addrs
+func Verify(ctx context.Context, resolver *net.Resolver, name string, addrs ...net.IP) Verification {
+	fqdn := strings.ToLower(Fqdn(name))
+	v := Verification{PTR: make(map[string][]string)}
+
+	resolved, err := resolver.LookupIPAddr(ctx, fqdn)
+	if err != nil {
+		v.Errors = append(v.Errors, err.Error())
+	}
+	for _, addr := range resolved {
+		v.Addresses = append(v.Addresses, addr.IP.String())
+	}
+	sort.Strings(v.Addresses)
+
+	v.Forward = err == nil && len(addrs) > 0
+	v.Reverse = len(addrs) > 0
+	for _, addr := range addrs {
+		if !containsIP(resolved, addr) {
+			v.Forward = false
+		}
+		names, err := resolver.LookupAddr(ctx, addr.String())
+		if err != nil {
+			v.Errors = append(v.Errors, err.Error())
+		}
+		v.PTR[addr.String()] = names
+		if !containsName(names, fqdn) {
+			v.Reverse = false
+		}
+	}
+	return v
+}
+
+func containsIP(resolved []net.IPAddr, ip net.IP) bool {
+	for _, addr := range resolved {
+		if addr.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsName(names []string, fqdn string) bool {
+	for _, name := range names {
+		if strings.ToLower(Fqdn(name)) == fqdn {
+			return true
+		}
+	}
+	return false
+}
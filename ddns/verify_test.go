@@ -0,0 +1,115 @@
+package ddns
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+const typePTR = 12
+
+// fakeResolver answers A, AAAA and PTR queries from records, keyed by
+// lowercase fqdn and record type, until the test ends
+func fakeResolver(t *testing.T, records map[string]map[uint16][][]byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := buf[:n]
+			wire, off, err := unpackName(req, 12)
+			if err != nil {
+				continue
+			}
+			qtype := binary.BigEndian.Uint16(req[off:])
+			answers := records[wireToName(wire)][qtype]
+
+			resp := append([]byte{}, req[:off+4]...)
+			binary.BigEndian.PutUint16(resp[2:], 0x8180)
+			if records[wireToName(wire)] == nil {
+				binary.BigEndian.PutUint16(resp[2:], 0x8183)
+			}
+			binary.BigEndian.PutUint16(resp[6:], uint16(len(answers)))
+			binary.BigEndian.PutUint16(resp[8:], 0)
+			binary.BigEndian.PutUint16(resp[10:], 0)
+			for _, rdata := range answers {
+				resp = appendRR(resp, wire, qtype, classIN, 60, rdata)
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func wireToName(wire []byte) string {
+	name := ""
+	for i := 0; wire[i] != 0; i += int(wire[i]) + 1 {
+		name += string(wire[i+1:i+1+int(wire[i])]) + "."
+	}
+	return name
+}
+
+func mustPack(t *testing.T, name string) []byte {
+	wire, err := packName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wire
+}
+
+func TestVerify(t *testing.T) {
+	ipv4 := net.ParseIP("192.0.2.10")
+	ipv6 := net.ParseIP("2001:db8::10")
+	records := map[string]map[uint16][][]byte{
+		"probe1.example.com.": {
+			typeA:    {ipv4.To4()},
+			typeAAAA: {ipv6.To16()},
+		},
+		"10.2.0.192.in-addr.arpa.": {
+			typePTR: {mustPack(t, "probe1.example.com")},
+		},
+		"0.1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.": {
+			typePTR: {mustPack(t, "other.example.com")},
+		},
+	}
+	resolver := NewResolver(fakeResolver(t, records))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("forward and reverse records match", func(t *testing.T) {
+		v := Verify(ctx, resolver, "Probe1.example.com", ipv4)
+		if !v.Forward || !v.Reverse {
+			t.Fatalf("expected a match, got %+v", v)
+		}
+	})
+	t.Run("reverse record of another name", func(t *testing.T) {
+		v := Verify(ctx, resolver, "probe1.example.com", ipv4, ipv6)
+		if !v.Forward || v.Reverse {
+			t.Fatalf("expected only the forward records to match, got %+v", v)
+		}
+		if names := v.PTR[ipv6.String()]; len(names) != 1 || names[0] != "other.example.com." {
+			t.Fatalf("unexpected ptr records %v", names)
+		}
+	})
+	t.Run("address missing from the forward records", func(t *testing.T) {
+		v := Verify(ctx, resolver, "probe1.example.com", net.ParseIP("192.0.2.11"))
+		if v.Forward || v.Reverse {
+			t.Fatalf("expected no match, got %+v", v)
+		}
+	})
+	t.Run("unknown name", func(t *testing.T) {
+		v := Verify(ctx, resolver, "missing.example.com", ipv4)
+		if v.Forward || len(v.Errors) == 0 {
+			t.Fatalf("expected a lookup error, got %+v", v)
+		}
+	})
+}
@@ -1,12 +1,54 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/base64"
+	"os"
+	"strings"
+	"time"
+
+	"bitbucket.org/fseros/sinker_registry_api/cli"
+	"bitbucket.org/fseros/sinker_registry_api/controllers"
+	"bitbucket.org/fseros/sinker_registry_api/ddns"
+	"bitbucket.org/fseros/sinker_registry_api/models"
 	_ "bitbucket.org/fseros/sinker_registry_api/routers"
 	log "github.com/Sirupsen/logrus"
 	"github.com/astaxie/beego"
 )
 
 func main() {
+	models.DatabaseDriver = beego.AppConfig.DefaultString("dbdriver", models.DatabaseDriver)
+	models.DataSource = beego.AppConfig.DefaultString("datasource", models.DataSource)
+	if handled, code := cli.Run(os.Args[1:]); handled {
+		os.Exit(code)
+	}
+	models.Init()
+	configureDNS()
+	configureBackups()
+	configureSSHCA()
+	models.ConfigureAuth(beego.AppConfig.DefaultBool("auth", false))
+	configureOIDC()
+	configureProbeCA()
+	configureTLS()
+	controllers.TrustProxy = beego.AppConfig.DefaultBool("trustproxy", false)
+	configureRateLimits()
+	models.ConfigureEnrollment(
+		time.Duration(beego.AppConfig.DefaultInt("enrollttl", 60))*time.Minute,
+		time.Duration(beego.AppConfig.DefaultInt("enrollmaxttl", 24))*time.Hour)
+	models.ConfigureDNSVerification(beego.AppConfig.String("dnsresolver"), beego.AppConfig.DefaultBool("dnsverifyonregister", false))
+	models.ConfigureHostKeyScan(
+		beego.AppConfig.DefaultString("sshkeyscan", "ssh-keyscan"),
+		beego.AppConfig.DefaultInt("sshport", 22),
+		beego.AppConfig.DefaultBool("sshhostkeytofu", true))
+	models.ConfigureKeyRotation(
+		time.Duration(beego.AppConfig.DefaultInt("sshkeygrace", 24))*time.Hour,
+		time.Duration(beego.AppConfig.DefaultInt("sshkeymaxage", 90))*24*time.Hour)
+	models.ConfigureChangeRetention(time.Duration(beego.AppConfig.DefaultInt("changeretention", 30)) * 24 * time.Hour)
+	models.ConfigureWebhooks(
+		beego.AppConfig.DefaultInt("webhookmaxattempts", 6),
+		time.Duration(beego.AppConfig.DefaultInt("webhookbackoff", 2))*time.Second,
+		time.Duration(beego.AppConfig.DefaultInt("webhooktimeout", 10))*time.Second,
+	)
 	if beego.BConfig.RunMode == "dev" {
 		beego.BConfig.WebConfig.DirectoryIndex = true
 		beego.BConfig.WebConfig.StaticDir["/swagger"] = "swagger"
@@ -14,3 +56,146 @@ func main() {
 	beego.Run()
 	log.SetLevel(log.WarnLevel)
 }
+
+// configureDNS enables dynamic DNS updates when dnszone is set in app.conf
+func configureDNS() {
+	zone := beego.AppConfig.String("dnszone")
+	if zone == "" {
+		return
+	}
+	client := &ddns.Client{
+		Server:  beego.AppConfig.DefaultString("dnsserver", "127.0.0.1:53"),
+		Zone:    zone,
+		Timeout: 5 * time.Second,
+	}
+	if name := beego.AppConfig.String("dnstsigname"); name != "" {
+		secret, err := base64.StdEncoding.DecodeString(beego.AppConfig.String("dnstsigsecret"))
+		if err != nil {
+			log.Fatalf("invalid dnstsigsecret, expected base64: %s", err)
+		}
+		client.Key = &ddns.Key{
+			Name:      name,
+			Algorithm: beego.AppConfig.DefaultString("dnstsigalgorithm", "hmac-sha256"),
+			Secret:    secret,
+		}
+	}
+	models.ConfigureDNS(client, uint32(beego.AppConfig.DefaultInt("dnsttl", 300)))
+	log.Infof("publishing probe records of zone %s to %s", zone, client.Server)
+}
+
+// configureSSHCA enables the ssh certificate authority when sshcakey is set
+// in app.conf
+func configureSSHCA() {
+	path := beego.AppConfig.String("sshcakey")
+	if path == "" {
+		return
+	}
+	ttl := time.Duration(beego.AppConfig.DefaultInt("sshcertttl", 5)) * time.Minute
+	maxTTL := time.Duration(beego.AppConfig.DefaultInt("sshcertmaxttl", 60)) * time.Minute
+	if err := models.ConfigureSSHCA(path, ttl, maxTTL); err != nil {
+		log.Fatalf("unable to configure the ssh certificate authority: %s", err)
+	}
+	log.Infof("issuing ssh certificates signed by %s, valid %s by default", path, ttl)
+}
+
+// configureOIDC accepts the tokens of an OpenID Connect issuer when
+// oidcissuer is set in app.conf, oidcgrouproles maps its groups to roles as
+// a list of group:role pairs
+func configureOIDC() {
+	issuer := beego.AppConfig.String("oidcissuer")
+	if issuer == "" {
+		return
+	}
+	groupRoles := make(map[string]string)
+	for _, pair := range beego.AppConfig.Strings("oidcgrouproles") {
+		group := strings.TrimSpace(pair)
+		role := ""
+		if i := strings.LastIndex(group, ":"); i > 0 {
+			group, role = strings.TrimSpace(group[:i]), strings.TrimSpace(group[i+1:])
+		}
+		groupRoles[group] = role
+	}
+	err := models.ConfigureOIDC(models.OIDCConfig{
+		Issuer:        issuer,
+		ClientID:      beego.AppConfig.String("oidcclientid"),
+		ClientSecret:  beego.AppConfig.String("oidcclientsecret"),
+		RedirectURL:   beego.AppConfig.String("oidcredirecturl"),
+		Audience:      beego.AppConfig.String("oidcaudience"),
+		Scopes:        strings.Fields(beego.AppConfig.DefaultString("oidcscopes", "openid profile email groups")),
+		UsernameClaim: beego.AppConfig.DefaultString("oidcusernameclaim", "preferred_username"),
+		GroupsClaim:   beego.AppConfig.DefaultString("oidcgroupsclaim", "groups"),
+		GroupRoles:    groupRoles,
+		AllowNoTeam:   beego.AppConfig.DefaultBool("oidcallownoteam", false),
+		JWKSRefresh:   time.Duration(beego.AppConfig.DefaultInt("oidcjwksrefresh", 60)) * time.Minute,
+	})
+	if err != nil {
+		log.Fatalf("unable to configure oidc: %s", err)
+	}
+	log.Infof("accepting the tokens of the oidc issuer %s", issuer)
+}
+
+// configureProbeCA issues client certificates to probes when probecadir is
+// set in app.conf
+func configureProbeCA() {
+	dir := beego.AppConfig.String("probecadir")
+	if dir == "" {
+		return
+	}
+	validity := time.Duration(beego.AppConfig.DefaultInt("probecertdays", 365)) * 24 * time.Hour
+	if err := models.ConfigureProbeCA(dir, validity); err != nil {
+		log.Fatalf("unable to configure the probe certificate authority: %s", err)
+	}
+	log.Infof("issuing probe client certificates signed by the CA of %s, valid %s", dir, validity)
+}
+
+// configureTLS sets up the HTTPS server beego runs when enablehttps is set
+// in app.conf, it verifies the client certificates of probes if any
+func configureTLS() {
+	if !beego.BConfig.Listen.EnableHTTPS {
+		return
+	}
+	// beego serves http and https with the same server, http2 is only set up
+	// for both when the config offers it upfront
+	config := &tls.Config{MinVersion: tls.VersionTLS12, NextProtos: []string{"h2", "http/1.1"}}
+	if models.ProbeCAConfigured() {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		config.ClientCAs = models.ProbeCAPool()
+	}
+	beego.BeeApp.Server.TLSConfig = config
+}
+
+// configureRateLimits limits the calls of every API key and source IP when
+// ratelimit is set in app.conf, budgets are in calls a minute and may be spent
+// at once
+func configureRateLimits() {
+	if !beego.AppConfig.DefaultBool("ratelimit", false) {
+		return
+	}
+	limits := make(map[string]models.RateLimit)
+	for _, class := range []string{models.RateRead, models.RateWrite, models.RateSecret} {
+		perMinute := beego.AppConfig.DefaultInt("ratelimit"+class, 60)
+		limits[class] = models.RateLimit{PerMinute: perMinute, Burst: perMinute}
+	}
+	store := beego.AppConfig.DefaultString("ratelimitstore", models.RateStoreMemory)
+	if err := models.ConfigureRateLimits(limits, store); err != nil {
+		log.Fatalf("unable to configure rate limits: %s", err)
+	}
+	controllers.RateLimitIPFactor = beego.AppConfig.DefaultInt("ratelimitipfactor", 4)
+	log.Infof("rate limiting calls to %d reads, %d writes and %d secret reads a minute, kept in %s",
+		limits[models.RateRead].PerMinute, limits[models.RateWrite].PerMinute, limits[models.RateSecret].PerMinute, store)
+}
+
+// configureBackups enables periodic online backups when backupdir is set in
+// app.conf
+func configureBackups() {
+	dir := beego.AppConfig.String("backupdir")
+	if dir == "" {
+		return
+	}
+	interval := time.Duration(beego.AppConfig.DefaultInt("backupinterval", 60)) * time.Minute
+	keep := beego.AppConfig.DefaultInt("backupkeep", 24)
+	if err := models.ConfigureBackups(dir, interval, keep); err != nil {
+		log.Fatalf("unable to configure backups: %s", err)
+	}
+	log.Infof("backing up the registry to %s every %s, keeping %d backups", dir, interval, keep)
+}
@@ -0,0 +1,388 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego/orm"
+)
+
+// Roles of the registry users, each one grants the permissions of the
+// previous one
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+// Permissions required by the API actions
+const (
+	PermProbeRead   = "probe:read"
+	PermProbeWrite  = "probe:write"
+	PermProbeSSH    = "probe:ssh"
+	PermProbeDelete = "probe:delete"
+	PermAdmin       = "admin"
+)
+
+// RolePermissions maps every role to the permissions it grants
+var RolePermissions = map[string][]string{
+	RoleViewer:   {PermProbeRead},
+	RoleOperator: {PermProbeRead, PermProbeWrite, PermProbeSSH, PermProbeDelete},
+	RoleAdmin:    {PermProbeRead, PermProbeWrite, PermProbeSSH, PermProbeDelete, PermAdmin},
+}
+
+const tokenPrefix = "snk_"
+
+// Team groups users, a team with providers only sees the probes of those
+// providers, and one with a label selector the probes it matches
+type Team struct {
+	ID        int64     `orm:"pk;auto;column(id)" json:"id"`
+	Name      string    `orm:"size(64);unique" json:"name"`
+	Providers []string  `orm:"-" json:"providers"`
+	Scope     string    `orm:"size(512)" json:"-"`
+	Selector  string    `orm:"size(512)" json:"selector"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (team *Team) pack() error {
+	if _, err := ParseLabelSelector(team.Selector); err != nil {
+		return err
+	}
+	var names []string
+	for _, name := range team.Providers {
+		provider, err := LookupProvider(name)
+		if err != nil {
+			return fmt.Errorf("invalid provider %s", name)
+		}
+		names = append(names, provider.Name)
+	}
+	team.Providers = names
+	team.Scope = strings.Join(names, ",")
+	return nil
+}
+
+func (team *Team) unpack() {
+	team.Providers = []string{}
+	if team.Scope != "" {
+		team.Providers = strings.Split(team.Scope, ",")
+	}
+}
+
+// User is a caller of the API, authenticated by a bearer token of which
+// only the SHA-256 is stored
+type User struct {
+	ID         int64     `orm:"pk;auto;column(id)" json:"id"`
+	Name       string    `orm:"size(64);unique" json:"name"`
+	Role       string    `orm:"size(16)" json:"role"`
+	Team       int64     `orm:"index" json:"team,omitempty"`
+	TokenHash  string    `orm:"size(64);index" json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `orm:"null" json:"last_seen_at"`
+	// Issuer and Subject identify the users of the oidc issuer, they are
+	// empty for the users holding a registry token
+	Issuer  string `orm:"size(256)" json:"issuer,omitempty"`
+	Subject string `orm:"size(256)" json:"subject,omitempty"`
+	// scope are the probes the team of the user sees, nil when the user
+	// sees every probe
+	scope *Scope `orm:"-"`
+}
+
+// TableIndex looks the users of the oidc issuer up by subject
+func (user *User) TableIndex() [][]string {
+	return [][]string{{"Issuer", "Subject"}}
+}
+
+// UserToken is a user along with its token, only shown when issued
+type UserToken struct {
+	*User
+	Token string `json:"token"`
+}
+
+var authEnabled bool
+
+// ConfigureAuth makes every API call require the token of a user whose
+// role grants the permission of the call
+func ConfigureAuth(enabled bool) {
+	authEnabled = enabled
+	if enabled {
+		if count, err := o.QueryTable("user").Count(); err == nil && count == 0 {
+			log.Warningf("[models.auth.ConfigureAuth]: no users, every call will be refused until one is added with the users subcommand")
+		}
+	}
+}
+
+// AuthEnabled reports whether API calls are authenticated
+func AuthEnabled() bool {
+	return authEnabled
+}
+
+func validRole(role string) error {
+	if _, ok := RolePermissions[role]; !ok {
+		return fmt.Errorf("invalid role `%s`, expected %s, %s or %s", role, RoleViewer, RoleOperator, RoleAdmin)
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return tokenPrefix + hex.EncodeToString(raw), nil
+}
+
+// Can reports whether the role of the user grants perm
+func (user *User) Can(perm string) bool {
+	for _, granted := range RolePermissions[user.Role] {
+		if granted == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Scope returns the probes the user sees, nil meaning all
+func (user *User) Scope() *Scope {
+	return user.scope
+}
+
+// Sees reports whether probe is within the scope of the user
+func (user *User) Sees(probe *Probe) bool {
+	return inScope(user.scope, probe)
+}
+
+func (user *User) loadScope() error {
+	scope, err := teamScope(user.Team)
+	user.scope = scope
+	return err
+}
+
+// teamScope returns the probes a team sees, nil for every probe, as for
+// the users without a team
+func teamScope(id int64) (*Scope, error) {
+	if id == 0 {
+		return nil, nil
+	}
+	team, err := GetTeam(id)
+	if err != nil {
+		return nil, err
+	}
+	selector, err := ParseLabelSelector(team.Selector)
+	if err != nil {
+		return nil, err
+	}
+	if len(team.Providers) == 0 && len(selector) == 0 {
+		return nil, nil
+	}
+	return &Scope{Providers: team.Providers, Selector: selector}, nil
+}
+
+// Authenticate returns the user holding token, a registry token or a token
+// of the oidc issuer when configured
+func Authenticate(token string) (*User, error) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		if OIDCEnabled() && token != "" {
+			return authenticateOIDC(token)
+		}
+		return nil, errors.New("invalid token")
+	}
+	user := User{TokenHash: hashToken(token)}
+	if err := o.Read(&user, "TokenHash"); err != nil {
+		return nil, errors.New("invalid token")
+	}
+	if err := user.loadScope(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if now.Sub(user.LastSeenAt) > time.Minute {
+		user.LastSeenAt = now
+		o.Update(&user, "LastSeenAt")
+	}
+	return &user, nil
+}
+
+// AddUser creates a user and returns it along with its token
+func AddUser(name string, role string, team int64) (*UserToken, error) {
+	log.Infof("[models.auth.AddUser]: adding %s user %s", role, name)
+	if name == "" {
+		return nil, errors.New("name is missing")
+	}
+	if err := validRole(role); err != nil {
+		return nil, err
+	}
+	if team != 0 {
+		if _, err := GetTeam(team); err != nil {
+			return nil, err
+		}
+	}
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	user := &User{Name: name, Role: role, Team: team, TokenHash: hashToken(token), CreatedAt: time.Now()}
+	if _, err := o.Insert(user); err != nil {
+		return nil, fmt.Errorf("unable to add user %s: %s", name, err)
+	}
+	return &UserToken{User: user, Token: token}, nil
+}
+
+// GetUser returns a user by id
+func GetUser(id int64) (*User, error) {
+	user := User{ID: id}
+	if err := o.Read(&user); err != nil {
+		if err == orm.ErrNoRows {
+			return nil, fmt.Errorf("user %d not found", id)
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetAllUsers returns every user
+func GetAllUsers() ([]*User, error) {
+	users := []*User{}
+	_, err := o.QueryTable("user").OrderBy("name").Limit(-1).All(&users)
+	return users, err
+}
+
+// UpdateUser assigns the role and team of a user
+func UpdateUser(id int64, role string, team int64) (*User, error) {
+	log.Infof("[models.auth.UpdateUser]: assigning role %s and team %d to user %d", role, team, id)
+	user, err := GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := validRole(role); err != nil {
+		return nil, err
+	}
+	if team != 0 {
+		if _, err := GetTeam(team); err != nil {
+			return nil, err
+		}
+	}
+	user.Role = role
+	user.Team = team
+	if _, err := o.Update(user, "Role", "Team"); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ResetToken replaces the token of a user, the former one stops working
+func ResetToken(id int64) (*UserToken, error) {
+	log.Infof("[models.auth.ResetToken]: issuing a new token to user %d", id)
+	user, err := GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	user.TokenHash = hashToken(token)
+	if _, err := o.Update(user, "TokenHash"); err != nil {
+		return nil, err
+	}
+	return &UserToken{User: user, Token: token}, nil
+}
+
+// DeleteUser removes a user, its token stops working
+func DeleteUser(id int64) error {
+	log.Infof("[models.auth.DeleteUser]: removing user %d", id)
+	user, err := GetUser(id)
+	if err != nil {
+		return err
+	}
+	_, err = o.Delete(user)
+	return err
+}
+
+// AddTeam creates a team, restricted to the probes of its providers and
+// matching its label selector when there are some
+func AddTeam(team Team) (*Team, error) {
+	log.Infof("[models.auth.AddTeam]: adding team %s", team.Name)
+	if team.Name == "" {
+		return nil, errors.New("name is missing")
+	}
+	if err := team.pack(); err != nil {
+		return nil, err
+	}
+	team.CreatedAt = time.Now()
+	if _, err := o.Insert(&team); err != nil {
+		return nil, fmt.Errorf("unable to add team %s: %s", team.Name, err)
+	}
+	return &team, nil
+}
+
+// GetTeam returns a team by id
+func GetTeam(id int64) (*Team, error) {
+	team := Team{ID: id}
+	if err := o.Read(&team); err != nil {
+		if err == orm.ErrNoRows {
+			return nil, fmt.Errorf("team %d not found", id)
+		}
+		return nil, err
+	}
+	team.unpack()
+	return &team, nil
+}
+
+// GetAllTeams returns every team
+func GetAllTeams() ([]*Team, error) {
+	teams := []*Team{}
+	if _, err := o.QueryTable("team").OrderBy("name").Limit(-1).All(&teams); err != nil {
+		return nil, err
+	}
+	for _, team := range teams {
+		team.unpack()
+	}
+	return teams, nil
+}
+
+// UpdateTeam renames a team and replaces its providers and label selector
+func UpdateTeam(id int64, update Team) (*Team, error) {
+	log.Infof("[models.auth.UpdateTeam]: updating team %d", id)
+	team, err := GetTeam(id)
+	if err != nil {
+		return nil, err
+	}
+	if update.Name != "" {
+		team.Name = update.Name
+	}
+	team.Providers = update.Providers
+	team.Selector = update.Selector
+	if err := team.pack(); err != nil {
+		return nil, err
+	}
+	if _, err := o.Update(team, "Name", "Scope", "Selector"); err != nil {
+		return nil, err
+	}
+	return team, nil
+}
+
+// DeleteTeam removes a team that has no users left
+func DeleteTeam(id int64) error {
+	log.Infof("[models.auth.DeleteTeam]: removing team %d", id)
+	team, err := GetTeam(id)
+	if err != nil {
+		return err
+	}
+	if count, err := o.QueryTable("user").Filter("team", id).Count(); err != nil {
+		return err
+	} else if count > 0 {
+		return fmt.Errorf("team %s still has %d users", team.Name, count)
+	}
+	_, err = o.Delete(team)
+	return err
+}
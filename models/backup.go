@@ -0,0 +1,316 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego/orm"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	backupPrefix = "data-"
+	backupSuffix = ".db"
+	// backupStepPages are copied at a time, the database is unlocked for
+	// backupStepPause between steps so that the registry keeps serving
+	backupStepPages = 256
+	backupStepPause = 10 * time.Millisecond
+	// backupStamp names backups after when they were taken, the backups of
+	// former versions were named to the second
+	backupStamp       = "20060102T150405.000Z"
+	backupStampSecond = "20060102T150405Z"
+)
+
+// Backup is a point in time copy of the registry database, taken with the
+// SQLite online backup API
+type Backup struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var (
+	backupMu   sync.Mutex
+	backupDir  string
+	backupKeep int
+)
+
+// ConfigureBackups takes a backup of the registry database to dir every
+// interval, keeping the keep latest ones
+func ConfigureBackups(dir string, interval time.Duration, keep int) error {
+	if DatabaseDriver != "sqlite3" {
+		return fmt.Errorf("online backups need the sqlite3 driver, not %s", DatabaseDriver)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	backupDir = dir
+	backupKeep = keep
+	go func() {
+		for range time.Tick(interval) {
+			if _, err := TakeBackup(); err != nil {
+				log.Errorf("[models.backup]: periodic backup failed: %s", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// BackupsConfigured reports whether backups are enabled
+func BackupsConfigured() bool {
+	return backupDir != ""
+}
+
+// sqliteBackup copies the main database of src into dest a few pages at a
+// time, src remains usable meanwhile
+func sqliteBackup(dest *sql.DB, src *sql.DB) error {
+	ctx := context.Background()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	return destConn.Raw(func(destDriverConn interface{}) error {
+		return srcConn.Raw(func(srcDriverConn interface{}) error {
+			destSQLite, ok := destDriverConn.(*sqlite3.SQLiteConn)
+			srcSQLite, ok2 := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok || !ok2 {
+				return errors.New("online backups need the sqlite3 driver")
+			}
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			for {
+				done, err := backup.Step(backupStepPages)
+				if err != nil {
+					backup.Finish()
+					return err
+				}
+				if done {
+					return backup.Finish()
+				}
+				time.Sleep(backupStepPause)
+			}
+		})
+	})
+}
+
+// copyDatabase backs up the sqlite database at src into dest
+func copyDatabase(dest string, src *sql.DB) error {
+	destDB, err := sql.Open("sqlite3", dest)
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+	return sqliteBackup(destDB, src)
+}
+
+func checksum(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// TakeBackup copies the registry database to a new backup, along with a
+// sha256sum compatible checksum file, and removes the oldest backups
+func TakeBackup() (*Backup, error) {
+	if !BackupsConfigured() {
+		return nil, errors.New("backups are not configured")
+	}
+	backupMu.Lock()
+	defer backupMu.Unlock()
+	src, err := orm.GetDB("default")
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	name := backupPrefix + now.Format(backupStamp) + backupSuffix
+	path := filepath.Join(backupDir, name)
+	// backups are never overwritten, even when the clock goes back
+	for {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		now = now.Add(time.Millisecond)
+		name = backupPrefix + now.Format(backupStamp) + backupSuffix
+		path = filepath.Join(backupDir, name)
+	}
+	tmp := filepath.Join(backupDir, "."+name+".tmp")
+	os.Remove(tmp)
+	if err := copyDatabase(tmp, src); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	os.Chmod(tmp, 0600)
+	sum, size, err := checksum(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path+".sha256", []byte(sum+"  "+name+"\n"), 0600); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	log.Infof("[models.backup.TakeBackup]: backed up the registry to %s", path)
+	rotateBackups()
+	return &Backup{Name: name, Size: size, SHA256: sum, CreatedAt: now}, nil
+}
+
+func rotateBackups() {
+	backups, err := ListBackups(backupDir)
+	if err != nil {
+		log.Errorf("[models.backup.rotateBackups]: %s", err)
+		return
+	}
+	for i := backupKeep; i < len(backups); i++ {
+		path := filepath.Join(backupDir, backups[i].Name)
+		log.Infof("[models.backup.rotateBackups]: removing %s", path)
+		os.Remove(path)
+		os.Remove(path + ".sha256")
+	}
+}
+
+// ListBackups returns the backups of dir, newest first, with the checksum
+// recorded when they were taken
+func ListBackups(dir string) ([]*Backup, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []*Backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix)
+		createdAt, err := time.Parse(backupStamp, stamp)
+		if err != nil {
+			if createdAt, err = time.Parse(backupStampSecond, stamp); err != nil {
+				continue
+			}
+		}
+		backup := &Backup{Name: name, Size: entry.Size(), CreatedAt: createdAt}
+		if content, err := ioutil.ReadFile(filepath.Join(dir, name+".sha256")); err == nil {
+			if fields := strings.Fields(string(content)); len(fields) > 0 {
+				backup.SHA256 = fields[0]
+			}
+		}
+		backups = append(backups, backup)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
+
+// GetBackups returns the backups of the configured directory
+func GetBackups() ([]*Backup, error) {
+	if !BackupsConfigured() {
+		return nil, errors.New("backups are not configured")
+	}
+	return ListBackups(backupDir)
+}
+
+// VerifyBackup checks a backup of dir against its recorded checksum
+func VerifyBackup(dir string, name string) (*Backup, error) {
+	if filepath.Base(name) != name {
+		return nil, fmt.Errorf("invalid backup name %s", name)
+	}
+	backups, err := ListBackups(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, backup := range backups {
+		if backup.Name != name {
+			continue
+		}
+		if backup.SHA256 == "" {
+			return nil, fmt.Errorf("backup %s has no checksum", name)
+		}
+		sum, _, err := checksum(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if sum != backup.SHA256 {
+			return nil, fmt.Errorf("backup %s is corrupted, its checksum is %s instead of %s", name, sum, backup.SHA256)
+		}
+		return backup, nil
+	}
+	return nil, fmt.Errorf("backup %s not found in %s", name, dir)
+}
+
+// RestoreBackup verifies a backup of dir and replaces the sqlite database at
+// target with it, the former database, however damaged, is kept aside with
+// a .before-restore suffix along with its journal and wal files, which may
+// hold changes not in the database file yet. target must not be in use.
+func RestoreBackup(dir string, name string, target string) (*Backup, error) {
+	backup, err := VerifyBackup(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	src, err := sql.Open("sqlite3", "file:"+filepath.Join(dir, name)+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	tmp := target + ".restore.tmp"
+	os.Remove(tmp)
+	if err := copyDatabase(tmp, src); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	os.Chmod(tmp, 0600)
+	suffixes := []string{"", "-journal", "-wal", "-shm"}
+	if _, err := os.Stat(target); err == nil {
+		for _, suffix := range suffixes {
+			os.Remove(target + ".before-restore" + suffix)
+		}
+		for _, suffix := range suffixes {
+			if err := os.Rename(target+suffix, target+".before-restore"+suffix); err != nil && !os.IsNotExist(err) {
+				os.Remove(tmp)
+				return nil, err
+			}
+		}
+	}
+	for _, suffix := range suffixes[1:] {
+		os.Remove(target + suffix)
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return nil, err
+	}
+	log.Infof("[models.backup.RestoreBackup]: restored %s into %s", name, target)
+	return backup, nil
+}
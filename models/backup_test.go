@@ -0,0 +1,118 @@
+package models
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTakeBackupNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ConfigureBackups(dir, time.Hour, 10); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { backupDir = "" }()
+	// a backup of a former version, named to the second
+	if err := ioutil.WriteFile(filepath.Join(dir, "data-20200102T030405Z.db"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	names := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		backup, err := TakeBackup()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names[backup.Name] {
+			t.Fatalf("backup %s taken twice", backup.Name)
+		}
+		names[backup.Name] = true
+		if _, err := VerifyBackup(dir, backup.Name); err != nil {
+			t.Error(err)
+		}
+	}
+	backups, err := GetBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 4 || backups[3].Name != "data-20200102T030405Z.db" {
+		t.Errorf("unexpected backups %v", backups)
+	}
+}
+
+func TestRestoreBackupKeepsWAL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	open := func(path string) *sql.DB {
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.SetMaxOpenConns(1)
+		return db
+	}
+	count := func(path string) int {
+		db := open(path)
+		defer db.Close()
+		var rows int
+		if err := db.QueryRow("SELECT COUNT(*) FROM probe").Scan(&rows); err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+
+	// a backup holding no probes
+	backupDB := open(filepath.Join(dir, "data-20200102T030405.000Z.db"))
+	if _, err := backupDB.Exec("CREATE TABLE probe (id TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	backupDB.Close()
+	sum, _, err := checksum(filepath.Join(dir, "data-20200102T030405.000Z.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "data-20200102T030405.000Z.db.sha256"), []byte(sum+"  data-20200102T030405.000Z.db\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// a database whose last probe is only in its wal file, as when the
+	// registry stopped without checkpointing it
+	live := filepath.Join(dir, "live.db")
+	db := open(live)
+	for _, statement := range []string{"PRAGMA journal_mode=WAL", "PRAGMA wal_autocheckpoint=0", "CREATE TABLE probe (id TEXT)", "INSERT INTO probe VALUES ('a')"} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	target := filepath.Join(dir, "data.db")
+	for _, suffix := range []string{"", "-wal"} {
+		content, err := ioutil.ReadFile(live + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(target+suffix, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	if _, err := RestoreBackup(dir, "data-20200102T030405.000Z.db", target); err != nil {
+		t.Fatal(err)
+	}
+	if rows := count(target); rows != 0 {
+		t.Errorf("restored database holds %d probes, expected none", rows)
+	}
+	if rows := count(target + ".before-restore"); rows != 1 {
+		t.Errorf("former database holds %d probes, expected the one of its wal file", rows)
+	}
+}
@@ -0,0 +1,263 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/asaskevich/govalidator"
+	"github.com/astaxie/beego/orm"
+)
+
+// Bulk actions
+const (
+	BulkEnable        = "enable"
+	BulkDisable       = "disable"
+	BulkDelete        = "delete"
+	BulkSetTracesPath = "set-tracespath"
+)
+
+// bulkMaxProbes bounds the probes a single bulk action changes
+const bulkMaxProbes = 1000
+
+// BulkRequest targets the probes of a bulk action by ID, by group or by
+// filter, exactly one of them. An atomic action changes every probe or none.
+type BulkRequest struct {
+	IDs        []string     `json:"ids"`
+	Group      string       `json:"group"`
+	Filter     *ProbeFilter `json:"filter"`
+	TracesPath string       `json:"tracespath"`
+	Atomic     bool         `json:"atomic"`
+	// Scope are the only probes the caller sees, nil for all of them
+	Scope *Scope `json:"-"`
+}
+
+// BulkItem is the outcome of a bulk action on a probe, unchanged when it
+// already was in the state asked for
+type BulkItem struct {
+	ProbeID string `json:"ProbeID"`
+	FQDN    string `json:"fqdn,omitempty"`
+	Changed bool   `json:"changed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BulkResult is the outcome of a bulk action, Changed lists the probes it
+// changed. Nothing is changed when an atomic action fails on a probe.
+type BulkResult struct {
+	Action     string     `json:"action"`
+	Atomic     bool       `json:"atomic"`
+	RolledBack bool       `json:"rolledback,omitempty"`
+	Changed    []string   `json:"changed"`
+	Unchanged  []string   `json:"unchanged"`
+	Failed     int        `json:"failed"`
+	Items      []BulkItem `json:"items"`
+}
+
+// bulkAction changes a probe record with db, reporting false when the
+// probe already is in the state asked for, then once the change is
+// committed runs its side effects
+type bulkAction struct {
+	apply func(db orm.Ormer, probe *Probe) (bool, error)
+	after func(probe *Probe)
+}
+
+func newBulkAction(action string, request BulkRequest) (*bulkAction, error) {
+	switch action {
+	case BulkEnable:
+		return &bulkAction{
+			apply: func(db orm.Ormer, probe *Probe) (bool, error) {
+				if probe.Enabled {
+					return false, nil
+				}
+				probe.Enabled = true
+				probe.UpdatedAt = time.Now()
+				probe.DisabledAt = time.Time{}
+				_, err := db.Update(probe, "Enabled", "UpdatedAt", "DisabledAt")
+				return err == nil, err
+			},
+			after: func(probe *Probe) { notify(EventEnabled, probe) },
+		}, nil
+	case BulkDisable:
+		return &bulkAction{
+			apply: func(db orm.Ormer, probe *Probe) (bool, error) {
+				if !probe.Enabled {
+					return false, nil
+				}
+				probe.Enabled = false
+				probe.UpdatedAt = time.Now()
+				probe.DisabledAt = probe.UpdatedAt
+				_, err := db.Update(probe, "Enabled", "UpdatedAt", "DisabledAt")
+				return err == nil, err
+			},
+			after: disabled,
+		}, nil
+	case BulkDelete:
+		return &bulkAction{
+			apply: func(db orm.Ormer, probe *Probe) (bool, error) {
+				_, err := db.Delete(probe)
+				return err == nil, err
+			},
+			after: deleted,
+		}, nil
+	case BulkSetTracesPath:
+		if ok, _ := govalidator.IsFilePath(request.TracesPath); !ok {
+			return nil, fmt.Errorf("Invalid path for traces '%s'", request.TracesPath)
+		}
+		return &bulkAction{
+			apply: func(db orm.Ormer, probe *Probe) (bool, error) {
+				if probe.TracesPath == request.TracesPath {
+					return false, nil
+				}
+				probe.TracesPath = request.TracesPath
+				probe.UpdatedAt = time.Now()
+				_, err := db.Update(probe, "TracesPath", "UpdatedAt")
+				return err == nil, err
+			},
+			after: func(probe *Probe) { notify(EventTracesPathUpdated, probe) },
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown bulk action %s, expected %s, %s, %s or %s", action, BulkEnable, BulkDisable, BulkDelete, BulkSetTracesPath)
+}
+
+// bulkTargets returns the probes a bulk request targets, along with the
+// items of the IDs that are not found
+func bulkTargets(request BulkRequest) ([]*Probe, []BulkItem, error) {
+	targets := 0
+	for _, given := range []bool{len(request.IDs) > 0, request.Group != "", request.Filter != nil} {
+		if given {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return nil, nil, errors.New("target probes with exactly one of ids, group or filter")
+	}
+	switch {
+	case request.Group != "":
+		probes, err := GroupProbes(request.Group, request.Scope)
+		return probes, nil, err
+	case request.Filter != nil:
+		filter := *request.Filter
+		if filter.empty() {
+			return nil, nil, errors.New("empty filter, give its state as all to target every probe")
+		}
+		if filter.State == "" {
+			filter.State = StateAll
+		}
+		filter.Scope = request.Scope
+		probes, err := Find(filter)
+		return probes, nil, err
+	}
+	var probes []*Probe
+	var missing []BulkItem
+	seen := make(map[string]bool)
+	for _, ProbeID := range request.IDs {
+		if seen[ProbeID] {
+			continue
+		}
+		seen[ProbeID] = true
+		probe, err := GetByID(ProbeID)
+		if err != nil || !inScope(request.Scope, probe) {
+			missing = append(missing, BulkItem{ProbeID: ProbeID, Error: fmt.Sprintf("probe %s not found", ProbeID)})
+			continue
+		}
+		probes = append(probes, probe)
+	}
+	return probes, missing, nil
+}
+
+// Bulk runs a bulk action on the probes targeted by request. Unless atomic
+// every probe is changed on its own and the ones failing are reported
+// along with the others. The error is only set when the request itself is
+// invalid or the database fails.
+func Bulk(action string, request BulkRequest) (*BulkResult, error) {
+	run, err := newBulkAction(action, request)
+	if err != nil {
+		return nil, err
+	}
+	probes, missing, err := bulkTargets(request)
+	if err != nil {
+		return nil, err
+	}
+	if len(probes) > bulkMaxProbes {
+		return nil, fmt.Errorf("%d probes targeted, a bulk action changes at most %d", len(probes), bulkMaxProbes)
+	}
+	log.Infof("[models.bulk.Bulk]: %s of %d probes, atomic %t", action, len(probes), request.Atomic)
+	result := &BulkResult{Action: action, Atomic: request.Atomic, Changed: []string{}, Unchanged: []string{}, Items: []BulkItem{}}
+	items := make([]BulkItem, len(probes))
+	for i, probe := range probes {
+		items[i] = BulkItem{ProbeID: probe.ProbeID, FQDN: probe.FQDN}
+	}
+
+	if !request.Atomic {
+		for i, probe := range probes {
+			changed, err := run.apply(o, probe)
+			if err != nil {
+				items[i].Error = err.Error()
+				continue
+			}
+			items[i].Changed = changed
+			if changed {
+				run.after(probe)
+			}
+		}
+		result.report(items, missing)
+		return result, nil
+	}
+
+	if len(missing) > 0 {
+		result.RolledBack = true
+		result.report(items, missing)
+		return result, nil
+	}
+	tx := orm.NewOrm()
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	failed := false
+	for i, probe := range probes {
+		changed, err := run.apply(tx, probe)
+		if err != nil {
+			items[i].Error = err.Error()
+			failed = true
+			break
+		}
+		items[i].Changed = changed
+	}
+	if failed {
+		if err := tx.Rollback(); err != nil {
+			return nil, err
+		}
+		for i := range items {
+			items[i].Changed = false
+		}
+		result.RolledBack = true
+		result.report(items, nil)
+		return result, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for i, probe := range probes {
+		if items[i].Changed {
+			run.after(probe)
+		}
+	}
+	result.report(items, nil)
+	return result, nil
+}
+
+// report sorts the items of the targeted probes and the missing ones out
+func (result *BulkResult) report(items []BulkItem, missing []BulkItem) {
+	for _, item := range append(items, missing...) {
+		switch {
+		case item.Error != "":
+			result.Failed++
+		case item.Changed:
+			result.Changed = append(result.Changed, item.ProbeID)
+		case !result.RolledBack:
+			result.Unchanged = append(result.Unchanged, item.ProbeID)
+		}
+		result.Items = append(result.Items, item)
+	}
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBulkPerItem(t *testing.T) {
+	enabled := addLabelledProbe(t, "bulk-enabled.example.com", "192.0.2.100", "AWS", Labels{"bulk": "items"})
+	defer Delete(enabled.ProbeID)
+	disabled := addLabelledProbe(t, "bulk-disabled.example.com", "192.0.2.101", "AWS", Labels{"bulk": "items"})
+	defer Delete(disabled.ProbeID)
+	if _, err := Disable(disabled.ProbeID); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Bulk(BulkEnable, BulkRequest{IDs: []string{enabled.ProbeID, disabled.ProbeID, "missing", disabled.ProbeID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Items) != 3 || result.Failed != 1 || result.RolledBack {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if len(result.Changed) != 1 || result.Changed[0] != disabled.ProbeID {
+		t.Errorf("changed %v, expected %s", result.Changed, disabled.ProbeID)
+	}
+	if len(result.Unchanged) != 1 || result.Unchanged[0] != enabled.ProbeID {
+		t.Errorf("unchanged %v, expected %s", result.Unchanged, enabled.ProbeID)
+	}
+	if probe, _ := GetByID(disabled.ProbeID); !probe.Enabled {
+		t.Error("disabled probe not enabled")
+	}
+}
+
+func TestBulkAtomicRollback(t *testing.T) {
+	first := addTestProbe(t, "bulk-first.example.com", "192.0.2.102", "AWS")
+	defer Delete(first.ProbeID)
+	second := addTestProbe(t, "bulk-second.example.com", "192.0.2.103", "AWS")
+	defer Delete(second.ProbeID)
+
+	// a missing probe fails the whole atomic action before anything changes
+	result, err := Bulk(BulkDisable, BulkRequest{IDs: []string{first.ProbeID, "missing", second.ProbeID}, Atomic: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.RolledBack || result.Failed != 1 || len(result.Changed) != 0 || len(result.Unchanged) != 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	for _, ProbeID := range []string{first.ProbeID, second.ProbeID} {
+		if probe, _ := GetByID(ProbeID); !probe.Enabled {
+			t.Errorf("probe %s disabled by a rolled back action", ProbeID)
+		}
+	}
+
+	// a probe failing to update rolls back the ones changed before it
+	if _, err := o.Raw(fmt.Sprintf("CREATE TRIGGER bulk_fail BEFORE UPDATE ON probe WHEN NEW.probe_i_d = '%s' BEGIN SELECT RAISE(ABORT, 'refused'); END", second.ProbeID)).Exec(); err != nil {
+		t.Fatal(err)
+	}
+	defer o.Raw("DROP TRIGGER bulk_fail").Exec()
+	result, err = Bulk(BulkSetTracesPath, BulkRequest{IDs: []string{first.ProbeID, second.ProbeID}, TracesPath: "/srv/traces", Atomic: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.RolledBack || result.Failed != 1 || len(result.Changed) != 0 || result.Items[0].Changed {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if probe, _ := GetByID(first.ProbeID); probe.TracesPath == "/srv/traces" {
+		t.Error("change of a rolled back action kept")
+	}
+
+	// without atomic the probes that can be changed are
+	result, err = Bulk(BulkSetTracesPath, BulkRequest{IDs: []string{first.ProbeID, second.ProbeID}, TracesPath: "/srv/traces"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RolledBack || result.Failed != 1 || len(result.Changed) != 1 || result.Changed[0] != first.ProbeID {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestBulkScope(t *testing.T) {
+	aws := addLabelledProbe(t, "bulk-aws.example.com", "192.0.2.104", "AWS", Labels{"bulk": "scope"})
+	defer Delete(aws.ProbeID)
+	vultr := addLabelledProbe(t, "bulk-vultr.example.com", "192.0.2.105", "Vultr", Labels{"bulk": "scope"})
+	defer Delete(vultr.ProbeID)
+	if _, err := AddGroup(ProbeGroup{Name: "bulk-scope", Members: []string{aws.ProbeID, vultr.ProbeID}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteGroup("bulk-scope")
+	scope := &Scope{Providers: []string{"AWS"}}
+
+	requests := map[string]BulkRequest{
+		"ids":    {IDs: []string{aws.ProbeID, vultr.ProbeID}, Scope: scope},
+		"group":  {Group: "bulk-scope", Scope: scope},
+		"filter": {Filter: &ProbeFilter{Selector: "bulk=scope"}, Scope: scope},
+	}
+	for target, request := range requests {
+		result, err := Bulk(BulkDisable, request)
+		if err != nil {
+			t.Fatalf("%s: %s", target, err)
+		}
+		if len(result.Changed) != 1 || result.Changed[0] != aws.ProbeID {
+			t.Errorf("%s: changed %v, expected %s only", target, result.Changed, aws.ProbeID)
+		}
+		if probe, _ := GetByID(vultr.ProbeID); !probe.Enabled {
+			t.Fatalf("%s: probe out of scope disabled", target)
+		}
+		if _, err := Enable(aws.ProbeID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Bulk(BulkDisable, BulkRequest{IDs: []string{aws.ProbeID}, Group: "bulk-scope"}); err == nil {
+		t.Error("request targeting both ids and a group accepted")
+	}
+	if _, err := Bulk(BulkDisable, BulkRequest{Filter: &ProbeFilter{}}); err == nil {
+		t.Error("empty filter accepted")
+	}
+}
+
+func TestBulkMaxProbes(t *testing.T) {
+	probe := addLabelledProbe(t, "bulk-max.example.com", "192.0.2.106", "AWS", Labels{"bulk": "max"})
+	defer Delete(probe.ProbeID)
+	IDs := []string{probe.ProbeID}
+	for len(IDs) <= bulkMaxProbes {
+		IDs = append(IDs, IDs...)
+	}
+	if _, err := Bulk(BulkEnable, BulkRequest{IDs: IDs}); err != nil {
+		t.Errorf("ids repeated over the limit refused: %s", err)
+	}
+
+	clones := make([]*Probe, bulkMaxProbes)
+	for i := range clones {
+		clone := *probe
+		clone.ProbeID = fmt.Sprintf("%s-%d", probe.ProbeID, i)
+		clone.FQDN = fmt.Sprintf("bulk-max-%d.example.com", i)
+		clones[i] = &clone
+	}
+	if _, err := o.InsertMulti(100, clones); err != nil {
+		t.Fatal(err)
+	}
+	defer o.QueryTable("probe").Filter("FQDN__startswith", "bulk-max-").Delete()
+	if _, err := Bulk(BulkDisable, BulkRequest{Filter: &ProbeFilter{Selector: "bulk=max"}}); err == nil {
+		t.Errorf("action on more than %d probes accepted", bulkMaxProbes)
+	}
+	if count, _ := o.QueryTable("probe").Filter("enabled", false).Filter("FQDN__startswith", "bulk-max-").Count(); count != 0 {
+		t.Errorf("%d probes disabled by a refused action", count)
+	}
+}
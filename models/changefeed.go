@@ -0,0 +1,144 @@
+package models
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego/orm"
+)
+
+// ProbeChange is a probe event persisted in the change feed, its ID
+// increases monotonically and identifies it across restarts
+type ProbeChange struct {
+	ID        int64     `orm:"pk;auto;column(id)" json:"id"`
+	Event     string    `orm:"size(64)" json:"event"`
+	ProbeID   string    `orm:"size(100);column(probe_id)" json:"ProbeID"`
+	Payload   string    `orm:"type(text)" json:"payload"`
+	Detail    string    `orm:"size(512)" json:"detail,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	// changeSubscriberBuffer is how many changes a slow subscriber may lag
+	// behind before being dropped
+	changeSubscriberBuffer = 64
+	// changePruneInterval is how often changes past their retention are
+	// removed
+	changePruneInterval = time.Hour
+)
+
+var (
+	changeSubscribersMu sync.Mutex
+	changeSubscribers   = make(map[chan *ProbeChange]bool)
+
+	changeRetention time.Duration
+	changePruner    sync.Once
+)
+
+// ConfigureChangeRetention keeps the changes of the feed for maxAge, 0
+// keeping them forever. Changes past their retention are removed every
+// hour, clients replaying from one of them start at the oldest change kept.
+func ConfigureChangeRetention(maxAge time.Duration) {
+	changeRetention = maxAge
+	if maxAge <= 0 {
+		return
+	}
+	changePruner.Do(func() {
+		go func() {
+			for range time.Tick(changePruneInterval) {
+				if _, err := PruneChanges(); err != nil {
+					log.Errorf("[models.changefeed]: periodic pruning failed: %s", err)
+				}
+			}
+		}()
+	})
+}
+
+// PruneChanges removes the changes past their retention and returns how
+// many were removed, host key changes are kept as the audit trail of the
+// probes
+func PruneChanges() (int64, error) {
+	if changeRetention <= 0 {
+		return 0, nil
+	}
+	removed, err := o.QueryTable("probe_change").Filter("created_at__lt", time.Now().Add(-changeRetention)).Exclude("event__in", hostKeyEvents).Delete()
+	if err != nil {
+		return 0, err
+	}
+	if removed > 0 {
+		log.Infof("[models.changefeed.PruneChanges]: removed %d changes older than %s", removed, changeRetention)
+	}
+	return removed, nil
+}
+
+// recordChange persists every probe event and hands it to the subscribers
+func recordChange(event ProbeEvent) {
+	probe := event.Probe
+	probe.SSHPrivateKey = ""
+	payload, err := json.Marshal(probe)
+	if err != nil {
+		log.Errorf("[models.changefeed.recordChange]: unable to encode %s event: %s", event.Type, err)
+		return
+	}
+	change := &ProbeChange{
+		Event:     event.Type,
+		ProbeID:   probe.ProbeID,
+		Payload:   string(payload),
+		Detail:    truncate(event.Detail, 512),
+		CreatedAt: time.Now(),
+	}
+	changeSubscribersMu.Lock()
+	defer changeSubscribersMu.Unlock()
+	if _, err := o.Insert(change); err != nil {
+		log.Errorf("[models.changefeed.recordChange]: unable to persist %s event: %s", event.Type, err)
+		return
+	}
+	for ch := range changeSubscribers {
+		select {
+		case ch <- change:
+		default:
+			log.Warningf("[models.changefeed.recordChange]: dropping subscriber lagging behind change %d", change.ID)
+			delete(changeSubscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// SubscribeChanges returns a channel receiving every change recorded from
+// now on, and a function to stop receiving them. The channel is closed
+// when the subscriber falls too far behind.
+func SubscribeChanges() (<-chan *ProbeChange, func()) {
+	ch := make(chan *ProbeChange, changeSubscriberBuffer)
+	changeSubscribersMu.Lock()
+	changeSubscribers[ch] = true
+	changeSubscribersMu.Unlock()
+	return ch, func() {
+		changeSubscribersMu.Lock()
+		defer changeSubscribersMu.Unlock()
+		if changeSubscribers[ch] {
+			delete(changeSubscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// LatestChangeID returns the id of the last change recorded, 0 when there
+// is none
+func LatestChangeID() (int64, error) {
+	var change ProbeChange
+	err := o.QueryTable("probe_change").OrderBy("-id").Limit(1).One(&change, "ID")
+	if err == orm.ErrNoRows {
+		return 0, nil
+	}
+	return change.ID, err
+}
+
+// GetChangesSince returns up to limit changes recorded after the change
+// with id lastID, oldest first
+func GetChangesSince(lastID int64, limit int) ([]*ProbeChange, error) {
+	var changes []*ProbeChange
+	_, err := o.QueryTable("probe_change").Filter("id__gt", lastID).OrderBy("id").Limit(limit).All(&changes)
+	return changes, err
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPruneChanges checks only the changes past their retention are
+// removed, along with the id new clients of the feed start from
+func TestPruneChanges(t *testing.T) {
+	defer ConfigureChangeRetention(changeRetention)
+	probe := addTestProbe(t, "pruned.example.com", "192.0.2.90", "AWS")
+	defer Delete(probe.ProbeID)
+	latest, err := LatestChangeID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes, _ := GetChangesSince(latest-1, 10); len(changes) != 1 || changes[0].ProbeID != probe.ProbeID {
+		t.Fatalf("last change is not the registration of the probe: %v", changes)
+	}
+
+	old := []*ProbeChange{
+		{Event: EventDisabled, ProbeID: probe.ProbeID, CreatedAt: time.Now().Add(-48 * time.Hour)},
+		{Event: EventHostKeyChanged, ProbeID: probe.ProbeID, CreatedAt: time.Now().Add(-48 * time.Hour)},
+	}
+	for _, change := range old {
+		if _, err := o.Insert(change); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ConfigureChangeRetention(0)
+	if removed, err := PruneChanges(); err != nil || removed != 0 {
+		t.Errorf("changes removed without a retention: %d %v", removed, err)
+	}
+	ConfigureChangeRetention(24 * time.Hour)
+	if removed, err := PruneChanges(); err != nil || removed != 1 {
+		t.Errorf("unexpected pruning: %d %v", removed, err)
+	}
+	kept, err := GetChangesSince(latest-1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 2 || kept[0].ID != latest || kept[1].Event != EventHostKeyChanged {
+		t.Errorf("unexpected changes kept %v", kept)
+	}
+	if id, _ := LatestChangeID(); id != old[1].ID {
+		t.Errorf("last change %d, expected %d", id, old[1].ID)
+	}
+}
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"bitbucket.org/fseros/sinker_registry_api/ddns"
+	log "github.com/Sirupsen/logrus"
+)
+
+// dnsBatchSize bounds the names sent per dynamic update so that messages
+// stay small enough for UDP
+const dnsBatchSize = 20
+
+var (
+	dnsClient *ddns.Client
+	dnsTTL    uint32
+)
+
+// ConfigureDNS enables publishing the addresses of probes whose FQDN is in
+// the client zone every time a probe is registered, changed or deleted
+func ConfigureDNS(client *ddns.Client, ttl uint32) {
+	dnsClient = client
+	dnsTTL = ttl
+	OnProbeEvent(func(event ProbeEvent) {
+		fqdn := event.Probe.FQDN
+		if !dnsClient.InZone(fqdn) {
+			return
+		}
+		go func() {
+			if err := publishFQDNs([]string{fqdn}); err != nil {
+				log.Errorf("[models.dns]: unable to publish %s after %s: %s", fqdn, event.Type, err)
+			}
+		}()
+	})
+}
+
+// DNSConfigured reports whether dynamic DNS updates are enabled
+func DNSConfigured() bool {
+	return dnsClient != nil
+}
+
+// rrsetFor returns the addresses fqdn should resolve to, those of the
+// enabled probes registered with it in any case
+func rrsetFor(fqdn string) (ddns.RRSet, error) {
+	set := ddns.RRSet{Name: fqdn, TTL: dnsTTL}
+	var probes []Probe
+	if _, err := o.QueryTable("probe").Filter("enabled", true).Filter("FQDN__iexact", fqdn).Limit(-1).All(&probes); err != nil {
+		return set, err
+	}
+	for _, probe := range probes {
+		if ip := net.ParseIP(probe.Ipv4); ip != nil {
+			set.IPv4 = append(set.IPv4, ip)
+		}
+		if ip := net.ParseIP(probe.Ipv6); ip != nil && probe.Ipv6 != "" {
+			set.IPv6 = append(set.IPv6, ip)
+		}
+	}
+	return set, nil
+}
+
+func publishFQDNs(fqdns []string) error {
+	for start := 0; start < len(fqdns); start += dnsBatchSize {
+		end := start + dnsBatchSize
+		if end > len(fqdns) {
+			end = len(fqdns)
+		}
+		sets := make([]ddns.RRSet, 0, end-start)
+		for _, fqdn := range fqdns[start:end] {
+			set, err := rrsetFor(fqdn)
+			if err != nil {
+				return err
+			}
+			sets = append(sets, set)
+		}
+		if err := dnsClient.Replace(sets...); err != nil {
+			return err
+		}
+		log.Infof("[models.dns]: published %d names to %s", len(sets), dnsClient.Server)
+	}
+	return nil
+}
+
+// SyncDNS publishes the records of every probe in the zone, removing those
+// of disabled probes
+func SyncDNS() (int, error) {
+	if dnsClient == nil {
+		return 0, fmt.Errorf("dynamic dns updates are not configured")
+	}
+	probes, err := Find(ProbeFilter{State: StateAll})
+	if err != nil {
+		return 0, err
+	}
+	seen := make(map[string]bool)
+	fqdns := make([]string, 0, len(probes))
+	for _, probe := range probes {
+		fqdn := strings.ToLower(probe.FQDN)
+		if !seen[fqdn] && dnsClient.InZone(fqdn) {
+			seen[fqdn] = true
+			fqdns = append(fqdns, fqdn)
+		}
+	}
+	sort.Strings(fqdns)
+	return len(fqdns), publishFQDNs(fqdns)
+}
+
+// ToZoneFile renders the A and AAAA records of the enabled probes whose
+// FQDN is in zone, in BIND master file format
+func ToZoneFile(probes []*Probe, zone string, ttl uint32) string {
+	zone = ddns.Fqdn(strings.ToLower(zone))
+	client := ddns.Client{Zone: zone}
+	sorted := make([]*Probe, 0, len(probes))
+	for _, probe := range probes {
+		if probe.Enabled && client.InZone(probe.FQDN) {
+			sorted = append(sorted, probe)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return strings.ToLower(sorted[i].FQDN) < strings.ToLower(sorted[j].FQDN)
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString("; generated by sinker_registry_api\n")
+	fmt.Fprintf(&buf, "$ORIGIN %s\n", zone)
+	fmt.Fprintf(&buf, "$TTL %d\n", ttl)
+	for _, probe := range sorted {
+		name := ddns.Fqdn(strings.ToLower(probe.FQDN))
+		if ip := net.ParseIP(probe.Ipv4); ip != nil && ip.To4() != nil {
+			fmt.Fprintf(&buf, "%s\tIN\tA\t%s\n", name, ip)
+		}
+		if ip := net.ParseIP(probe.Ipv6); ip != nil && ip.To4() == nil {
+			fmt.Fprintf(&buf, "%s\tIN\tAAAA\t%s\n", name, ip)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRRSetForIgnoresCase(t *testing.T) {
+	var probe Probe
+	probe.SetDefaults()
+	probe.FQDN = "Mixed.Example.com"
+	probe.Ipv4 = "192.0.2.50"
+	probe.Ipv6 = "2001:db8::50"
+	probe.Provider = "AWS"
+	probe.Enabled = true
+	probe.GeoLatitude = "40.4"
+	probe.GeoLongitude = "-3.7"
+	ProbeID, err := AddOne(probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Delete(ProbeID)
+
+	set, err := rrsetFor("mixed.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.IPv4) != 1 || !set.IPv4[0].Equal(net.ParseIP(probe.Ipv4)) || len(set.IPv6) != 1 {
+		t.Errorf("records of Mixed.Example.com not found for mixed.example.com: %v %v", set.IPv4, set.IPv6)
+	}
+}
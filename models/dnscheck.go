@@ -0,0 +1,62 @@
+package models
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"bitbucket.org/fseros/sinker_registry_api/ddns"
+	log "github.com/Sirupsen/logrus"
+)
+
+const dnsVerifyTimeout = 10 * time.Second
+
+var (
+	dnsResolver         = net.DefaultResolver
+	dnsVerifyOnRegister bool
+)
+
+// DNSCheck is a probe along with the outcome of verifying its records
+type DNSCheck struct {
+	Probe        *Probe             `json:"probe"`
+	Verification *ddns.Verification `json:"verification"`
+}
+
+// ConfigureDNSVerification sets the resolver, host:port, used to verify the
+// probes records, the system one when empty, and whether new probes are
+// verified when they register
+func ConfigureDNSVerification(resolver string, onRegister bool) {
+	dnsResolver = ddns.NewResolver(resolver)
+	dnsVerifyOnRegister = onRegister
+}
+
+// verifyDNS checks the forward and reverse records of probe and records the
+// outcome on it, without saving it
+func verifyDNS(probe *Probe) ddns.Verification {
+	addrs := []net.IP{net.ParseIP(probe.Ipv4)}
+	if probe.Ipv6 != "" {
+		addrs = append(addrs, net.ParseIP(probe.Ipv6))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dnsVerifyTimeout)
+	defer cancel()
+	verification := ddns.Verify(ctx, dnsResolver, probe.FQDN, addrs...)
+	probe.DNSForward = verification.Forward
+	probe.DNSReverse = verification.Reverse
+	probe.DNSVerifiedAt = time.Now()
+	log.Infof("[models.dnscheck.verifyDNS]: %s forward %t reverse %t %v", probe.FQDN, verification.Forward, verification.Reverse, verification.Errors)
+	return verification
+}
+
+// VerifyDNS checks whether the FQDN of a probe resolves to its addresses
+// and whether they point back to it, and saves the outcome
+func VerifyDNS(ProbeID string) (*Probe, *ddns.Verification, error) {
+	probe, err := GetByID(ProbeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	verification := verifyDNS(probe)
+	if _, err := o.Update(probe, "DNSForward", "DNSReverse", "DNSVerifiedAt"); err != nil {
+		return nil, nil, err
+	}
+	return probe, &verification, nil
+}
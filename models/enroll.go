@@ -0,0 +1,208 @@
+package models
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego/orm"
+)
+
+const enrollmentPrefix = "enr_"
+
+// EnrollmentRefused is the error of enrollments whose token is invalid, or
+// does not allow the probe
+type EnrollmentRefused struct {
+	reason string
+}
+
+func (e *EnrollmentRefused) Error() string {
+	return e.reason
+}
+
+func refuseEnrollment(format string, a ...interface{}) error {
+	return &EnrollmentRefused{reason: fmt.Sprintf(format, a...)}
+}
+
+// EnrollmentToken lets a probe register itself once before it expires,
+// within the provider and the network it is restricted to if any, and the
+// scope of the team of the user minting it. Only the SHA-256 of the token
+// is stored.
+type EnrollmentToken struct {
+	ID          int64     `orm:"pk;auto;column(id)" json:"id"`
+	TokenHash   string    `orm:"size(64);unique" json:"-"`
+	Description string    `orm:"size(256)" json:"description"`
+	Provider    string    `orm:"size(64)" json:"provider,omitempty"`
+	CIDR        string    `orm:"size(64);column(cidr)" json:"cidr,omitempty"`
+	Enable      bool      `json:"enable"`
+	CreatedBy   string    `orm:"size(64)" json:"created_by,omitempty"`
+	Team        int64     `json:"team,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Used        bool      `orm:"index" json:"used"`
+	UsedAt      time.Time `orm:"null" json:"used_at"`
+	ProbeID     string    `orm:"size(100);column(probe_id)" json:"ProbeID,omitempty"`
+}
+
+// NewEnrollmentToken is an enrollment token along with the token itself,
+// only shown when minted
+type NewEnrollmentToken struct {
+	*EnrollmentToken
+	Token string `json:"token"`
+}
+
+var (
+	enrollmentTTL    = time.Hour
+	enrollmentMaxTTL = 24 * time.Hour
+)
+
+// ConfigureEnrollment sets how long enrollment tokens last by default and
+// at most
+func ConfigureEnrollment(ttl time.Duration, maxTTL time.Duration) {
+	enrollmentTTL = ttl
+	enrollmentMaxTTL = maxTTL
+}
+
+// AddEnrollmentToken mints an enrollment token lasting ttl, the default
+// when zero. Its probe must be within the scope of team, the team of the
+// user minting it.
+func AddEnrollmentToken(token EnrollmentToken, ttl time.Duration, createdBy string, team int64) (*NewEnrollmentToken, error) {
+	log.Infof("[models.enroll.AddEnrollmentToken]: %s minting an enrollment token for provider %q network %q", createdBy, token.Provider, token.CIDR)
+	if ttl == 0 {
+		ttl = enrollmentTTL
+	}
+	if ttl < 0 || ttl > enrollmentMaxTTL {
+		return nil, fmt.Errorf("enrollment tokens last at most %s", enrollmentMaxTTL)
+	}
+	if token.Provider != "" {
+		provider, err := LookupProvider(token.Provider)
+		if err != nil {
+			return nil, fmt.Errorf("invalid provider %s", token.Provider)
+		}
+		token.Provider = provider.Name
+	}
+	if token.CIDR != "" {
+		_, network, err := net.ParseCIDR(token.CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %s", token.CIDR)
+		}
+		token.CIDR = network.String()
+	}
+	secret, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	secret = enrollmentPrefix + strings.TrimPrefix(secret, tokenPrefix)
+	now := time.Now()
+	record := &EnrollmentToken{
+		TokenHash:   hashToken(secret),
+		Description: token.Description,
+		Provider:    token.Provider,
+		CIDR:        token.CIDR,
+		Enable:      token.Enable,
+		CreatedBy:   createdBy,
+		Team:        team,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+	}
+	if _, err := o.Insert(record); err != nil {
+		return nil, err
+	}
+	return &NewEnrollmentToken{EnrollmentToken: record, Token: secret}, nil
+}
+
+// GetEnrollmentTokens returns every enrollment token, newest first
+func GetEnrollmentTokens() ([]*EnrollmentToken, error) {
+	tokens := []*EnrollmentToken{}
+	_, err := o.QueryTable("enrollment_token").OrderBy("-id").Limit(-1).All(&tokens)
+	return tokens, err
+}
+
+// DeleteEnrollmentToken removes an enrollment token, it can no longer be
+// used
+func DeleteEnrollmentToken(id int64) error {
+	log.Infof("[models.enroll.DeleteEnrollmentToken]: removing enrollment token %d", id)
+	token := EnrollmentToken{ID: id}
+	if err := o.Read(&token); err != nil {
+		if err == orm.ErrNoRows {
+			return fmt.Errorf("enrollment token %d not found", id)
+		}
+		return err
+	}
+	_, err := o.Delete(&token)
+	return err
+}
+
+// Enroll registers probe with an enrollment token, on behalf of the probe
+// calling from source. The probe takes the source address as Ipv4 when it
+// has none and the provider of the token when restricted to one.
+func Enroll(secret string, probe Probe, source string) (string, error) {
+	if !strings.HasPrefix(secret, enrollmentPrefix) {
+		return "", refuseEnrollment("invalid enrollment token")
+	}
+	token := EnrollmentToken{TokenHash: hashToken(secret)}
+	if err := o.Read(&token, "TokenHash"); err != nil {
+		return "", refuseEnrollment("invalid enrollment token")
+	}
+	now := time.Now()
+	if token.Used {
+		return "", refuseEnrollment("enrollment token %d was already used", token.ID)
+	}
+	if now.After(token.ExpiresAt) {
+		return "", refuseEnrollment("enrollment token %d expired at %s", token.ID, token.ExpiresAt.Format(time.RFC3339))
+	}
+	ip := net.ParseIP(source)
+	if token.CIDR != "" {
+		_, network, _ := net.ParseCIDR(token.CIDR)
+		if ip == nil || network == nil || !network.Contains(ip) {
+			return "", refuseEnrollment("enrollment token %d is restricted to %s", token.ID, token.CIDR)
+		}
+	}
+	if probe.Ipv4 == "" && ip != nil && ip.To4() != nil {
+		probe.Ipv4 = ip.String()
+	}
+	if token.Provider != "" {
+		if probe.Provider == "" {
+			probe.Provider = token.Provider
+		}
+		if provider, err := LookupProvider(probe.Provider); err != nil || provider.Name != token.Provider {
+			return "", refuseEnrollment("enrollment token %d is restricted to provider %s", token.ID, token.Provider)
+		}
+	}
+	if provider, err := LookupProvider(probe.Provider); err == nil {
+		probe.Provider = provider.Name
+	}
+	scope, err := teamScope(token.Team)
+	if err != nil {
+		return "", refuseEnrollment("enrollment token %d belongs to a team that is gone", token.ID)
+	}
+	if !inScope(scope, &probe) {
+		return "", refuseEnrollment("enrollment token %d is restricted to the scope of team %d", token.ID, token.Team)
+	}
+	probe.Enabled = token.Enable
+
+	// claim the token first so that it registers a single probe
+	claimed, err := o.QueryTable("enrollment_token").Filter("id", token.ID).Filter("used", false).Update(orm.Params{
+		"used":    true,
+		"used_at": now,
+	})
+	if err != nil {
+		return "", err
+	}
+	if claimed == 0 {
+		return "", refuseEnrollment("enrollment token %d was already used", token.ID)
+	}
+	ProbeID, err := AddOne(probe)
+	if err != nil {
+		o.QueryTable("enrollment_token").Filter("id", token.ID).Update(orm.Params{"used": false})
+		return "", err
+	}
+	token.ProbeID = ProbeID
+	if _, err := o.Update(&token, "ProbeID"); err != nil {
+		log.Errorf("[models.enroll.Enroll]: unable to record probe %s enrolled with token %d: %s", ProbeID, token.ID, err)
+	}
+	log.Infof("[models.enroll.Enroll]: probe %s enrolled from %s with token %d", ProbeID, source, token.ID)
+	return ProbeID, nil
+}
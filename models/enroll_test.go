@@ -0,0 +1,168 @@
+package models
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// enrollingProbe is a probe enrolling itself with a token
+func enrollingProbe(fqdn string, provider string) Probe {
+	var probe Probe
+	probe.SetDefaults()
+	probe.FQDN = fqdn
+	probe.Provider = provider
+	probe.GeoLatitude = "40.4"
+	probe.GeoLongitude = "-3.7"
+	return probe
+}
+
+func enrollmentUsed(t *testing.T, id int64) bool {
+	token := EnrollmentToken{ID: id}
+	if err := o.Read(&token); err != nil {
+		t.Fatal(err)
+	}
+	return token.Used
+}
+
+func TestEnrollSingleUse(t *testing.T) {
+	token, err := AddEnrollmentToken(EnrollmentToken{Enable: true}, 0, "admin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteEnrollmentToken(token.ID)
+	ProbeID, err := Enroll(token.Token, enrollingProbe("enrolled.example.com", "AWS"), "192.0.2.80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Delete(ProbeID)
+	probe, err := GetByID(ProbeID)
+	if err != nil || probe.Ipv4 != "192.0.2.80" || !probe.Enabled {
+		t.Errorf("enrolled probe %+v: %v", probe, err)
+	}
+	if _, err := Enroll(token.Token, enrollingProbe("again.example.com", "AWS"), "192.0.2.81"); err == nil {
+		t.Error("token used twice")
+	} else if _, refused := err.(*EnrollmentRefused); !refused {
+		t.Errorf("second enrollment failed instead of being refused: %s", err)
+	}
+	if _, err := Enroll("enr_unknown", enrollingProbe("unknown.example.com", "AWS"), "192.0.2.82"); err == nil {
+		t.Error("unknown token accepted")
+	}
+}
+
+func TestEnrollExpired(t *testing.T) {
+	token, err := AddEnrollmentToken(EnrollmentToken{}, time.Minute, "admin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteEnrollmentToken(token.ID)
+	token.ExpiresAt = time.Now().Add(-time.Second)
+	if _, err := o.Update(token.EnrollmentToken, "ExpiresAt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Enroll(token.Token, enrollingProbe("expired.example.com", "AWS"), "192.0.2.83"); err == nil {
+		t.Error("expired token accepted")
+	}
+	if _, err := AddEnrollmentToken(EnrollmentToken{}, 2*enrollmentMaxTTL, "admin", 0); err == nil {
+		t.Error("token lasting longer than allowed minted")
+	}
+}
+
+func TestEnrollRestrictions(t *testing.T) {
+	token, err := AddEnrollmentToken(EnrollmentToken{Provider: "vultr", CIDR: "192.0.2.0/28"}, 0, "admin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteEnrollmentToken(token.ID)
+	if token.CIDR != "192.0.2.0/28" || token.Provider != "Vultr" {
+		t.Errorf("restrictions not normalized: %q %q", token.CIDR, token.Provider)
+	}
+	if _, err := Enroll(token.Token, enrollingProbe("outside.example.com", ""), "198.51.100.1"); err == nil {
+		t.Error("token used outside of its network")
+	}
+	if _, err := Enroll(token.Token, enrollingProbe("other.example.com", "AWS"), "192.0.2.1"); err == nil {
+		t.Error("token used for another provider")
+	}
+	if enrollmentUsed(t, token.ID) {
+		t.Fatal("refused enrollments used the token")
+	}
+	ProbeID, err := Enroll(token.Token, enrollingProbe("inside.example.com", ""), "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Delete(ProbeID)
+	probe, err := GetByID(ProbeID)
+	if err != nil || probe.Provider != "Vultr" || probe.Enabled {
+		t.Errorf("probe enrolled within the restrictions %+v: %v", probe, err)
+	}
+}
+
+func TestEnrollReleasesTokenOnFailure(t *testing.T) {
+	token, err := AddEnrollmentToken(EnrollmentToken{}, 0, "admin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteEnrollmentToken(token.ID)
+	if _, err := Enroll(token.Token, enrollingProbe("not a name", "AWS"), "192.0.2.90"); err == nil {
+		t.Fatal("invalid probe enrolled")
+	}
+	if enrollmentUsed(t, token.ID) {
+		t.Fatal("token kept after the probe was refused")
+	}
+	ProbeID, err := Enroll(token.Token, enrollingProbe("valid.example.com", "AWS"), "192.0.2.90")
+	if err != nil {
+		t.Fatalf("released token refused: %s", err)
+	}
+	Delete(ProbeID)
+}
+
+func TestSnapshotEnrollmentTokens(t *testing.T) {
+	token, err := AddEnrollmentToken(EnrollmentToken{Description: "snapshot"}, 0, "admin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteEnrollmentToken(token.ID)
+	var buf bytes.Buffer
+	manifest, err := ExportSnapshot(&buf, "a long enough passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Counts["enrollment_token"] == 0 {
+		t.Fatalf("enrollment tokens missing from the snapshot: %v", manifest.Counts)
+	}
+	if err := DeleteEnrollmentToken(token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RestoreSnapshot(&buf, "a long enough passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	ProbeID, err := Enroll(token.Token, enrollingProbe("restored.example.com", "AWS"), "192.0.2.91")
+	if err != nil {
+		t.Fatalf("restored token refused: %s", err)
+	}
+	Delete(ProbeID)
+}
+
+// TestEnrollTeamScope checks a token minted by a scoped user only enrolls
+// probes within the scope of its team
+func TestEnrollTeamScope(t *testing.T) {
+	team, err := AddTeam(Team{Name: "vultr-enrollers", Providers: []string{"Vultr"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := AddEnrollmentToken(EnrollmentToken{}, 0, "vultr-admin", team.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteEnrollmentToken(token.ID)
+	if _, err := Enroll(token.Token, enrollingProbe("scoped-aws.example.com", "aws"), "192.0.2.90"); err == nil {
+		t.Error("token enrolled a probe outside the scope of its team")
+	} else if _, refused := err.(*EnrollmentRefused); !refused {
+		t.Errorf("out of scope enrollment failed instead of being refused: %s", err)
+	}
+	ProbeID, err := Enroll(token.Token, enrollingProbe("scoped-vultr.example.com", "vultr"), "192.0.2.91")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Delete(ProbeID)
+}
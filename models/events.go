@@ -0,0 +1,54 @@
+package models
+
+import "sync"
+
+// Probe lifecycle events
+const (
+	EventRegistered        = "probe.registered"
+	EventEnabled           = "probe.enabled"
+	EventDisabled          = "probe.disabled"
+	EventKeyStaged         = "probe.key_staged"
+	EventRekeyed           = "probe.rekeyed"
+	EventHostKeyUpdated    = "probe.hostkey_updated"
+	EventHostKeyChanged    = "probe.hostkey_changed"
+	EventHostKeyMismatch   = "probe.hostkey_mismatch"
+	EventTracesPathUpdated = "probe.tracespath_updated"
+	EventLabelsUpdated     = "probe.labels_updated"
+	EventDeleted           = "probe.deleted"
+)
+
+// ProbeEvent describes a change of a probe, Probe is its state after the
+// change, or before it for EventDeleted. Detail explains alerts.
+type ProbeEvent struct {
+	Type   string
+	Probe  Probe
+	Detail string
+}
+
+// ProbeListener is called synchronously for every event, listeners doing
+// slow work should hand it off to a goroutine
+type ProbeListener func(event ProbeEvent)
+
+var (
+	listenersMu sync.RWMutex
+	listeners   []ProbeListener
+)
+
+// OnProbeEvent registers a listener for the probe lifecycle events
+func OnProbeEvent(listener ProbeListener) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	listeners = append(listeners, listener)
+}
+
+func notify(eventType string, probe *Probe) {
+	notifyDetail(eventType, probe, "")
+}
+
+func notifyDetail(eventType string, probe *Probe, detail string) {
+	listenersMu.RLock()
+	defer listenersMu.RUnlock()
+	for _, listener := range listeners {
+		listener(ProbeEvent{Type: eventType, Probe: *probe, Detail: detail})
+	}
+}
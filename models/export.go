@@ -0,0 +1,72 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// ToSSHConfig renders an OpenSSH client configuration with a Host block per
+// probe, aliased after its FQDN and preceded by its labels in a comment. %n
+// in identityFile is replaced by the alias.
+func ToSSHConfig(probes []*Probe, user string, identityFile string) string {
+	var buf bytes.Buffer
+	buf.WriteString("# generated by sinker_registry_api\n")
+	for _, probe := range probes {
+		alias := probe.FQDN
+		buf.WriteString("\n")
+		if len(probe.Labels) > 0 {
+			fmt.Fprintf(&buf, "# labels %s\n", probe.Labels.String())
+		}
+		fmt.Fprintf(&buf, "Host %s\n", alias)
+		fmt.Fprintf(&buf, "    HostName %s\n", probe.Ipv4)
+		fmt.Fprintf(&buf, "    User %s\n", user)
+		fmt.Fprintf(&buf, "    IdentityFile %s\n", strings.Replace(identityFile, "%n", alias, -1))
+		fmt.Fprintf(&buf, "    IdentitiesOnly yes\n")
+	}
+	return buf.String()
+}
+
+// KnownHostsLines returns the known_hosts entries of a probe, one per
+// pinned host key, or for its single registered host key when none is
+// pinned. Invalid keys are skipped.
+func KnownHostsLines(probe *Probe, pinned []*ProbeHostKey) []string {
+	keys := make([]string, 0, len(pinned))
+	for _, key := range pinned {
+		keys = append(keys, key.Key)
+	}
+	if len(keys) == 0 && probe.SSHHostKey != "" {
+		keys = append(keys, probe.SSHHostKey)
+	}
+	hosts := []string{probe.FQDN, probe.Ipv4}
+	if probe.Ipv6 != "" {
+		hosts = append(hosts, probe.Ipv6)
+	}
+	var lines []string
+	for _, line := range keys {
+		key, err := ParseSSHPublicKey(line)
+		if err != nil {
+			continue
+		}
+		lines = append(lines, strings.Join(hosts, ",")+" "+key.String())
+	}
+	return lines
+}
+
+// ToKnownHosts renders an OpenSSH known_hosts file with the host keys of
+// every probe that registered some, pinned gives the keys by probe id. The
+// entries of a probe with labels are preceded by them in a comment.
+func ToKnownHosts(probes []*Probe, pinned map[string][]*ProbeHostKey) string {
+	var buf bytes.Buffer
+	for _, probe := range probes {
+		lines := KnownHostsLines(probe, pinned[probe.ProbeID])
+		if len(lines) > 0 && len(probe.Labels) > 0 {
+			fmt.Fprintf(&buf, "# %s labels %s\n", probe.FQDN, probe.Labels.String())
+		}
+		for _, line := range lines {
+			buf.WriteString(line)
+			buf.WriteString("\n")
+		}
+	}
+	return buf.String()
+}
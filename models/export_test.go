@@ -0,0 +1,40 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+const testHostKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOmwG1Fq4fnq8NbSEfbAxBWtyBg5QGn5mwSbkIsnlq0A"
+
+// TestToSSHConfig checks every probe gets a Host block aliased after its
+// FQDN, with its identity file named after the alias
+func TestToSSHConfig(t *testing.T) {
+	probes := []*Probe{
+		{FQDN: "madrid.example.com", Ipv4: "192.0.2.160"},
+		{FQDN: "paris.example.com", Ipv4: "192.0.2.161"},
+	}
+	config := ToSSHConfig(probes, "sinker", "~/.ssh/%n.key")
+	expected := "Host paris.example.com\n" +
+		"    HostName 192.0.2.161\n" +
+		"    User sinker\n" +
+		"    IdentityFile ~/.ssh/paris.example.com.key\n" +
+		"    IdentitiesOnly yes\n"
+	if !strings.HasSuffix(config, expected) || strings.Count(config, "Host ") != 2 {
+		t.Errorf("unexpected ssh config\n%s", config)
+	}
+}
+
+// TestToKnownHosts checks the known_hosts entries of the probes list their
+// names and addresses, without the key comments, skipping invalid keys
+func TestToKnownHosts(t *testing.T) {
+	probes := []*Probe{
+		{ProbeID: "keyed", FQDN: "keyed.example.com", Ipv4: "192.0.2.162", Ipv6: "2001:db8::162", SSHHostKey: testHostKey + " root@keyed"},
+		{ProbeID: "invalid", FQDN: "invalid.example.com", Ipv4: "192.0.2.163", SSHHostKey: "ssh-ed25519 not-base64!"},
+		{ProbeID: "keyless", FQDN: "keyless.example.com", Ipv4: "192.0.2.164"},
+	}
+	knownHosts := ToKnownHosts(probes, nil)
+	if knownHosts != "keyed.example.com,192.0.2.162,2001:db8::162 "+testHostKey+"\n" {
+		t.Errorf("unexpected known_hosts\n%s", knownHosts)
+	}
+}
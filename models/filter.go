@@ -0,0 +1,159 @@
+package models
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego/orm"
+)
+
+const (
+	StateEnabled  = "enabled"
+	StateDisabled = "disabled"
+	StateAll      = "all"
+)
+
+// ProbeFilter narrows down the probes returned by the list endpoints,
+// empty fields are not filtered on
+type ProbeFilter struct {
+	Provider     string `json:"provider,omitempty"`
+	Country      string `json:"country,omitempty"`
+	State        string `json:"state,omitempty"`
+	Region       string `json:"region,omitempty"`
+	Zone         string `json:"zone,omitempty"`
+	InstanceType string `json:"instancetype,omitempty"`
+	// Selector is a label selector, see ParseLabelSelector
+	Selector string `json:"selector,omitempty"`
+	// Scope are the only probes the caller sees, nil for all of them
+	Scope *Scope `json:"-"`
+}
+
+// Scope narrows down the probes a caller sees to those of its providers
+// matching its label selector, empty fields are not narrowed on
+type Scope struct {
+	Providers []string
+	Selector  LabelSelector
+}
+
+// idsPerQuery bounds the ids listed in a single query, well below the 999
+// variables older sqlite builds accept
+const idsPerQuery = 500
+
+// chunkIDs splits ProbeIDs into lists short enough for a single query
+func chunkIDs(ProbeIDs []string) [][]string {
+	var chunks [][]string
+	for len(ProbeIDs) > idsPerQuery {
+		chunks = append(chunks, ProbeIDs[:idsPerQuery])
+		ProbeIDs = ProbeIDs[idsPerQuery:]
+	}
+	if len(ProbeIDs) > 0 {
+		chunks = append(chunks, ProbeIDs)
+	}
+	return chunks
+}
+
+// empty reports whether the filter has no criteria at all
+func (f *ProbeFilter) empty() bool {
+	return f.State == "" && f.Provider == "" && f.Country == "" && f.Region == "" && f.Zone == "" && f.InstanceType == "" && f.Selector == ""
+}
+
+// Validate checks the filter values that can be checked without the database
+func (f *ProbeFilter) Validate() error {
+	switch f.State {
+	case "", StateEnabled, StateDisabled, StateAll:
+	default:
+		return fmt.Errorf("invalid state `%s`, expected %s, %s or %s", f.State, StateEnabled, StateDisabled, StateAll)
+	}
+	_, err := ParseLabelSelector(f.Selector)
+	return err
+}
+
+// inScope reports whether probe is within scope, nil for every probe
+func inScope(scope *Scope, probe *Probe) bool {
+	if scope == nil {
+		return true
+	}
+	if !scope.Selector.Matches(probe.Labels) {
+		return false
+	}
+	if len(scope.Providers) == 0 {
+		return true
+	}
+	for _, provider := range scope.Providers {
+		if probe.Provider == provider {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *ProbeFilter) apply(qs orm.QuerySeter) orm.QuerySeter {
+	switch f.State {
+	case StateAll:
+	case StateDisabled:
+		qs = qs.Filter("enabled", false)
+	default:
+		qs = qs.Filter("enabled", true)
+	}
+	if f.Provider != "" {
+		name := f.Provider
+		if provider, err := LookupProvider(f.Provider); err == nil {
+			name = provider.Name
+		}
+		qs = qs.Filter("provider", name)
+	}
+	if f.Country != "" {
+		qs = qs.Filter("country__iexact", f.Country)
+	}
+	if f.Region != "" {
+		qs = qs.Filter("region", f.Region)
+	}
+	if f.Zone != "" {
+		qs = qs.Filter("zone", f.Zone)
+	}
+	if f.InstanceType != "" {
+		qs = qs.Filter("instance_type", f.InstanceType)
+	}
+	if f.Scope != nil && len(f.Scope.Providers) > 0 {
+		qs = qs.Filter("provider__in", f.Scope.Providers)
+	}
+	return qs
+}
+
+// selected drops the probes not matching the label selector of the filter
+// or out of its scope
+func (f *ProbeFilter) selected(probes []*Probe) []*Probe {
+	selector, _ := ParseLabelSelector(f.Selector)
+	if len(selector) == 0 && (f.Scope == nil || len(f.Scope.Selector) == 0) {
+		return probes
+	}
+	selected := probes[:0]
+	for _, probe := range probes {
+		if selector.Matches(probe.Labels) && inScope(f.Scope, probe) {
+			selected = append(selected, probe)
+		}
+	}
+	return selected
+}
+
+// Find returns every probe matching the filter
+func Find(filter ProbeFilter) ([]*Probe, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	var probes []*Probe
+	num, err := filter.apply(o.QueryTable("probe")).Limit(-1).All(&probes)
+	log.Debugf("[models.filter.Find]: Returned Rows Num: %d, %v", num, err)
+	if err != nil {
+		return probes, err
+	}
+	return filter.selected(probes), nil
+}
+
+// State describes whether the probe is enabled or disabled
+func (probe *Probe) State() string {
+	if probe.Enabled {
+		return StateEnabled
+	}
+	return StateDisabled
+}
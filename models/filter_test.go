@@ -0,0 +1,68 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestLabelScope(t *testing.T) {
+	blueAWS := addLabelledProbe(t, "blue-aws.example.com", "192.0.2.60", "AWS", Labels{"team": "blue"})
+	defer Delete(blueAWS.ProbeID)
+	blueVultr := addLabelledProbe(t, "blue-vultr.example.com", "192.0.2.61", "Vultr", Labels{"team": "blue"})
+	defer Delete(blueVultr.ProbeID)
+	redAWS := addLabelledProbe(t, "red-aws.example.com", "192.0.2.62", "AWS", Labels{"team": "red"})
+	defer Delete(redAWS.ProbeID)
+
+	team, err := AddTeam(Team{Name: "blue", Providers: []string{"aws"}, Selector: "team=blue"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddTeam(Team{Name: "invalid", Selector: "team in blue"}); err == nil {
+		t.Error("team added with an invalid selector")
+	}
+	added, err := AddUser("blue-operator", RoleOperator, team.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := Authenticate(added.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scope := user.Scope()
+	if scope == nil {
+		t.Fatal("user of a team with a selector sees every probe")
+	}
+	for probe, sees := range map[*Probe]bool{blueAWS: true, blueVultr: false, redAWS: false} {
+		if user.Sees(probe) != sees {
+			t.Errorf("user sees %s: %t", probe.FQDN, !sees)
+		}
+	}
+
+	probes, err := Find(ProbeFilter{State: StateAll, Scope: scope})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(probes) != 1 || probes[0].ProbeID != blueAWS.ProbeID {
+		t.Errorf("probes found within scope: %v", probes)
+	}
+	if _, err := AddGroup(ProbeGroup{Name: "blue-static", Members: []string{redAWS.ProbeID}}, scope); err == nil {
+		t.Error("group added with a probe out of scope")
+	}
+	if _, err := AddGroup(ProbeGroup{Name: "aws-static", Members: []string{blueAWS.ProbeID, redAWS.ProbeID}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteGroup("aws-static")
+	if probes, err := GroupProbes("aws-static", scope); err != nil || len(probes) != 1 || probes[0].ProbeID != blueAWS.ProbeID {
+		t.Errorf("group probes within scope: %v %v", probes, err)
+	}
+
+	stats, err := GetStats(scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 1 || len(stats.ByProvider) != 1 || stats.ByProvider[0].Key != "AWS" {
+		t.Errorf("stats within scope: %+v", stats)
+	}
+	if stats, err := GetStats(&Scope{Selector: LabelSelector{}}); err != nil || stats.Total < 3 {
+		t.Errorf("stats of an empty scope: %+v %v", stats, err)
+	}
+}
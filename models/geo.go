@@ -0,0 +1,145 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const earthRadiusKm = 6371.0088
+
+// ProbeDistance is a probe along with its great-circle distance to a point
+type ProbeDistance struct {
+	Probe
+	DistanceKm float64 `json:"distance_km"`
+}
+
+// BoundingBox delimits a map viewport, MinLon may be greater than MaxLon
+// when the box crosses the antimeridian
+type BoundingBox struct {
+	MinLon float64
+	MinLat float64
+	MaxLon float64
+	MaxLat float64
+}
+
+// ParseBoundingBox parses a "minLon,minLat,maxLon,maxLat" string
+func ParseBoundingBox(bbox string) (*BoundingBox, error) {
+	parts := strings.Split(bbox, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid bbox `%s`, expected minLon,minLat,maxLon,maxLat", bbox)
+	}
+	var values [4]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(v) {
+			return nil, fmt.Errorf("invalid bbox coordinate `%s`", part)
+		}
+		values[i] = v
+	}
+	box := &BoundingBox{MinLon: values[0], MinLat: values[1], MaxLon: values[2], MaxLat: values[3]}
+	if !validLongitude(box.MinLon) || !validLongitude(box.MaxLon) {
+		return nil, fmt.Errorf("invalid bbox longitude in `%s`", bbox)
+	}
+	if !validLatitude(box.MinLat) || !validLatitude(box.MaxLat) || box.MinLat > box.MaxLat {
+		return nil, fmt.Errorf("invalid bbox latitude in `%s`", bbox)
+	}
+	return box, nil
+}
+
+// Contains reports whether the point lies inside the box
+func (b *BoundingBox) Contains(lat float64, lon float64) bool {
+	if lat < b.MinLat || lat > b.MaxLat {
+		return false
+	}
+	if b.MinLon <= b.MaxLon {
+		return lon >= b.MinLon && lon <= b.MaxLon
+	}
+	return lon >= b.MinLon || lon <= b.MaxLon
+}
+
+func validLatitude(lat float64) bool {
+	return lat >= -90 && lat <= 90
+}
+
+func validLongitude(lon float64) bool {
+	return lon >= -180 && lon <= 180
+}
+
+// Coordinates returns the probe location, ok is false when it is unknown
+func (probe *Probe) Coordinates() (lat float64, lon float64, ok bool) {
+	lat, errLat := strconv.ParseFloat(probe.GeoLatitude, 64)
+	lon, errLon := strconv.ParseFloat(probe.GeoLongitude, 64)
+	if errLat != nil || errLon != nil || math.IsNaN(lat) || math.IsNaN(lon) {
+		return 0, 0, false
+	}
+	if !validLatitude(lat) || !validLongitude(lon) {
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
+
+// Haversine returns the great-circle distance in kilometers between two points
+func Haversine(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// GetNear returns enabled probes ordered by distance to lat, lon. A radiusKm
+// or limit lower or equal than zero disables the respective restriction.
+// Coordinates are stored as text, so distances are computed here instead of
+// in SQL to keep it working on every storage backend.
+func GetNear(lat float64, lon float64, radiusKm float64, limit int) ([]ProbeDistance, error) {
+	if !validLatitude(lat) || !validLongitude(lon) {
+		return nil, fmt.Errorf("invalid coordinates %f,%f", lat, lon)
+	}
+	log.Debugf("[models.geo.GetNear]: looking for probes near %f,%f radius %f", lat, lon, radiusKm)
+	probes, err := Find(ProbeFilter{State: StateEnabled})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ProbeDistance, 0)
+	for _, probe := range probes {
+		plat, plon, ok := probe.Coordinates()
+		if !ok {
+			continue
+		}
+		distance := Haversine(lat, lon, plat, plon)
+		if radiusKm > 0 && distance > radiusKm {
+			continue
+		}
+		result = append(result, ProbeDistance{Probe: *probe, DistanceKm: distance})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].DistanceKm < result[j].DistanceKm
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// GetWithin returns enabled probes located inside the bounding box
+func GetWithin(box *BoundingBox) ([]Probe, error) {
+	log.Debugf("[models.geo.GetWithin]: looking for probes within %+v", box)
+	probes, err := Find(ProbeFilter{State: StateEnabled})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Probe, 0)
+	for _, probe := range probes {
+		lat, lon, ok := probe.Coordinates()
+		if ok && box.Contains(lat, lon) {
+			result = append(result, *probe)
+		}
+	}
+	return result, nil
+}
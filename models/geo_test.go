@@ -0,0 +1,113 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBoundingBox(t *testing.T) {
+	for _, bbox := range []string{"1,2,3", "a,0,1,1", "-181,0,1,1", "0,10,1,5", "0,-91,1,1"} {
+		if _, err := ParseBoundingBox(bbox); err == nil {
+			t.Errorf("bbox %s accepted", bbox)
+		}
+	}
+	box, err := ParseBoundingBox("-10, 35, 5, 44")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !box.Contains(40.4, -3.7) || box.Contains(48.8, 2.3) || box.Contains(40.4, 6) {
+		t.Errorf("unexpected containment in %+v", box)
+	}
+	// a box crossing the antimeridian
+	pacific, err := ParseBoundingBox("170,-50,-170,-30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pacific.Contains(-41.3, 174.8) || !pacific.Contains(-40, -175) || pacific.Contains(-40, 0) {
+		t.Errorf("unexpected containment in %+v", pacific)
+	}
+}
+
+func TestHaversine(t *testing.T) {
+	// Madrid to Paris is about 1053 km
+	if d := Haversine(40.4168, -3.7038, 48.8566, 2.3522); math.Abs(d-1053) > 5 {
+		t.Errorf("Madrid to Paris %f km", d)
+	}
+	if d := Haversine(10, 20, 10, 20); d != 0 {
+		t.Errorf("distance to itself %f km", d)
+	}
+}
+
+// TestGetNear checks the enabled probes located near a point are returned
+// closest first, within the radius and up to the limit
+func TestGetNear(t *testing.T) {
+	madrid := addTestProbe(t, "near-madrid.example.com", "192.0.2.120", "AWS")
+	defer Delete(madrid.ProbeID)
+	paris := addTestProbe(t, "near-paris.example.com", "192.0.2.121", "AWS")
+	defer Delete(paris.ProbeID)
+	paris.GeoLatitude, paris.GeoLongitude = "48.8566", "2.3522"
+	o.Update(paris)
+	off := addTestProbe(t, "near-off.example.com", "192.0.2.122", "AWS")
+	defer Delete(off.ProbeID)
+	Disable(off.ProbeID)
+
+	near, err := GetNear(41.39, 2.17, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for _, probe := range near {
+		switch probe.ProbeID {
+		case madrid.ProbeID, paris.ProbeID, off.ProbeID:
+			found = append(found, probe.ProbeID)
+		}
+	}
+	if len(found) != 2 || found[0] != madrid.ProbeID || found[1] != paris.ProbeID {
+		t.Errorf("probes near Barcelona %v, expected Madrid then Paris", found)
+	}
+	if near, _ := GetNear(48.85, 2.35, 50, 0); len(near) != 1 || near[0].ProbeID != paris.ProbeID {
+		t.Errorf("probes within 50 km of Paris %v", near)
+	}
+	if near, _ := GetNear(41.39, 2.17, 0, 1); len(near) != 1 {
+		t.Errorf("limit ignored %v", near)
+	}
+	if _, err := GetNear(91, 0, 0, 0); err == nil {
+		t.Error("invalid latitude accepted")
+	}
+}
+
+// TestGetWithinWholeFleet checks every enabled probe inside a box is
+// returned, past the default row limit of the database queries
+func TestGetWithinWholeFleet(t *testing.T) {
+	count := 1010
+	probes := make([]Probe, count)
+	for i := range probes {
+		probes[i] = Probe{
+			ProbeID:      fmt.Sprintf("bulk-geo-%d", i),
+			FQDN:         fmt.Sprintf("bulk-geo-%d.example.com", i),
+			Provider:     "AWS",
+			GeoLatitude:  "-75.1",
+			GeoLongitude: fmt.Sprintf("%f", 100+float64(i)/100),
+			Enabled:      true,
+			CreatedAt:    time.Now(),
+			UpdatedAt:    time.Now(),
+		}
+	}
+	if _, err := o.InsertMulti(100, probes); err != nil {
+		t.Fatal(err)
+	}
+	defer o.QueryTable("probe").Filter("probe_i_d__startswith", "bulk-geo-").Delete()
+
+	within, err := GetWithin(&BoundingBox{MinLon: 99, MinLat: -76, MaxLon: 111, MaxLat: -75})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(within) != count {
+		t.Errorf("%d probes within the box, expected %d", len(within), count)
+	}
+	if near, err := GetNear(-75.1, 105, 0, 0); err != nil || len(near) < count {
+		t.Errorf("%d probes near the box, expected %d at least: %v", len(near), count, err)
+	}
+}
@@ -0,0 +1,48 @@
+package models
+
+// FeatureCollection is a GeoJSON (RFC 7946) feature collection
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature is a GeoJSON feature with a Point geometry
+type Feature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Geometry   Point                  `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Point is a GeoJSON point, coordinates are in longitude, latitude order
+type Point struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// ToGeoJSON builds a feature collection with one point per probe, probes
+// whose location is unknown are skipped
+func ToGeoJSON(probes []*Probe) FeatureCollection {
+	collection := FeatureCollection{Type: "FeatureCollection", Features: make([]Feature, 0, len(probes))}
+	for _, probe := range probes {
+		lat, lon, ok := probe.Coordinates()
+		if !ok {
+			continue
+		}
+		collection.Features = append(collection.Features, Feature{
+			Type:     "Feature",
+			ID:       probe.ProbeID,
+			Geometry: Point{Type: "Point", Coordinates: [2]float64{lon, lat}},
+			Properties: map[string]interface{}{
+				"ProbeID":  probe.ProbeID,
+				"fqdn":     probe.FQDN,
+				"provider": probe.Provider,
+				"country":  probe.Country,
+				"region":   probe.Region,
+				"state":    probe.State(),
+				"labels":   probe.Labels,
+			},
+		})
+	}
+	return collection
+}
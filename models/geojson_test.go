@@ -0,0 +1,30 @@
+package models
+
+import "testing"
+
+// TestToGeoJSON checks probes are given as points in longitude, latitude
+// order, skipping the ones whose location is unknown
+func TestToGeoJSON(t *testing.T) {
+	probes := []*Probe{
+		{ProbeID: "located", FQDN: "located.example.com", Provider: "AWS", Country: "ES", GeoLatitude: "40.4", GeoLongitude: "-3.7", Enabled: true},
+		{ProbeID: "unknown", FQDN: "unknown.example.com", Provider: "AWS", GeoLatitude: "", GeoLongitude: ""},
+		{ProbeID: "invalid", FQDN: "invalid.example.com", Provider: "AWS", GeoLatitude: "91", GeoLongitude: "0"},
+	}
+	collection := ToGeoJSON(probes)
+	if collection.Type != "FeatureCollection" || len(collection.Features) != 1 {
+		t.Fatalf("unexpected collection %+v", collection)
+	}
+	feature := collection.Features[0]
+	if feature.Type != "Feature" || feature.ID != "located" || feature.Geometry.Type != "Point" {
+		t.Errorf("unexpected feature %+v", feature)
+	}
+	if feature.Geometry.Coordinates != [2]float64{-3.7, 40.4} {
+		t.Errorf("coordinates not in longitude, latitude order: %v", feature.Geometry.Coordinates)
+	}
+	if feature.Properties["fqdn"] != "located.example.com" || feature.Properties["country"] != "ES" || feature.Properties["state"] != probes[0].State() {
+		t.Errorf("unexpected properties %v", feature.Properties)
+	}
+	if empty := ToGeoJSON(nil); empty.Features == nil || len(empty.Features) != 0 {
+		t.Errorf("empty fleet not given as an empty feature list: %+v", empty)
+	}
+}
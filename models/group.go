@@ -0,0 +1,358 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego/orm"
+)
+
+var probeGroupNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9_.]{0,62}[a-z0-9])?$`)
+
+// ProbeGroup names a set of probes, either static, listing its members, or
+// dynamic, holding the probes matching its filter. A group stays of the
+// kind it was created with.
+type ProbeGroup struct {
+	ID          int64        `orm:"pk;auto;column(id)" json:"id"`
+	Name        string       `orm:"size(64);unique" json:"name"`
+	Description string       `orm:"size(256)" json:"description"`
+	Dynamic     bool         `json:"dynamic"`
+	Filter      *ProbeFilter `orm:"-" json:"filter,omitempty"`
+	Query       string       `orm:"type(text);null" json:"-"`
+	Members     []string     `orm:"-" json:"members,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// ProbeGroupMember is a probe of a static group
+type ProbeGroupMember struct {
+	ID      int64  `orm:"pk;auto;column(id)" json:"id"`
+	Group   int64  `orm:"column(group_id);index" json:"group"`
+	ProbeID string `orm:"size(100);column(probe_id);index" json:"ProbeID"`
+}
+
+// TableUnique keeps a probe from being twice a member of a group
+func (m *ProbeGroupMember) TableUnique() [][]string {
+	return [][]string{{"Group", "ProbeID"}}
+}
+
+func (group *ProbeGroup) pack() error {
+	group.Query = ""
+	if !group.Dynamic {
+		return nil
+	}
+	if group.Filter == nil || group.Filter.empty() {
+		return fmt.Errorf("dynamic group %s needs a filter", group.Name)
+	}
+	group.Filter.Scope = nil
+	if err := group.Filter.Validate(); err != nil {
+		return err
+	}
+	query, err := json.Marshal(group.Filter)
+	if err != nil {
+		return err
+	}
+	group.Query = string(query)
+	return nil
+}
+
+func (group *ProbeGroup) unpack() error {
+	if !group.Dynamic {
+		return nil
+	}
+	group.Filter = &ProbeFilter{}
+	return json.Unmarshal([]byte(group.Query), group.Filter)
+}
+
+// load unpacks a group read from the database, along with its members when
+// static
+func (group *ProbeGroup) load() error {
+	if !group.Dynamic {
+		var members orm.ParamsList
+		if _, err := o.QueryTable("probe_group_member").Filter("group_id", group.ID).OrderBy("probe_id").Limit(-1).ValuesFlat(&members, "probe_id"); err != nil {
+			return err
+		}
+		group.Members = []string{}
+		for _, member := range members {
+			group.Members = append(group.Members, fmt.Sprint(member))
+		}
+		return nil
+	}
+	return group.unpack()
+}
+
+// checkMembers checks the probes exist and are within scope, nil for every
+// probe
+func checkMembers(ProbeIDs []string, scope *Scope) error {
+	for _, ProbeID := range ProbeIDs {
+		probe, err := GetByID(ProbeID)
+		if err != nil || !inScope(scope, probe) {
+			return fmt.Errorf("probe %s not found", ProbeID)
+		}
+	}
+	return nil
+}
+
+// AddGroup creates a group, along with its members when static. The
+// members must be within scope, nil for every probe.
+func AddGroup(group ProbeGroup, scope *Scope) (*ProbeGroup, error) {
+	log.Infof("[models.group.AddGroup]: adding group %s", group.Name)
+	if !probeGroupNameRegexp.MatchString(group.Name) {
+		return nil, fmt.Errorf("invalid group name `%s`, expected up to 64 lowercase alphanumerics, '-', '_' or '.'", group.Name)
+	}
+	group.Dynamic = group.Filter != nil
+	if group.Dynamic && len(group.Members) > 0 {
+		return nil, errors.New("a group has either a filter or members")
+	}
+	if err := group.pack(); err != nil {
+		return nil, err
+	}
+	if err := checkMembers(group.Members, scope); err != nil {
+		return nil, err
+	}
+	group.CreatedAt = time.Now()
+	group.UpdatedAt = group.CreatedAt
+	tx := orm.NewOrm()
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Insert(&group); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("unable to add group %s: %s", group.Name, err)
+	}
+	if err := insertMembers(tx, group.ID, group.Members); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetGroup(group.Name, scope)
+}
+
+// insertMembers adds probes to a static group, skipping its members
+func insertMembers(db orm.Ormer, group int64, ProbeIDs []string) error {
+	for _, ProbeID := range ProbeIDs {
+		if db.QueryTable("probe_group_member").Filter("group_id", group).Filter("probe_id", ProbeID).Exist() {
+			continue
+		}
+		if _, err := db.Insert(&ProbeGroupMember{Group: group, ProbeID: ProbeID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restrict drops the members of a static group outside scope, nil for
+// every probe
+func (group *ProbeGroup) restrict(scope *Scope) error {
+	if group.Dynamic || scope == nil {
+		return nil
+	}
+	probes, err := memberProbes(group.Members, scope)
+	if err != nil {
+		return err
+	}
+	visible := make(map[string]bool)
+	for _, probe := range probes {
+		visible[probe.ProbeID] = true
+	}
+	members := []string{}
+	for _, member := range group.Members {
+		if visible[member] {
+			members = append(members, member)
+		}
+	}
+	group.Members = members
+	return nil
+}
+
+// GetGroup returns a group by name, along with its members within scope,
+// nil for every probe
+func GetGroup(name string, scope *Scope) (*ProbeGroup, error) {
+	group := ProbeGroup{Name: name}
+	if err := o.Read(&group, "Name"); err != nil {
+		if err == orm.ErrNoRows {
+			return nil, fmt.Errorf("group %s not found", name)
+		}
+		return nil, err
+	}
+	if err := group.load(); err != nil {
+		return nil, err
+	}
+	if err := group.restrict(scope); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// GetAllGroups returns every group, along with their members within scope,
+// nil for every probe
+func GetAllGroups(scope *Scope) ([]*ProbeGroup, error) {
+	groups := []*ProbeGroup{}
+	if _, err := o.QueryTable("probe_group").OrderBy("name").Limit(-1).All(&groups); err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		if err := group.load(); err != nil {
+			return nil, err
+		}
+		if err := group.restrict(scope); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// GroupWithinScope reports whether every probe of a group is within scope,
+// nil for every probe. Only such groups may be changed by the caller.
+func GroupWithinScope(name string, scope *Scope) (bool, error) {
+	probes, err := GroupProbes(name, nil)
+	if err != nil {
+		return false, err
+	}
+	for _, probe := range probes {
+		if !inScope(scope, probe) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// UpdateGroup replaces the description of a group, and the filter of a
+// dynamic one
+func UpdateGroup(name string, update ProbeGroup) (*ProbeGroup, error) {
+	log.Infof("[models.group.UpdateGroup]: updating group %s", name)
+	group, err := GetGroup(name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if update.Filter != nil && !group.Dynamic {
+		return nil, fmt.Errorf("group %s is static, it has no filter", name)
+	}
+	group.Description = update.Description
+	if update.Filter != nil {
+		group.Filter = update.Filter
+	}
+	if err := group.pack(); err != nil {
+		return nil, err
+	}
+	group.UpdatedAt = time.Now()
+	if _, err := o.Update(group, "Description", "Query", "UpdatedAt"); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// DeleteGroup removes a group, its members are left alone
+func DeleteGroup(name string) error {
+	log.Infof("[models.group.DeleteGroup]: removing group %s", name)
+	group, err := GetGroup(name, nil)
+	if err != nil {
+		return err
+	}
+	tx := orm.NewOrm()
+	if err := tx.Begin(); err != nil {
+		return err
+	}
+	if _, err := tx.QueryTable("probe_group_member").Filter("group_id", group.ID).Delete(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Delete(group); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddGroupMembers adds probes to a static group, the ones already members
+// are ignored. The probes must be within scope, nil for every probe.
+func AddGroupMembers(name string, ProbeIDs []string, scope *Scope) (*ProbeGroup, error) {
+	log.Infof("[models.group.AddGroupMembers]: adding %v to group %s", ProbeIDs, name)
+	group, err := GetGroup(name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if group.Dynamic {
+		return nil, fmt.Errorf("group %s is dynamic, its members are the probes matching its filter", name)
+	}
+	if err := checkMembers(ProbeIDs, scope); err != nil {
+		return nil, err
+	}
+	tx := orm.NewOrm()
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	if err := insertMembers(tx, group.ID, ProbeIDs); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetGroup(name, scope)
+}
+
+// RemoveGroupMembers removes probes from a static group, the ones not
+// members are ignored. The group is returned with its members within
+// scope, nil for every probe.
+func RemoveGroupMembers(name string, ProbeIDs []string, scope *Scope) (*ProbeGroup, error) {
+	log.Infof("[models.group.RemoveGroupMembers]: removing %v from group %s", ProbeIDs, name)
+	group, err := GetGroup(name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if group.Dynamic {
+		return nil, fmt.Errorf("group %s is dynamic, its members are the probes matching its filter", name)
+	}
+	for _, chunk := range chunkIDs(ProbeIDs) {
+		if _, err := o.QueryTable("probe_group_member").Filter("group_id", group.ID).Filter("probe_id__in", chunk).Delete(); err != nil {
+			return nil, err
+		}
+	}
+	return GetGroup(name, scope)
+}
+
+// GroupProbes returns the probes of a group within scope, nil for every
+// probe, whatever their state unless the filter of the group has one
+func GroupProbes(name string, scope *Scope) ([]*Probe, error) {
+	group, err := GetGroup(name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if group.Dynamic {
+		filter := *group.Filter
+		if filter.State == "" {
+			filter.State = StateAll
+		}
+		filter.Scope = scope
+		return Find(filter)
+	}
+	return memberProbes(group.Members, scope)
+}
+
+// memberProbes returns the probes of ProbeIDs within scope, nil for every
+// probe, whatever their state
+func memberProbes(ProbeIDs []string, scope *Scope) ([]*Probe, error) {
+	probes := []*Probe{}
+	filter := ProbeFilter{State: StateAll, Scope: scope}
+	for _, chunk := range chunkIDs(ProbeIDs) {
+		var found []*Probe
+		if _, err := filter.apply(o.QueryTable("probe")).Filter("probe_i_d__in", chunk).Limit(-1).All(&found); err != nil {
+			return nil, err
+		}
+		probes = append(probes, filter.selected(found)...)
+	}
+	return probes, nil
+}
+
+// removeGroupMemberships removes a deleted probe from the static groups
+func removeGroupMemberships(ProbeID string) error {
+	_, err := o.QueryTable("probe_group_member").Filter("probe_id", ProbeID).Delete()
+	return err
+}
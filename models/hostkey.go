@@ -0,0 +1,319 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego/orm"
+)
+
+// Sources of pinned host keys
+const (
+	HostKeyRegistered = "registered"
+	HostKeyTOFU       = "tofu"
+)
+
+// Outcomes of comparing a scanned host key with the pinned one
+const (
+	ScanPinned   = "pinned"
+	ScanMatch    = "match"
+	ScanMismatch = "mismatch"
+	ScanUnpinned = "unpinned"
+)
+
+const hostKeyScanTimeout = 15 * time.Second
+
+// ProbeHostKey is a host key pinned for a probe, one per key type
+type ProbeHostKey struct {
+	ID          int64     `orm:"pk;auto;column(id)" json:"-"`
+	ProbeID     string    `orm:"size(100);column(probe_id);index" json:"ProbeID"`
+	Type        string    `orm:"size(32)" json:"type"`
+	Key         string    `orm:"size(1024)" json:"key"`
+	Fingerprint string    `orm:"size(64)" json:"fingerprint"`
+	Source      string    `orm:"size(16)" json:"source"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (k *ProbeHostKey) TableUnique() [][]string {
+	return [][]string{{"ProbeID", "Type"}}
+}
+
+// HostKeyScan is a host key presented by a probe compared with the pinned one
+type HostKeyScan struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	Pinned      string `json:"pinned,omitempty"`
+	Status      string `json:"status"`
+}
+
+// hostKeyPreference orders key types when a single host key is needed
+var hostKeyPreference = []string{
+	"ssh-ed25519", "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521", "ssh-rsa",
+}
+
+var (
+	sshKeyscan  = "ssh-keyscan"
+	sshPort     = 22
+	hostKeyTOFU bool
+)
+
+// ConfigureHostKeyScan sets the ssh-keyscan binary and port used to scan
+// probes, and whether scans pin the keys of types not pinned yet
+func ConfigureHostKeyScan(keyscan string, port int, tofu bool) {
+	sshKeyscan = keyscan
+	sshPort = port
+	hostKeyTOFU = tofu
+}
+
+// Fingerprint returns the OpenSSH SHA256 fingerprint of the key
+func (key *SSHPublicKey) Fingerprint() string {
+	sum := sha256.Sum256(key.Blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+func newProbeHostKey(ProbeID string, key *SSHPublicKey, source string) *ProbeHostKey {
+	return &ProbeHostKey{
+		ProbeID:     ProbeID,
+		Type:        key.Type,
+		Key:         key.String(),
+		Fingerprint: key.Fingerprint(),
+		Source:      source,
+		CreatedAt:   time.Now(),
+	}
+}
+
+// GetHostKeys returns the host keys pinned for a probe
+func GetHostKeys(ProbeID string) ([]*ProbeHostKey, error) {
+	var keys []*ProbeHostKey
+	_, err := o.QueryTable("probe_host_key").Filter("probe_id", ProbeID).OrderBy("type").All(&keys)
+	return keys, err
+}
+
+// GetAllHostKeys returns the pinned host keys of every probe by probe id
+func GetAllHostKeys() (map[string][]*ProbeHostKey, error) {
+	var keys []*ProbeHostKey
+	if _, err := o.QueryTable("probe_host_key").OrderBy("probe_id", "type").Limit(-1).All(&keys); err != nil {
+		return nil, err
+	}
+	byProbe := make(map[string][]*ProbeHostKey)
+	for _, key := range keys {
+		byProbe[key.ProbeID] = append(byProbe[key.ProbeID], key)
+	}
+	return byProbe, nil
+}
+
+func preferredHostKey(keys []*ProbeHostKey) string {
+	for _, keyType := range hostKeyPreference {
+		for _, key := range keys {
+			if key.Type == keyType {
+				return key.Key
+			}
+		}
+	}
+	return ""
+}
+
+// pinHostKeys pins keys for probe, replacing the pinned keys of the same
+// types, and every other one when replaceAll is set. Replaced keys raise an
+// EventHostKeyChanged alert, kept in the change feed as the audit trail.
+func pinHostKeys(probe *Probe, keys []*SSHPublicKey, source string, replaceAll bool) ([]*ProbeHostKey, error) {
+	byType := make(map[string]*SSHPublicKey)
+	for _, key := range keys {
+		if _, ok := byType[key.Type]; ok {
+			return nil, fmt.Errorf("more than one %s host key given", key.Type)
+		}
+		byType[key.Type] = key
+	}
+	pinned, err := GetHostKeys(probe.ProbeID)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []string
+	tx := orm.NewOrm()
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	for _, old := range pinned {
+		key, replaced := byType[old.Type]
+		if !replaced && !replaceAll {
+			continue
+		}
+		if replaced && key.String() == old.Key {
+			delete(byType, old.Type)
+			continue
+		}
+		if replaced {
+			changes = append(changes, fmt.Sprintf("%s host key changed from %s to %s", old.Type, old.Fingerprint, key.Fingerprint()))
+		} else {
+			changes = append(changes, fmt.Sprintf("%s host key %s removed", old.Type, old.Fingerprint))
+		}
+		if _, err := tx.Delete(old); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	for _, keyType := range hostKeyPreference {
+		if key, ok := byType[keyType]; ok {
+			if _, err := tx.Insert(newProbeHostKey(probe.ProbeID, key, source)); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	current, err := GetHostKeys(probe.ProbeID)
+	if err != nil {
+		return nil, err
+	}
+	if len(byType) == 0 && len(changes) == 0 {
+		return current, nil
+	}
+	probe.SSHHostKey = preferredHostKey(current)
+	probe.UpdatedAt = time.Now()
+	if _, err := o.Update(probe, "SSHHostKey", "UpdatedAt"); err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		log.Warningf("[models.hostkey.pinHostKeys]: probe %s %s", probe.ProbeID, change)
+		notifyDetail(EventHostKeyChanged, probe, change)
+	}
+	notify(EventHostKeyUpdated, probe)
+	return current, nil
+}
+
+// SetHostKeys replaces the host keys pinned for a probe with those it
+// registers, in authorized_keys format
+func SetHostKeys(ProbeID string, lines []string) ([]*ProbeHostKey, error) {
+	log.Infof("[models.hostkey.SetHostKeys]: registering %d host keys for probe %s", len(lines), ProbeID)
+	probe, err := GetByID(ProbeID)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("no host keys given")
+	}
+	keys := make([]*SSHPublicKey, 0, len(lines))
+	for _, line := range lines {
+		key, err := ParseSSHPublicKey(line)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return pinHostKeys(probe, keys, HostKeyRegistered, true)
+}
+
+// hostKeyEvents are the events making up the audit trail of the host keys
+// of a probe
+var hostKeyEvents = []string{EventHostKeyUpdated, EventHostKeyChanged, EventHostKeyMismatch}
+
+// GetHostKeyChanges returns the audit trail of the host keys of a probe,
+// the host key events persisted in the change feed, oldest first
+func GetHostKeyChanges(ProbeID string) ([]*ProbeChange, error) {
+	changes := []*ProbeChange{}
+	_, err := o.QueryTable("probe_change").Filter("probe_id", ProbeID).Filter("event__in", hostKeyEvents).OrderBy("id").Limit(-1).All(&changes)
+	return changes, err
+}
+
+// keyscan runs ssh-keyscan against host and returns the host keys found
+func keyscan(host string) ([]*SSHPublicKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), hostKeyScanTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, sshKeyscan, "-T", "10", "-p", strconv.Itoa(sshPort), "-t", "ed25519,ecdsa,rsa", host)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ssh-keyscan %s failed: %s %s", host, err, strings.TrimSpace(stderr.String()))
+	}
+	var keys []*SSHPublicKey
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		fields := strings.Fields(line)
+		if len(fields) < 3 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := ParseSSHPublicKey(strings.Join(fields[1:], " "))
+		if err != nil {
+			log.Warningf("[models.hostkey.keyscan]: ignoring host key of %s: %s", host, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no host keys found on %s:%d", host, sshPort)
+	}
+	return keys, nil
+}
+
+// ScanHostKeys connects to a probe and compares the host keys it presents
+// with the pinned ones. Mismatches raise an EventHostKeyMismatch alert and
+// are never pinned, keys of types not pinned yet are pinned in TOFU mode.
+func ScanHostKeys(ProbeID string) ([]*HostKeyScan, error) {
+	probe, err := GetByID(ProbeID)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := keyscan(probe.Ipv4)
+	if err != nil {
+		return nil, err
+	}
+	pinned, err := GetHostKeys(ProbeID)
+	if err != nil {
+		return nil, err
+	}
+	pinnedByType := make(map[string]*ProbeHostKey)
+	for _, key := range pinned {
+		pinnedByType[key.Type] = key
+	}
+
+	var scans []*HostKeyScan
+	var unpinned []*SSHPublicKey
+	for _, key := range keys {
+		scan := &HostKeyScan{Type: key.Type, Fingerprint: key.Fingerprint()}
+		scans = append(scans, scan)
+		old, ok := pinnedByType[key.Type]
+		switch {
+		case !ok && hostKeyTOFU:
+			scan.Status = ScanPinned
+			unpinned = append(unpinned, key)
+		case !ok:
+			scan.Status = ScanUnpinned
+		case old.Key == key.String():
+			scan.Status = ScanMatch
+			scan.Pinned = old.Fingerprint
+		default:
+			scan.Status = ScanMismatch
+			scan.Pinned = old.Fingerprint
+			detail := fmt.Sprintf("%s host key presented by %s is %s, pinned %s", key.Type, probe.Ipv4, scan.Fingerprint, old.Fingerprint)
+			log.Warningf("[models.hostkey.ScanHostKeys]: probe %s %s", ProbeID, detail)
+			notifyDetail(EventHostKeyMismatch, probe, detail)
+		}
+	}
+	if len(unpinned) > 0 {
+		if _, err := pinHostKeys(probe, unpinned, HostKeyTOFU, false); err != nil {
+			return nil, err
+		}
+	}
+	return scans, nil
+}
+
+// deleteHostKeys forgets the host keys of a deleted probe
+func deleteHostKeys(ProbeID string) error {
+	_, err := o.QueryTable("probe_host_key").Filter("probe_id", ProbeID).Delete()
+	return err
+}
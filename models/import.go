@@ -0,0 +1,286 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego/orm"
+)
+
+// Import formats
+const (
+	ImportCSV    = "csv"
+	ImportJSON   = "json"
+	ImportNDJSON = "ndjson"
+)
+
+// importMaxRows bounds the size of a single import transaction
+const importMaxRows = 1000
+
+// ImportRow is a probe to import along with the line of the file it was
+// read from
+type ImportRow struct {
+	Line  int
+	Probe Probe
+	Err   error
+}
+
+// ImportRowResult is the outcome of importing a row
+type ImportRowResult struct {
+	Line    int    `json:"line"`
+	ProbeID string `json:"ProbeID,omitempty"`
+	FQDN    string `json:"fqdn"`
+	Ipv4    string `json:"ipv4"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ImportResult is the outcome of an import, no probe is imported unless
+// every row is valid. OutOfScope counts the failed rows outside the scope
+// of the caller.
+type ImportResult struct {
+	DryRun     bool              `json:"dryrun"`
+	Imported   int               `json:"imported"`
+	Failed     int               `json:"failed"`
+	OutOfScope int               `json:"outofscope,omitempty"`
+	Rows       []ImportRowResult `json:"rows"`
+}
+
+// DetectImportFormat guesses the format of body from its content type, or
+// its first character when the content type is not conclusive
+func DetectImportFormat(contentType string, body []byte) string {
+	switch {
+	case strings.Contains(contentType, "csv"):
+		return ImportCSV
+	case strings.Contains(contentType, "ndjson"), strings.Contains(contentType, "jsonlines"):
+		return ImportNDJSON
+	}
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		return ImportJSON
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return ImportNDJSON
+	}
+	return ImportCSV
+}
+
+// ParseImport reads the probes of body, rows that can not be decoded are
+// returned with Err set. The error is only set when the file as a whole is
+// unreadable.
+func ParseImport(format string, body []byte) ([]ImportRow, error) {
+	var rows []ImportRow
+	var err error
+	switch format {
+	case ImportCSV:
+		rows, err = parseCSV(body)
+	case ImportJSON:
+		rows, err = parseJSONArray(body)
+	case ImportNDJSON:
+		rows, err = parseNDJSON(body)
+	default:
+		return nil, fmt.Errorf("unknown import format `%s`, expected csv, json or ndjson", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("no probes to import")
+	}
+	if len(rows) > importMaxRows {
+		return nil, fmt.Errorf("too many probes to import, %d at most", importMaxRows)
+	}
+	return rows, nil
+}
+
+func newImportRow(line int, object []byte) ImportRow {
+	row := ImportRow{Line: line}
+	row.Probe.SetDefaults()
+	if err := json.Unmarshal(object, &row.Probe); err != nil {
+		row.Err = err
+	}
+	return row
+}
+
+// csvTypedColumns are decoded as numbers and booleans rather than strings
+var csvTypedColumns = map[string]bool{"monthlycost": true, "enabled": true, "labels": true}
+
+func parseCSV(body []byte) ([]ImportRow, error) {
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read csv header: %s", err)
+	}
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(column))
+	}
+	var rows []ImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, err
+			}
+			rows = append(rows, ImportRow{Line: parseErr.StartLine, Err: parseErr.Err})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		fields := make(map[string]interface{}, len(header))
+		var fieldErr error
+		for i, column := range header {
+			value := strings.TrimSpace(record[i])
+			switch {
+			case value == "" && csvTypedColumns[column]:
+			case column == "monthlycost":
+				fields[column], fieldErr = strconv.ParseFloat(value, 64)
+			case column == "enabled":
+				fields[column], fieldErr = strconv.ParseBool(value)
+			case column == "labels":
+				fields[column], fieldErr = ParseLabels(value)
+			default:
+				fields[column] = value
+			}
+			if fieldErr != nil {
+				fieldErr = fmt.Errorf("invalid %s `%s`", column, value)
+				break
+			}
+		}
+		if fieldErr != nil {
+			rows = append(rows, ImportRow{Line: line, Err: fieldErr})
+			continue
+		}
+		object, _ := json.Marshal(fields)
+		rows = append(rows, newImportRow(line, object))
+	}
+	return rows, nil
+}
+
+func parseJSONArray(body []byte) ([]ImportRow, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return nil, errors.New("expected a json array of probes")
+	}
+	var rows []ImportRow
+	for decoder.More() {
+		var object json.RawMessage
+		offset := decoder.InputOffset()
+		if err := decoder.Decode(&object); err != nil {
+			return nil, fmt.Errorf("invalid json at line %d: %s", lineAt(body, offset), err)
+		}
+		rows = append(rows, newImportRow(lineAt(body, offset), object))
+	}
+	return rows, nil
+}
+
+// lineAt returns the line of the first value at or after offset
+func lineAt(body []byte, offset int64) int {
+	for offset < int64(len(body)) && strings.IndexByte(" \t\r\n,", body[offset]) >= 0 {
+		offset++
+	}
+	return bytes.Count(body[:offset], []byte("\n")) + 1
+}
+
+func parseNDJSON(body []byte) ([]ImportRow, error) {
+	var rows []ImportRow
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		object := bytes.TrimSpace(scanner.Bytes())
+		if len(object) == 0 {
+			continue
+		}
+		rows = append(rows, newImportRow(line, append([]byte{}, object...)))
+	}
+	return rows, scanner.Err()
+}
+
+// checkRegistered rejects probes whose FQDN or IPv4 address is already
+// registered, unlike Validate it also looks at disabled probes
+func checkRegistered(probe Probe) error {
+	if o.QueryTable("probe").Filter("FQDN__iexact", probe.FQDN).Exist() {
+		return fmt.Errorf("FQDN name already registered %s", probe.FQDN)
+	}
+	if o.QueryTable("probe").Filter("Ipv4", probe.Ipv4).Exist() {
+		return fmt.Errorf("IPv4 address already registered %s", probe.Ipv4)
+	}
+	return nil
+}
+
+// Import validates every row and, unless dryRun is set or any row is
+// invalid or outside scope, nil for every probe, registers all of them in
+// a single transaction
+func Import(rows []ImportRow, dryRun bool, scope *Scope) (*ImportResult, error) {
+	log.Infof("[models.import.Import]: importing %d probes, dry run %t", len(rows), dryRun)
+	result := &ImportResult{DryRun: dryRun, Rows: make([]ImportRowResult, len(rows))}
+	fqdns := make(map[string]int)
+	ipv4s := make(map[string]int)
+	for i := range rows {
+		row := &rows[i]
+		if row.Err == nil {
+			row.Err = prepare(&row.Probe)
+		}
+		if row.Err == nil && !inScope(scope, &row.Probe) {
+			row.Err = fmt.Errorf("probe %s of provider %s is outside the scope of the caller", row.Probe.FQDN, row.Probe.Provider)
+			result.OutOfScope++
+		}
+		if row.Err == nil {
+			row.Err = checkRegistered(row.Probe)
+		}
+		if row.Err == nil {
+			fqdn := strings.ToLower(row.Probe.FQDN)
+			if line, ok := fqdns[fqdn]; ok {
+				row.Err = fmt.Errorf("FQDN name %s already given at line %d", row.Probe.FQDN, line)
+			} else if line, ok := ipv4s[row.Probe.Ipv4]; ok {
+				row.Err = fmt.Errorf("IPv4 address %s already given at line %d", row.Probe.Ipv4, line)
+			} else {
+				fqdns[fqdn] = row.Line
+				ipv4s[row.Probe.Ipv4] = row.Line
+			}
+		}
+		result.Rows[i] = ImportRowResult{Line: row.Line, FQDN: row.Probe.FQDN, Ipv4: row.Probe.Ipv4}
+		if row.Err != nil {
+			result.Rows[i].Error = row.Err.Error()
+			result.Failed++
+		}
+	}
+	if dryRun || result.Failed > 0 {
+		return result, nil
+	}
+
+	if dnsVerifyOnRegister {
+		for i := range rows {
+			verifyDNS(&rows[i].Probe)
+		}
+	}
+	tx := orm.NewOrm()
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		if _, err := tx.Insert(&rows[i].Probe); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("unable to import line %d: %s", rows[i].Line, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	result.Imported = len(rows)
+	for i := range rows {
+		result.Rows[i].ProbeID = rows[i].Probe.ProbeID
+		notify(EventRegistered, &rows[i].Probe)
+	}
+	return result, nil
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestDetectImportFormat checks the format is taken from the content type,
+// or guessed from the body otherwise
+func TestDetectImportFormat(t *testing.T) {
+	cases := []struct {
+		contentType string
+		body        string
+		format      string
+	}{
+		{"text/csv", `[{"fqdn": "a"}]`, ImportCSV},
+		{"application/x-ndjson", "", ImportNDJSON},
+		{"application/json", "  [\n{}]", ImportJSON},
+		{"", `{"fqdn": "a"}`, ImportNDJSON},
+		{"", "fqdn,ipv4,provider\n", ImportCSV},
+	}
+	for _, c := range cases {
+		if format := DetectImportFormat(c.contentType, []byte(c.body)); format != c.format {
+			t.Errorf("format of %q as %s detected as %s, expected %s", c.body, c.contentType, format, c.format)
+		}
+	}
+}
+
+// TestImportReportsLines checks every invalid row is reported along with the
+// line it was read from, probes given twice included, and that nothing is
+// imported unless every row is valid
+func TestImportReportsLines(t *testing.T) {
+	registered := addTestProbe(t, "imported-before.example.com", "192.0.2.170", "AWS")
+	defer Delete(registered.ProbeID)
+	body := "fqdn,ipv4,provider,monthlycost\n" +
+		"imported-one.example.com,192.0.2.171,AWS,5\n" +
+		"IMPORTED-ONE.example.com,192.0.2.172,AWS,5\n" +
+		"imported-two.example.com,192.0.2.171,AWS,5\n" +
+		"imported-before.example.com,192.0.2.173,AWS,5\n" +
+		"imported-three.example.com,192.0.2.174,AWS,cheap\n" +
+		"imported-four.example.com,192.0.2.175,Nowhere,5\n"
+	rows, err := ParseImport(ImportCSV, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Import(rows, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 0 || result.Failed != 5 || len(result.Rows) != 6 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	expected := map[int]string{
+		2: "",
+		3: "already given at line 2",
+		4: "already given at line 2",
+		5: "already registered",
+		6: "invalid monthlycost",
+		7: "invalid provider",
+	}
+	for _, row := range result.Rows {
+		message, ok := expected[row.Line]
+		if !ok || message == "" && row.Error != "" || !strings.Contains(row.Error, message) {
+			t.Errorf("line %d reported with error %q, expected %q", row.Line, row.Error, message)
+		}
+	}
+	if _, err := GetByID(rows[0].Probe.ProbeID); err == nil {
+		t.Error("valid row imported along with invalid ones")
+	}
+}
+
+// TestImportDryRun checks a dry run validates the rows without registering
+// them, and a real run registers all of them
+func TestImportDryRun(t *testing.T) {
+	body := `[
+  {"fqdn": "dry-one.example.com", "ipv4": "192.0.2.176", "provider": "AWS"},
+  {"fqdn": "dry-two.example.com", "ipv4": "192.0.2.177", "provider": "Vultr"}
+]`
+	rows, err := ParseImport(ImportJSON, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows[0].Line != 2 || rows[1].Line != 3 {
+		t.Errorf("rows read from lines %d and %d, expected 2 and 3", rows[0].Line, rows[1].Line)
+	}
+	result, err := Import(rows, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.DryRun || result.Failed != 0 || result.Imported != 0 {
+		t.Fatalf("unexpected dry run %+v", result)
+	}
+	for _, fqdn := range []string{"dry-one.example.com", "dry-two.example.com"} {
+		if o.QueryTable("probe").Filter("FQDN", fqdn).Exist() {
+			t.Errorf("probe %s registered by a dry run", fqdn)
+		}
+	}
+
+	rows, _ = ParseImport(ImportJSON, []byte(body))
+	result, err = Import(rows, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 2 {
+		t.Fatalf("unexpected import %+v", result)
+	}
+	for _, row := range result.Rows {
+		defer Delete(row.ProbeID)
+		if _, err := GetByID(row.ProbeID); err != nil {
+			t.Errorf("probe of line %d not registered: %s", row.Line, err)
+		}
+	}
+}
@@ -10,17 +10,41 @@ import (
 )
 
 func init() {
-	orm.RegisterModel(new(Probe))
-	orm.RegisterDataBase("default", "sqlite3", "/etc/sinker_registry_api/data.db")
-	o = orm.NewOrm()
-	forced, verbose := false, true
-	err := orm.RunSyncdb("default", forced, verbose)
-	if err != nil {
+	orm.RegisterModel(new(Probe), new(Provider), new(Webhook), new(WebhookDelivery), new(ProbeChange), new(ProbeHostKey), new(KeyRotation), new(Certificate), new(User), new(Team), new(PendingLogin), new(ProbeCertificate), new(RateBucket), new(EnrollmentToken), new(ProbeGroup), new(ProbeGroupMember))
+}
+
+// Database the registry is stored in, set them before calling Init
+var (
+	DatabaseDriver = "sqlite3"
+	DataSource     = "/etc/sinker_registry_api/data.db"
+)
+
+// Init opens the registry database, creating missing tables, and the GeoIP
+// database. It must be called before using any other function of the package.
+func Init() {
+	if err := openDatabase(DatabaseDriver, DataSource, true); err != nil {
 		log.Error(err)
 	}
+	seedProviders()
+	OnProbeEvent(recordChange)
 	gip = initializeGeoIP()
 }
 
+// OpenDatabase opens a registry database, creating missing tables, without
+// seeding it. It is enough to export or restore snapshots.
+func OpenDatabase(driver string, source string) error {
+	return openDatabase(driver, source, false)
+}
+
+func openDatabase(driver string, source string, verbose bool) error {
+	if err := orm.RegisterDataBase("default", driver, source); err != nil {
+		return err
+	}
+	o = orm.NewOrm()
+	forced := false
+	return orm.RunSyncdb("default", forced, verbose)
+}
+
 func initializeGeoIP() *geoip.GeoIP {
 	file := "/usr/share/GeoIP/GeoIPCity.dat"
 
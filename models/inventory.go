@@ -0,0 +1,89 @@
+package models
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// AnsibleInventory is the JSON document expected from an Ansible dynamic
+// inventory script called with --list
+type AnsibleInventory map[string]interface{}
+
+// AnsibleGroup is a group of hosts of an Ansible inventory
+type AnsibleGroup struct {
+	Hosts    []string `json:"hosts"`
+	Children []string `json:"children,omitempty"`
+}
+
+var groupNameRegexp = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// AnsibleGroupName turns a free-form value into a valid Ansible group name
+func AnsibleGroupName(prefix string, value string) string {
+	name := groupNameRegexp.ReplaceAllString(strings.ToLower(value), "_")
+	return prefix + "_" + strings.Trim(name, "_")
+}
+
+// AnsibleHostVars returns the host variables of a probe
+func AnsibleHostVars(probe *Probe) map[string]interface{} {
+	labels := probe.Labels
+	if labels == nil {
+		labels = Labels{}
+	}
+	return map[string]interface{}{
+		"ansible_host":       probe.Ipv4,
+		"sinker_probe_id":    probe.ProbeID,
+		"sinker_fqdn":        probe.FQDN,
+		"sinker_traces_path": probe.TracesPath,
+		"sinker_provider":    probe.Provider,
+		"sinker_country":     probe.Country,
+		"sinker_region":      probe.Region,
+		"sinker_labels":      labels,
+	}
+}
+
+// ToAnsibleInventory builds an inventory with one host per probe, named
+// after its FQDN, grouped by provider, by country and by label
+func ToAnsibleInventory(probes []*Probe) AnsibleInventory {
+	slugs := make(map[string]string)
+	if providers, err := GetAllProviders(); err == nil {
+		for _, provider := range providers {
+			slugs[provider.Name] = provider.Slug
+		}
+	}
+
+	groups := make(map[string]*AnsibleGroup)
+	addHost := func(group string, host string) {
+		if _, ok := groups[group]; !ok {
+			groups[group] = &AnsibleGroup{Hosts: []string{}}
+		}
+		groups[group].Hosts = append(groups[group].Hosts, host)
+	}
+	hostvars := make(map[string]interface{})
+	for _, probe := range probes {
+		host := probe.FQDN
+		hostvars[host] = AnsibleHostVars(probe)
+		provider := probe.Provider
+		if slug, ok := slugs[provider]; ok {
+			provider = slug
+		}
+		addHost(AnsibleGroupName("provider", provider), host)
+		if probe.Country != "" {
+			addHost(AnsibleGroupName("country", probe.Country), host)
+		}
+		for key, value := range probe.Labels {
+			addHost(AnsibleGroupName("label", key+"_"+value), host)
+		}
+	}
+
+	inventory := AnsibleInventory{"_meta": map[string]interface{}{"hostvars": hostvars}}
+	all := &AnsibleGroup{Hosts: []string{}, Children: []string{}}
+	for name, group := range groups {
+		sort.Strings(group.Hosts)
+		inventory[name] = group
+		all.Children = append(all.Children, name)
+	}
+	sort.Strings(all.Children)
+	inventory["all"] = all
+	return inventory
+}
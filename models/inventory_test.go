@@ -0,0 +1,61 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestAnsibleGroupName checks free-form values become valid group names
+func TestAnsibleGroupName(t *testing.T) {
+	for value, expected := range map[string]string{
+		"ES":             "country_es",
+		"Digital Ocean":  "country_digital_ocean",
+		"--eu-west-1--":  "country_eu_west_1",
+		"São Paulo/Nord": "country_s_o_paulo_nord",
+	} {
+		if name := AnsibleGroupName("country", value); name != expected {
+			t.Errorf("group name of `%s` is %s, expected %s", value, name, expected)
+		}
+	}
+}
+
+// TestToAnsibleInventory checks probes are named after their FQDN, grouped
+// by provider slug and by country, and given their host variables
+func TestToAnsibleInventory(t *testing.T) {
+	probes := []*Probe{
+		{ProbeID: "madrid", FQDN: "madrid.example.com", Ipv4: "192.0.2.140", Provider: "Digital Ocean", Country: "ES"},
+		{ProbeID: "frankfurt", FQDN: "frankfurt.example.com", Ipv4: "192.0.2.141", Provider: "AWS", Country: "DE"},
+		{ProbeID: "nowhere", FQDN: "nowhere.example.com", Ipv4: "192.0.2.142", Provider: "AWS"},
+	}
+	inventory := ToAnsibleInventory(probes)
+
+	expected := map[string][]string{
+		"provider_digitalocean": {"madrid.example.com"},
+		"provider_aws":          {"frankfurt.example.com", "nowhere.example.com"},
+		"country_es":            {"madrid.example.com"},
+		"country_de":            {"frankfurt.example.com"},
+	}
+	for name, hosts := range expected {
+		group, ok := inventory[name].(*AnsibleGroup)
+		if !ok {
+			t.Errorf("group %s missing from %v", name, inventory)
+			continue
+		}
+		if !reflect.DeepEqual(group.Hosts, hosts) {
+			t.Errorf("group %s holds %v, expected %v", name, group.Hosts, hosts)
+		}
+	}
+	all := inventory["all"].(*AnsibleGroup)
+	if !reflect.DeepEqual(all.Children, []string{"country_de", "country_es", "provider_aws", "provider_digitalocean"}) {
+		t.Errorf("unexpected children of all %v", all.Children)
+	}
+
+	hostvars := inventory["_meta"].(map[string]interface{})["hostvars"].(map[string]interface{})
+	vars, ok := hostvars["madrid.example.com"].(map[string]interface{})
+	if !ok || len(hostvars) != 3 {
+		t.Fatalf("unexpected hostvars %v", hostvars)
+	}
+	if vars["ansible_host"] != "192.0.2.140" || vars["sinker_probe_id"] != "madrid" || vars["sinker_provider"] != "Digital Ocean" {
+		t.Errorf("unexpected host variables %v", vars)
+	}
+}
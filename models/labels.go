@@ -0,0 +1,292 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego/orm"
+)
+
+// maxLabels bounds the labels of a probe
+const maxLabels = 64
+
+var (
+	labelNameRegexp   = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?)?$`)
+	labelPrefixRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	labelSetRegexp    = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+)
+
+// Labels are the key/value pairs a probe is tagged with, following the
+// syntax of Kubernetes labels. They are stored as a JSON object.
+type Labels map[string]string
+
+// String returns the labels as a selector matching them, key=value pairs
+// sorted by key
+func (l *Labels) String() string {
+	pairs := make([]string, 0, len(*l))
+	for key, value := range *l {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// FieldType stores labels in a text column
+func (l *Labels) FieldType() int {
+	return orm.TypeTextField
+}
+
+// SetRaw decodes the labels read from the database
+func (l *Labels) SetRaw(value interface{}) error {
+	var raw string
+	switch v := value.(type) {
+	case nil:
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	default:
+		return fmt.Errorf("unexpected labels %v", value)
+	}
+	*l = nil
+	if raw == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(raw), l)
+}
+
+// RawValue encodes the labels written to the database
+func (l *Labels) RawValue() interface{} {
+	if len(*l) == 0 {
+		return ""
+	}
+	raw, _ := json.Marshal(*l)
+	return string(raw)
+}
+
+func validLabelKey(key string) error {
+	name := key
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		prefix := key[:i]
+		name = key[i+1:]
+		if prefix == "" || len(prefix) > 253 || !labelPrefixRegexp.MatchString(prefix) {
+			return fmt.Errorf("invalid label key `%s`, its prefix must be a DNS subdomain", key)
+		}
+	}
+	if name == "" || len(name) > 63 || !labelNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid label key `%s`, expected up to 63 alphanumerics, '-', '_' or '.'", key)
+	}
+	return nil
+}
+
+func validLabelValue(value string) error {
+	if len(value) > 63 || !labelNameRegexp.MatchString(value) {
+		return fmt.Errorf("invalid label value `%s`, expected up to 63 alphanumerics, '-', '_' or '.'", value)
+	}
+	return nil
+}
+
+// Validate checks the keys and values of the labels
+func (l Labels) Validate() error {
+	if len(l) > maxLabels {
+		return fmt.Errorf("too many labels, a probe has at most %d", maxLabels)
+	}
+	for key, value := range l {
+		if err := validLabelKey(key); err != nil {
+			return err
+		}
+		if err := validLabelValue(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseLabels parses labels given as key=value pairs separated by commas
+func ParseLabels(s string) (Labels, error) {
+	labels := Labels{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid label `%s`, expected key=value", pair)
+		}
+		labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return labels, labels.Validate()
+}
+
+// Label selector operators
+const (
+	selectEquals       = "="
+	selectNotEquals    = "!="
+	selectIn           = "in"
+	selectNotIn        = "notin"
+	selectExists       = "exists"
+	selectDoesNotExist = "!"
+)
+
+type labelRequirement struct {
+	key      string
+	operator string
+	values   []string
+}
+
+func (r labelRequirement) matches(labels Labels) bool {
+	value, ok := labels[r.key]
+	switch r.operator {
+	case selectExists:
+		return ok
+	case selectDoesNotExist:
+		return !ok
+	case selectEquals, selectIn:
+		if !ok {
+			return false
+		}
+		for _, v := range r.values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	case selectNotEquals, selectNotIn:
+		for _, v := range r.values {
+			if ok && v == value {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// LabelSelector selects probes by their labels, a probe matches when it
+// meets every requirement. The empty selector matches every probe.
+type LabelSelector []labelRequirement
+
+// ParseLabelSelector parses a selector of Kubernetes syntax, requirements
+// separated by commas among key, !key, key=value, key==value, key!=value,
+// key in (value,...) and key notin (value,...)
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	var selector LabelSelector
+	for _, term := range splitSelector(s) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			if strings.TrimSpace(s) == "" {
+				continue
+			}
+			return nil, fmt.Errorf("invalid label selector `%s`, empty requirement", s)
+		}
+		var requirement labelRequirement
+		if match := labelSetRegexp.FindStringSubmatch(term); match != nil {
+			requirement = labelRequirement{key: match[1], operator: match[2]}
+			for _, value := range strings.Split(match[3], ",") {
+				requirement.values = append(requirement.values, strings.TrimSpace(value))
+			}
+		} else if strings.HasPrefix(term, "!") {
+			requirement = labelRequirement{key: strings.TrimSpace(term[1:]), operator: selectDoesNotExist}
+		} else if i := strings.Index(term, "!="); i >= 0 {
+			requirement = labelRequirement{key: strings.TrimSpace(term[:i]), operator: selectNotEquals, values: []string{strings.TrimSpace(term[i+2:])}}
+		} else if i := strings.Index(term, "="); i >= 0 {
+			value := strings.TrimPrefix(term[i+1:], "=")
+			requirement = labelRequirement{key: strings.TrimSpace(term[:i]), operator: selectEquals, values: []string{strings.TrimSpace(value)}}
+		} else {
+			requirement = labelRequirement{key: term, operator: selectExists}
+		}
+		if err := validLabelKey(requirement.key); err != nil {
+			return nil, fmt.Errorf("invalid label selector `%s`: %s", s, err)
+		}
+		for _, value := range requirement.values {
+			if err := validLabelValue(value); err != nil {
+				return nil, fmt.Errorf("invalid label selector `%s`: %s", s, err)
+			}
+		}
+		selector = append(selector, requirement)
+	}
+	return selector, nil
+}
+
+// splitSelector splits a selector on the commas outside of value sets
+func splitSelector(s string) []string {
+	var terms []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, s[start:])
+}
+
+// Matches reports whether labels meet every requirement of the selector
+func (s LabelSelector) Matches(labels Labels) bool {
+	for _, requirement := range s {
+		if !requirement.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// SetLabels adds labels to a probe, replacing the values of the keys it
+// already has
+func SetLabels(ProbeID string, labels Labels) (*Probe, error) {
+	log.Infof("[models.labels.SetLabels]: labelling probe %s with %s", ProbeID, labels.String())
+	probe, err := GetByID(ProbeID)
+	if err != nil {
+		return nil, err
+	}
+	merged := Labels{}
+	for key, value := range probe.Labels {
+		merged[key] = value
+	}
+	for key, value := range labels {
+		merged[key] = value
+	}
+	if err := merged.Validate(); err != nil {
+		return nil, err
+	}
+	return updateLabels(probe, merged)
+}
+
+// RemoveLabels removes labels from a probe by key, missing keys are ignored
+func RemoveLabels(ProbeID string, keys []string) (*Probe, error) {
+	log.Infof("[models.labels.RemoveLabels]: removing labels %v of probe %s", keys, ProbeID)
+	probe, err := GetByID(ProbeID)
+	if err != nil {
+		return nil, err
+	}
+	remaining := Labels{}
+	for key, value := range probe.Labels {
+		remaining[key] = value
+	}
+	for _, key := range keys {
+		delete(remaining, key)
+	}
+	return updateLabels(probe, remaining)
+}
+
+func updateLabels(probe *Probe, labels Labels) (*Probe, error) {
+	probe.Labels = labels
+	probe.UpdatedAt = time.Now()
+	if _, err := o.Update(probe, "Labels", "UpdatedAt"); err != nil {
+		return nil, err
+	}
+	notify(EventLabelsUpdated, probe)
+	return probe, nil
+}
@@ -0,0 +1,67 @@
+package models
+
+import "testing"
+
+func TestLabelSelector(t *testing.T) {
+	prodSSH := Labels{"env": "prod", "flavour": "ssh", "example.com/customer": "acme"}
+	prodLegacy := Labels{"env": "prod", "flavour": "telnet", "legacy": ""}
+	staging := Labels{"env": "staging", "flavour": "http"}
+
+	tests := []struct {
+		selector string
+		matches  []Labels
+		misses   []Labels
+	}{
+		{"", []Labels{prodSSH, prodLegacy, staging, nil}, nil},
+		{"env=prod", []Labels{prodSSH, prodLegacy}, []Labels{staging, nil}},
+		{"env==prod", []Labels{prodSSH, prodLegacy}, []Labels{staging}},
+		{"env!=prod", []Labels{staging, nil}, []Labels{prodSSH, prodLegacy}},
+		{"env=prod,flavour in (ssh,telnet),!legacy", []Labels{prodSSH}, []Labels{prodLegacy, staging}},
+		{"flavour notin (ssh, telnet)", []Labels{staging, nil}, []Labels{prodSSH, prodLegacy}},
+		{"legacy", []Labels{prodLegacy}, []Labels{prodSSH, staging}},
+		{" example.com/customer = acme ", []Labels{prodSSH}, []Labels{prodLegacy}},
+	}
+	for _, test := range tests {
+		selector, err := ParseLabelSelector(test.selector)
+		if err != nil {
+			t.Errorf("%q: %s", test.selector, err)
+			continue
+		}
+		for _, labels := range test.matches {
+			if !selector.Matches(labels) {
+				t.Errorf("%q does not match %v", test.selector, labels)
+			}
+		}
+		for _, labels := range test.misses {
+			if selector.Matches(labels) {
+				t.Errorf("%q matches %v", test.selector, labels)
+			}
+		}
+	}
+
+	for _, invalid := range []string{"env=prod,", "=prod", "env in (a b)", "-env", "env=a=b", "Example.com/env", "env=" + string(make([]byte, 64))} {
+		if _, err := ParseLabelSelector(invalid); err == nil {
+			t.Errorf("%q parsed", invalid)
+		}
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels("env=prod, flavour=ssh,legacy=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 3 || labels["flavour"] != "ssh" || labels["legacy"] != "" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+	if labels.String() != "env=prod,flavour=ssh,legacy=" {
+		t.Errorf("unexpected string %s", labels.String())
+	}
+	var stored Labels
+	if err := stored.SetRaw(labels.RawValue()); err != nil || stored.String() != labels.String() {
+		t.Errorf("labels %v stored as %v: %v", labels, stored, err)
+	}
+	if _, err := ParseLabels("env"); err == nil {
+		t.Error("label without value parsed")
+	}
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain runs the tests of the package on a database of their own
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "sinker_registry_models")
+	if err != nil {
+		panic(err)
+	}
+	DataSource = filepath.Join(dir, "data.db")
+	Init()
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testProbe returns an enabled probe located in Madrid
+func testProbe(fqdn string, ipv4 string, provider string) Probe {
+	var probe Probe
+	probe.SetDefaults()
+	probe.FQDN = fqdn
+	probe.Ipv4 = ipv4
+	probe.Provider = provider
+	probe.Enabled = true
+	probe.GeoLatitude = "40.4"
+	probe.GeoLongitude = "-3.7"
+	return probe
+}
+
+// registerTestProbe registers probe and returns it as stored
+func registerTestProbe(t *testing.T, probe Probe) *Probe {
+	ProbeID, err := AddOne(probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, err := GetByID(ProbeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return added
+}
+
+// addTestProbe registers an enabled probe and returns it
+func addTestProbe(t *testing.T, fqdn string, ipv4 string, provider string) *Probe {
+	return registerTestProbe(t, testProbe(fqdn, ipv4, provider))
+}
+
+// addLabelledProbe registers an enabled probe labelled with labels and
+// returns it
+func addLabelledProbe(t *testing.T, fqdn string, ipv4 string, provider string, labels Labels) *Probe {
+	probe := testProbe(fqdn, ipv4, provider)
+	probe.Labels = labels
+	return registerTestProbe(t, probe)
+}
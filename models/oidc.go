@@ -0,0 +1,611 @@
+package models
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego/orm"
+)
+
+// OIDCConfig describes the OpenID Connect issuer users log in with, and
+// how the claims of its tokens map to registry users
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback of the authorization code flow, the
+	// /v1/auth/callback URL of the registry as the issuer reaches it
+	RedirectURL string
+	// Audience tokens must be issued to, ClientID when empty
+	Audience      string
+	Scopes        []string
+	UsernameClaim string
+	GroupsClaim   string
+	// GroupRoles maps groups to registry roles, users get the highest role
+	// of their groups, and join the team named after one of them if any
+	GroupRoles map[string]string
+	// AllowNoTeam lets in the users none of whose groups names a team, they
+	// see every probe. They are refused otherwise.
+	AllowNoTeam bool
+	// JWKSRefresh is how long the signing keys of the issuer are cached
+	JWKSRefresh time.Duration
+}
+
+// oidcDiscovery is the part of the issuer metadata the registry uses
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// PendingLogin is an authorization code flow waiting for its callback, kept
+// in the database so that any instance of the registry completes it. Only
+// the hash of its state is stored, the browser holds the state itself.
+type PendingLogin struct {
+	StateHash string    `orm:"pk;size(64)" json:"-"`
+	Nonce     string    `json:"-"`
+	Verifier  string    `json:"-"`
+	ReturnTo  string    `orm:"size(1024)" json:"-"`
+	ExpiresAt time.Time `orm:"index" json:"-"`
+}
+
+// OIDCLogin is the outcome of a successful authorization code flow
+type OIDCLogin struct {
+	User      *User
+	IDToken   string
+	ExpiresAt time.Time
+	ReturnTo  string
+}
+
+const (
+	// jwksMinRefresh bounds how often an unknown key id makes the registry
+	// fetch the keys of the issuer again
+	jwksMinRefresh = 30 * time.Second
+	// oidcClockSkew is the clock difference tolerated with the issuer
+	oidcClockSkew = time.Minute
+	// OIDCLoginTimeout is how long users have to log in at the issuer
+	OIDCLoginTimeout = 10 * time.Minute
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+var (
+	oidcConfig *OIDCConfig
+	oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+	oidcMu        sync.Mutex
+	oidcMeta      *oidcDiscovery
+	oidcKeys      map[string]crypto.PublicKey
+	oidcFetchedAt time.Time
+)
+
+// ConfigureOIDC makes the registry accept the tokens of an OpenID Connect
+// issuer along with its own, the issuer metadata is fetched on first use
+func ConfigureOIDC(config OIDCConfig) error {
+	if config.Issuer == "" || config.ClientID == "" {
+		return errors.New("the oidc issuer and client id are required")
+	}
+	for group, role := range config.GroupRoles {
+		if err := validRole(role); err != nil {
+			return fmt.Errorf("group %s: %s", group, err)
+		}
+	}
+	if config.Audience == "" {
+		config.Audience = config.ClientID
+	}
+	config.Issuer = strings.TrimRight(config.Issuer, "/")
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	oidcConfig = &config
+	oidcMeta = nil
+	oidcKeys = nil
+	oidcFetchedAt = time.Time{}
+	return nil
+}
+
+// OIDCEnabled reports whether users may authenticate with the issuer
+func OIDCEnabled() bool {
+	return oidcConfig != nil
+}
+
+func getOIDCJSON(url string, v interface{}) error {
+	resp, err := oidcClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// discovery returns the issuer metadata, oidcMu must be held
+func discovery() (*oidcDiscovery, error) {
+	if oidcMeta != nil {
+		return oidcMeta, nil
+	}
+	var meta oidcDiscovery
+	if err := getOIDCJSON(oidcConfig.Issuer+"/.well-known/openid-configuration", &meta); err != nil {
+		return nil, fmt.Errorf("unable to discover the oidc issuer: %s", err)
+	}
+	if strings.TrimRight(meta.Issuer, "/") != oidcConfig.Issuer {
+		return nil, fmt.Errorf("the oidc issuer calls itself %s", meta.Issuer)
+	}
+	if meta.JWKSURI == "" {
+		return nil, errors.New("the oidc issuer publishes no jwks_uri")
+	}
+	oidcMeta = &meta
+	return oidcMeta, nil
+}
+
+// refreshKeys fetches the signing keys of the issuer, oidcMu must be held
+func refreshKeys() error {
+	meta, err := discovery()
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	oidcFetchedAt = time.Now()
+	if err := getOIDCJSON(meta.JWKSURI, &set); err != nil {
+		return fmt.Errorf("unable to fetch the oidc signing keys: %s", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Warningf("[models.oidc.refreshKeys]: skipping key %s: %s", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	log.Infof("[models.oidc.refreshKeys]: fetched %d signing keys from %s", len(keys), meta.JWKSURI)
+	oidcKeys = keys
+	return nil
+}
+
+// signingKey returns the key of the issuer with id kid, the keys are
+// fetched again when they expire or when kid is unknown, as it is after
+// the issuer rotates them
+func signingKey(kid string) (crypto.PublicKey, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if oidcKeys == nil || time.Since(oidcFetchedAt) > oidcConfig.JWKSRefresh {
+		if err := refreshKeys(); err != nil && oidcKeys == nil {
+			return nil, err
+		} else if err != nil {
+			log.Warningf("[models.oidc.signingKey]: keeping the cached keys: %s", err)
+		}
+	}
+	if key, ok := oidcKeys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(oidcFetchedAt) > jwksMinRefresh {
+		if err := refreshKeys(); err != nil {
+			return nil, err
+		}
+		if key, ok := oidcKeys[kid]; ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %s", kid)
+}
+
+func decodeSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, errN := decodeSegment(jwk.N)
+		e, errE := decodeSegment(jwk.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA key")
+		}
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[jwk.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, errX := decodeSegment(jwk.X)
+		y, errY := decodeSegment(jwk.Y)
+		if errX != nil || errY != nil {
+			return nil, errors.New("invalid EC key")
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("invalid EC key")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// verifySignature checks the signature of a JWT, only asymmetric
+// algorithms are accepted
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	hash, ok := jwtHashes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported signing algorithm %s", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] == 'R' && rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[0] == 'E' && len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(key, digest, r, s) {
+				return nil
+			}
+		}
+	}
+	return errors.New("invalid token signature")
+}
+
+// verifyOIDCToken checks a token is signed by the issuer, issued to the
+// registry and valid now, and returns its claims
+func verifyOIDCToken(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	raw, err := decodeSegment(parts[0])
+	if err != nil || json.Unmarshal(raw, &header) != nil {
+		return nil, errors.New("malformed token header")
+	}
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	key, err := signingKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	raw, err = decodeSegment(parts[1])
+	if err != nil || json.Unmarshal(raw, &claims) != nil {
+		return nil, errors.New("malformed token claims")
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != oidcConfig.Issuer {
+		return nil, fmt.Errorf("token issued by %s", iss)
+	}
+	if !audienceMatches(claims["aud"], oidcConfig.Audience) {
+		return nil, errors.New("token issued to another audience")
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.Add(-oidcClockSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(oidcClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token not valid yet")
+	}
+	return claims, nil
+}
+
+func audienceMatches(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, value := range aud {
+			if value == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimStrings returns a claim holding a string or a list of strings
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return strings.Fields(strings.Replace(value, ",", " ", -1))
+	case []interface{}:
+		var values []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// oidcRole returns the highest role the groups map to, empty when none does
+func oidcRole(groups []string) string {
+	role := ""
+	for _, group := range groups {
+		if mapped, ok := oidcConfig.GroupRoles[group]; ok && roleRanks[mapped] > roleRanks[role] {
+			role = mapped
+		}
+	}
+	return role
+}
+
+func oidcUsername(claims map[string]interface{}) string {
+	for _, name := range []string{oidcConfig.UsernameClaim, "email", "sub"} {
+		if value, ok := claims[name].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// oidcUser returns the registry user of the claims of a verified token,
+// keeping its role and team in sync with its groups. Users are told apart by
+// their subject, the users holding a registry token are never changed.
+func oidcUser(claims map[string]interface{}) (*User, error) {
+	name := oidcUsername(claims)
+	subject, _ := claims["sub"].(string)
+	if name == "" || subject == "" {
+		return nil, errors.New("token names no user")
+	}
+	groups := claimStrings(claims, oidcConfig.GroupsClaim)
+	role := oidcRole(groups)
+	if role == "" {
+		return nil, fmt.Errorf("no group of %s maps to a registry role", name)
+	}
+	var team int64
+	if len(groups) > 0 {
+		var teams []*Team
+		o.QueryTable("team").Filter("name__in", groups).OrderBy("id").Limit(1).All(&teams)
+		if len(teams) == 1 {
+			team = teams[0].ID
+		}
+	}
+	if team == 0 && !oidcConfig.AllowNoTeam {
+		return nil, fmt.Errorf("no group of %s names a registry team", name)
+	}
+	now := time.Now()
+	var user User
+	err := o.QueryTable("user").Filter("issuer", oidcConfig.Issuer).Filter("subject", subject).One(&user)
+	if err == orm.ErrNoRows {
+		name, err = oidcFreeName(name, subject)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("[models.oidc.oidcUser]: adding %s user %s from the oidc issuer", role, name)
+		user = User{Name: name, Role: role, Team: team, CreatedAt: now, LastSeenAt: now, Issuer: oidcConfig.Issuer, Subject: subject}
+		if _, err := o.Insert(&user); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else if user.Role != role || user.Team != team || now.Sub(user.LastSeenAt) > time.Minute {
+		if user.Role != role || user.Team != team {
+			log.Infof("[models.oidc.oidcUser]: %s is now %s of team %d as per its groups", name, role, team)
+		}
+		user.Role, user.Team, user.LastSeenAt = role, team, now
+		if _, err := o.Update(&user, "Role", "Team", "LastSeenAt"); err != nil {
+			return nil, err
+		}
+	}
+	if err := user.loadScope(); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// oidcFreeName returns the name a new user of the oidc issuer is added
+// with, its own unless another user holds it
+func oidcFreeName(name string, subject string) (string, error) {
+	if !o.QueryTable("user").Filter("name", name).Exist() {
+		return name, nil
+	}
+	sum := sha256.Sum256([]byte(oidcConfig.Issuer + " " + subject))
+	suffix := "~" + hex.EncodeToString(sum[:4])
+	if len(name)+len(suffix) > 64 {
+		name = name[:64-len(suffix)]
+	}
+	name += suffix
+	if o.QueryTable("user").Filter("name", name).Exist() {
+		return "", fmt.Errorf("user name %s is taken", name)
+	}
+	return name, nil
+}
+
+func authenticateOIDC(token string) (*User, error) {
+	claims, err := verifyOIDCToken(token)
+	if err != nil {
+		log.Debugf("[models.oidc.authenticateOIDC]: refusing token: %s", err)
+		return nil, err
+	}
+	return oidcUser(claims)
+}
+
+func randomString() string {
+	raw := make([]byte, 24)
+	rand.Read(raw)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// stateHash is what identifies a pending login in the database
+func stateHash(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
+
+// OIDCLoginURL starts an authorization code flow, users are sent to the
+// returned URL of the issuer and come back to returnTo once logged in. The
+// returned state must be kept by the browser and be sent back along with
+// the callback, binding the login to the browser that started it.
+func OIDCLoginURL(returnTo string) (string, string, error) {
+	if !OIDCEnabled() {
+		return "", "", errors.New("oidc is not configured")
+	}
+	oidcMu.Lock()
+	meta, err := discovery()
+	oidcMu.Unlock()
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	if _, err := o.QueryTable("pending_login").Filter("expires_at__lt", now).Delete(); err != nil {
+		log.Warningf("[models.oidc.OIDCLoginURL]: unable to remove expired logins: %s", err)
+	}
+	state := randomString()
+	login := PendingLogin{StateHash: stateHash(state), Nonce: randomString(), Verifier: randomString(), ReturnTo: returnTo, ExpiresAt: now.Add(OIDCLoginTimeout)}
+	if _, err := o.Insert(&login); err != nil {
+		log.Errorf("[models.oidc.OIDCLoginURL]: Error inserting pending login %s", err)
+		return "", "", err
+	}
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {oidcConfig.ClientID},
+		"redirect_uri":          {oidcConfig.RedirectURL},
+		"scope":                 {strings.Join(oidcConfig.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return meta.AuthorizationEndpoint + separator + query.Encode(), state, nil
+}
+
+// exchangeCode redeems the code of an authorization code flow for an ID
+// token, verified and bound to the flow by its nonce. A login is removed as
+// it is read, only one callback completes it.
+func exchangeCode(code string, state string) (string, map[string]interface{}, *PendingLogin, error) {
+	login := PendingLogin{StateHash: stateHash(state)}
+	err := o.Read(&login)
+	if err == nil {
+		var removed int64
+		if removed, err = o.QueryTable("pending_login").Filter("state_hash", login.StateHash).Delete(); err == nil && removed == 0 {
+			err = orm.ErrNoRows
+		}
+	}
+	if err != nil || time.Now().After(login.ExpiresAt) {
+		return "", nil, nil, errors.New("unknown or expired login, start again")
+	}
+	oidcMu.Lock()
+	meta, err := discovery()
+	oidcMu.Unlock()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {oidcConfig.RedirectURL},
+		"client_id":     {oidcConfig.ClientID},
+		"client_secret": {oidcConfig.ClientSecret},
+		"code_verifier": {login.Verifier},
+	}
+	resp, err := oidcClient.PostForm(meta.TokenEndpoint, form)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	defer resp.Body.Close()
+	var tokens struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	json.Unmarshal(body, &tokens)
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return "", nil, nil, fmt.Errorf("the oidc issuer refused the code: %s %s", resp.Status, tokens.Error)
+	}
+	claims, err := verifyOIDCToken(tokens.IDToken)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != login.Nonce {
+		return "", nil, nil, errors.New("token nonce does not match the login")
+	}
+	return tokens.IDToken, claims, &login, nil
+}
+
+// CompleteOIDCLogin ends an authorization code flow, the ID token it
+// returns authenticates the browser session of the user until it expires
+func CompleteOIDCLogin(code string, state string) (*OIDCLogin, error) {
+	if !OIDCEnabled() {
+		return nil, errors.New("oidc is not configured")
+	}
+	token, claims, login, err := exchangeCode(code, state)
+	if err != nil {
+		return nil, err
+	}
+	user, err := oidcUser(claims)
+	if err != nil {
+		return nil, err
+	}
+	exp, _ := claims["exp"].(float64)
+	log.Infof("[models.oidc.CompleteOIDCLogin]: %s logged in as %s", user.Name, user.Role)
+	return &OIDCLogin{User: user, IDToken: token, ExpiresAt: time.Unix(int64(exp), 0), ReturnTo: login.ReturnTo}, nil
+}
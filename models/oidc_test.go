@@ -0,0 +1,385 @@
+package models
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockIssuer is a local OpenID Connect issuer, it signs with the keys it
+// currently publishes and redeems the codes it was given
+type mockIssuer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	keys     map[string]crypto.Signer
+	jwksHits int
+	codes    map[string]map[string]interface{}
+	verifier map[string]string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	issuer := &mockIssuer{keys: make(map[string]crypto.Signer), codes: make(map[string]map[string]interface{}), verifier: make(map[string]string)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                issuer.URL,
+			AuthorizationEndpoint: issuer.URL + "/authorize",
+			TokenEndpoint:         issuer.URL + "/token",
+			JWKSURI:               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mu.Lock()
+		defer issuer.mu.Unlock()
+		issuer.jwksHits++
+		var keys []jsonWebKey
+		for kid, signer := range issuer.keys {
+			switch key := signer.Public().(type) {
+			case *rsa.PublicKey:
+				keys = append(keys, jsonWebKey{Kty: "RSA", Kid: kid, Use: "sig",
+					N: base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())})
+			case *ecdsa.PublicKey:
+				keys = append(keys, jsonWebKey{Kty: "EC", Kid: kid, Crv: "P-256",
+					X: base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+					Y: base64.RawURLEncoding.EncodeToString(key.Y.Bytes())})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		issuer.mu.Lock()
+		claims, ok := issuer.codes[r.Form.Get("code")]
+		challenge := issuer.verifier[r.Form.Get("code")]
+		delete(issuer.codes, r.Form.Get("code"))
+		issuer.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || r.Form.Get("client_secret") != "secret" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": issuer.sign(t, "rsa1", claims)})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	return issuer
+}
+
+func (issuer *mockIssuer) addKey(t *testing.T, kid string, ec bool) {
+	var signer crypto.Signer
+	var err error
+	if ec {
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	} else {
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.mu.Lock()
+	issuer.keys[kid] = signer
+	issuer.mu.Unlock()
+}
+
+func (issuer *mockIssuer) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	issuer.mu.Lock()
+	signer := issuer.keys[kid]
+	issuer.mu.Unlock()
+	alg := "RS256"
+	if _, ok := signer.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch key := signer.(type) {
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (issuer *mockIssuer) claims(overrides map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"iss":                issuer.URL,
+		"aud":                "sinker",
+		"sub":                "1234",
+		"preferred_username": "alice",
+		"groups":             []string{"interns"},
+		"exp":                time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range overrides {
+		claims[name] = value
+	}
+	return claims
+}
+
+func configureMockIssuer(t *testing.T) *mockIssuer {
+	issuer := newMockIssuer(t)
+	issuer.addKey(t, "rsa1", false)
+	err := ConfigureOIDC(OIDCConfig{
+		Issuer:        issuer.URL,
+		ClientID:      "sinker",
+		ClientSecret:  "secret",
+		RedirectURL:   "http://registry/v1/auth/callback",
+		Scopes:        []string{"openid", "groups"},
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		GroupRoles:    map[string]string{"interns": RoleViewer, "oncall": RoleOperator, "sre": RoleAdmin},
+		JWKSRefresh:   time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issuer
+}
+
+func TestVerifyOIDCToken(t *testing.T) {
+	issuer := configureMockIssuer(t)
+	defer issuer.Close()
+	issuer.addKey(t, "ec1", true)
+
+	for _, kid := range []string{"rsa1", "ec1"} {
+		claims, err := verifyOIDCToken(issuer.sign(t, kid, issuer.claims(nil)))
+		if err != nil {
+			t.Fatalf("%s: %s", kid, err)
+		}
+		if oidcUsername(claims) != "alice" {
+			t.Errorf("%s: unexpected username %s", kid, oidcUsername(claims))
+		}
+	}
+
+	refused := map[string]map[string]interface{}{
+		"expired":      {"exp": time.Now().Add(-time.Hour).Unix()},
+		"not yet":      {"nbf": time.Now().Add(time.Hour).Unix()},
+		"audience":     {"aud": "someone-else"},
+		"issuer":       {"iss": "https://evil.example.com"},
+		"no exp claim": {"exp": nil},
+	}
+	for name, overrides := range refused {
+		if _, err := verifyOIDCToken(issuer.sign(t, "rsa1", issuer.claims(overrides))); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+	if _, err := verifyOIDCToken(issuer.sign(t, "rsa1", issuer.claims(map[string]interface{}{"aud": []string{"other", "sinker"}}))); err != nil {
+		t.Errorf("audience list: %s", err)
+	}
+
+	token := issuer.sign(t, "rsa1", issuer.claims(nil))
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"`+issuer.URL+`","aud":"sinker","preferred_username":"mallory","exp":9999999999}`)) + "." + parts[2]
+	if _, err := verifyOIDCToken(tampered); err == nil {
+		t.Error("tampered token accepted")
+	}
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"rsa1"}`)) + "." + parts[1] + "."
+	if _, err := verifyOIDCToken(none); err == nil {
+		t.Error("unsigned token accepted")
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	issuer := configureMockIssuer(t)
+	defer issuer.Close()
+	if _, err := verifyOIDCToken(issuer.sign(t, "rsa1", issuer.claims(nil))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyOIDCToken(issuer.sign(t, "rsa1", issuer.claims(nil))); err != nil {
+		t.Fatal(err)
+	}
+	if issuer.jwksHits != 1 {
+		t.Fatalf("keys fetched %d times, expected them cached", issuer.jwksHits)
+	}
+
+	// the issuer rotates its keys, tokens of the new one are accepted once
+	// the cached keys are old enough to be fetched again
+	issuer.addKey(t, "rsa2", false)
+	oidcFetchedAt = oidcFetchedAt.Add(-2 * jwksMinRefresh)
+	if _, err := verifyOIDCToken(issuer.sign(t, "rsa2", issuer.claims(nil))); err != nil {
+		t.Fatalf("rotated key: %s", err)
+	}
+	if issuer.jwksHits != 2 {
+		t.Fatalf("keys fetched %d times, expected 2", issuer.jwksHits)
+	}
+	// unknown keys do not make the registry hammer the issuer
+	issuer.addKey(t, "rsa3", false)
+	if _, err := verifyOIDCToken(issuer.sign(t, "rsa3", issuer.claims(nil))); err == nil {
+		t.Error("token of a key fetched too recently accepted")
+	}
+	if issuer.jwksHits != 2 {
+		t.Errorf("keys fetched %d times, expected 2", issuer.jwksHits)
+	}
+}
+
+func TestOIDCRole(t *testing.T) {
+	issuer := configureMockIssuer(t)
+	defer issuer.Close()
+	cases := map[string][]string{
+		RoleViewer:   {"interns"},
+		RoleOperator: {"interns", "oncall"},
+		RoleAdmin:    {"sre", "interns"},
+		"":           {"marketing"},
+	}
+	for role, groups := range cases {
+		if got := oidcRole(groups); got != role {
+			t.Errorf("groups %v: role %q, expected %q", groups, got, role)
+		}
+	}
+	claims := map[string]interface{}{"groups": "oncall, sre"}
+	if groups := claimStrings(claims, "groups"); len(groups) != 2 || groups[1] != "sre" {
+		t.Errorf("unexpected groups %v", groups)
+	}
+}
+
+func TestOIDCAuthorizationCode(t *testing.T) {
+	issuer := configureMockIssuer(t)
+	defer issuer.Close()
+	login, state, err := OIDCLoginURL("/v1/probe/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, err := url.Parse(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := redirect.Query()
+	if query.Get("state") != state {
+		t.Fatalf("login state %s not sent to the issuer", state)
+	}
+	logins := o.QueryTable("pending_login")
+	if logins.Filter("state_hash", state).Exist() || !logins.Filter("state_hash", stateHash(state)).Exist() {
+		t.Error("pending login not stored by the hash of its state")
+	}
+	if redirect.Path != "/authorize" || query.Get("client_id") != "sinker" || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected login URL %s", login)
+	}
+
+	// the issuer authenticates the user and hands a code bound to the nonce
+	issuer.mu.Lock()
+	issuer.codes["code1"] = issuer.claims(map[string]interface{}{"nonce": query.Get("nonce")})
+	issuer.verifier["code1"] = query.Get("code_challenge")
+	issuer.mu.Unlock()
+
+	if _, _, _, err := exchangeCode("code1", "forged-state"); err == nil {
+		t.Error("unknown state accepted")
+	}
+	token, claims, pending, err := exchangeCode("code1", query.Get("state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == "" || oidcUsername(claims) != "alice" || pending.ReturnTo != "/v1/probe/" {
+		t.Errorf("unexpected login %v %+v", claims, pending)
+	}
+	if _, _, _, err := exchangeCode("code1", query.Get("state")); err == nil {
+		t.Error("state used twice")
+	}
+
+	second, _, _ := OIDCLoginURL("/")
+	redirect, _ = url.Parse(second)
+	issuer.mu.Lock()
+	issuer.codes["code2"] = issuer.claims(map[string]interface{}{"nonce": query.Get("nonce")})
+	issuer.verifier["code2"] = redirect.Query().Get("code_challenge")
+	issuer.mu.Unlock()
+	if _, _, _, err := exchangeCode("code2", redirect.Query().Get("state")); err == nil {
+		t.Error("token of another login accepted")
+	}
+}
+
+func TestOIDCUserBySubject(t *testing.T) {
+	issuer := configureMockIssuer(t)
+	defer issuer.Close()
+	oidcConfig.AllowNoTeam = true
+	local, err := AddUser("mallory", RoleAdmin, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser(local.ID)
+
+	// a user of the issuer naming itself after a local user gets one of its own
+	claims := issuer.claims(map[string]interface{}{"sub": "5678", "preferred_username": "mallory"})
+	user, err := authenticateOIDC(issuer.sign(t, "rsa1", claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser(user.ID)
+	if user.ID == local.ID || user.Role != RoleViewer || user.Name == "mallory" || user.Subject != "5678" {
+		t.Errorf("user of the issuer took the local one over: %+v", user)
+	}
+	kept, err := GetUser(local.ID)
+	if err != nil || kept.Role != RoleAdmin || kept.Issuer != "" {
+		t.Errorf("local user changed: %+v %v", kept, err)
+	}
+	if _, err := Authenticate(local.Token); err != nil {
+		t.Errorf("local token refused: %s", err)
+	}
+
+	// the user is found by subject again, whatever name it goes by now
+	claims = issuer.claims(map[string]interface{}{"sub": "5678", "preferred_username": "mallory2", "groups": []string{"oncall"}})
+	again, err := authenticateOIDC(issuer.sign(t, "rsa1", claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != user.ID || again.Role != RoleOperator {
+		t.Errorf("user of the issuer not found by subject: %+v", again)
+	}
+}
+
+// TestOIDCUserWithoutTeam checks users none of whose groups names a team are
+// refused, unless explicitly let in seeing every probe
+func TestOIDCUserWithoutTeam(t *testing.T) {
+	issuer := configureMockIssuer(t)
+	defer issuer.Close()
+	claims := issuer.claims(map[string]interface{}{"sub": "9012", "preferred_username": "teamless", "groups": []string{"oncall"}})
+	if user, err := authenticateOIDC(issuer.sign(t, "rsa1", claims)); err == nil {
+		DeleteUser(user.ID)
+		t.Fatalf("user in no team let in: %+v", user)
+	}
+
+	team, err := AddTeam(Team{Name: "oncall", Providers: []string{"AWS"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteTeam(team.ID)
+	user, err := authenticateOIDC(issuer.sign(t, "rsa1", claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser(user.ID)
+	if user.Team != team.ID || user.Scope() == nil {
+		t.Errorf("user not restricted to the team named after its group: %+v", user)
+	}
+
+	oidcConfig.AllowNoTeam = true
+	claims = issuer.claims(map[string]interface{}{"sub": "3456", "preferred_username": "unscoped", "groups": []string{"sre"}})
+	unscoped, err := authenticateOIDC(issuer.sign(t, "rsa1", claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser(unscoped.ID)
+	if unscoped.Team != 0 || unscoped.Scope() != nil {
+		t.Errorf("user let in without a team is restricted: %+v", unscoped)
+	}
+}
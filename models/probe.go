@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -14,66 +16,59 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-type Provider int
-
-const (
-	DIGITALOCEAN = 1 + iota
-	VULTR
-	AWS
-	GOOGLECLOUD
-	LINODE
-	HETZNER
-	ERROR
-)
-
-var providers = map[string]Provider{
-	"Digital Ocean": DIGITALOCEAN,
-	"Vultr":         VULTR,
-	"AWS":           AWS,
-	"Google Cloud":  GOOGLECLOUD,
-	"Linode":        LINODE,
-	"Hetzner":       HETZNER,
-	"Bad Provider":  ERROR,
-}
-
-func (p Provider) String() string {
-	for i, k := range providers {
-		if k == p {
-			return i
-		}
-	}
-	return ""
+// Model Struct
+type Probe struct {
+	ProbeID         string    `orm:"pk" json:"ProbeID"`
+	FQDN            string    `orm:"size(100)" json:"fqdn"`
+	Ipv4            string    `json:"ipv4"`
+	Ipv6            string    `json:"ipv6"`
+	Provider        string    `orm:"size(100)" json:"provider"`
+	GeoLongitude    string    `json:"geolongitude"`
+	GeoLatitude     string    `json:"geolatitude"`
+	Country         string    `json:"country"`
+	SSHPrivateKey   string    `json:"-"`
+	SSHPublicKey    string    `json:"sshpublicKey"`
+	SSHHostKey      string    `orm:"size(1024)" json:"sshhostkey"`
+	TracesPath      string    `json:"tracespath"`
+	Region          string    `orm:"size(64)" json:"region"`
+	Zone            string    `orm:"size(64)" json:"zone"`
+	InstanceID      string    `orm:"size(100)" json:"instanceid"`
+	InstanceType    string    `orm:"size(64)" json:"instancetype"`
+	MonthlyCost     float64   `json:"monthlycost"`
+	Labels          Labels    `orm:"null" json:"labels,omitempty"`
+	DNSForward      bool      `json:"dnsforward"`
+	DNSReverse      bool      `json:"dnsreverse"`
+	Enabled         bool      `json:"enabled"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	DisabledAt      time.Time `orm:"null" json:"disabled_at"`
+	DNSVerifiedAt   time.Time `orm:"null" json:"dnsverified_at"`
+	SSHKeyCreatedAt time.Time `orm:"null" json:"sshkey_created_at"`
 }
 
-func ParseProvider(descr string) (Provider, bool) {
-	if val, ok := providers[descr]; ok {
-		return val, ok
+// UnmarshalJSON decodes a probe along with the ssh private key it is
+// registered or rekeyed with, the key is left out of the JSON of probes and
+// only handed out by GetSSH
+func (probe *Probe) UnmarshalJSON(data []byte) error {
+	type record Probe
+	decoded := struct {
+		*record
+		SSHPrivateKey string `json:"sshprivateKey"`
+	}{record: (*record)(probe), SSHPrivateKey: probe.SSHPrivateKey}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
 	}
-	return ERROR, false
-}
-
-// Model Struct
-type Probe struct {
-	ProbeID       string    `orm:"pk" json:"ProbeID"`
-	FQDN          string    `orm:"size(100)" json:"fqdn"`
-	Ipv4          string    `json:"ipv4"`
-	Ipv6          string    `json:"ipv6"`
-	Provider      string    `orm:"size(100)" json:"provider"`
-	GeoLongitude  string    `json:"geolongitude"`
-	GeoLatitude   string    `json:"geolatitude"`
-	Country       string    `json:"country"`
-	SSHPrivateKey string    `json:"sshprivateKey"`
-	SSHPublicKey  string    `json:"sshpublicKey"`
-	TracesPath    string    `json:"tracespath"`
-	Enabled       bool      `json:"enabled"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
-	DisabledAt    time.Time `orm:"null" json:"disabled_at"`
+	probe.SSHPrivateKey = decoded.SSHPrivateKey
+	return nil
 }
 
+// ProbeSSHKeys are the decoded ssh keys of a probe, along with the staged
+// ones during a key rotation
 type ProbeSSHKeys struct {
-	Private string `json:"SSHPrivateKey"`
-	Public  string `json:"SSHPublicKey"`
+	Private       string `json:"SSHPrivateKey"`
+	Public        string `json:"SSHPublicKey"`
+	StagedPrivate string `json:"StagedSSHPrivateKey,omitempty"`
+	StagedPublic  string `json:"StagedSSHPublicKey,omitempty"`
 }
 
 func toHash(probe Probe) (hashID string) {
@@ -92,9 +87,18 @@ func toHash(probe Probe) (hashID string) {
 }
 
 func Validate(probe Probe) (bool, error) {
-	if _, ok := ParseProvider(probe.Provider); !ok {
+	provider, err := LookupProvider(probe.Provider)
+	if err != nil {
 		return false, fmt.Errorf("invalid provider %s", probe.Provider)
 	}
+	if err := validateInstance(probe, provider); err != nil {
+		return false, err
+	}
+	if probe.SSHHostKey != "" {
+		if _, err := ParseSSHPublicKey(probe.SSHHostKey); err != nil {
+			return false, err
+		}
+	}
 	if len(probe.Ipv4) < 7 {
 		return false, fmt.Errorf("bad ipv4, too short for an ipv4 address %s", probe.Ipv4)
 	}
@@ -112,9 +116,12 @@ func Validate(probe Probe) (bool, error) {
 	if (probe.GeoLatitude != "NaN" && probe.GeoLatitude != "") && !govalidator.IsLatitude(probe.GeoLatitude) {
 		return false, fmt.Errorf("invalid latitude `%s`", probe.GeoLatitude)
 	}
+	if err := probe.Labels.Validate(); err != nil {
+		return false, err
+	}
 
 	probes, err := GetByFQDN(probe.FQDN)
-	log.Debugf("[models.probe.addOne]: number of probes %s found by FQDN", len(probes))
+	log.Debugf("[models.probe.addOne]: number of probes %d found by FQDN", len(probes))
 
 	if err == nil && len(probes) >= 1 {
 		return false, fmt.Errorf("FQDN name already registered %s", probe.FQDN)
@@ -122,7 +129,7 @@ func Validate(probe Probe) (bool, error) {
 		log.Errorf("[models.probe.addOne]: Error querying database %s", err)
 	}
 	probes, err = GetByIPv4(probe.Ipv4)
-	log.Debugf("[models.probe.addOne]: number of probes %s found by IP", len(probes))
+	log.Debugf("[models.probe.addOne]: number of probes %d found by IP", len(probes))
 
 	if err == nil && len(probes) >= 1 {
 		return false, fmt.Errorf("IPv4 address already registered %s", probe.Ipv4)
@@ -133,6 +140,31 @@ func Validate(probe Probe) (bool, error) {
 	return true, nil
 
 }
+
+func validateInstance(probe Probe, provider *Provider) error {
+	if probe.Region != "" && !provider.HasRegion(probe.Region) {
+		return fmt.Errorf("unknown region `%s` for provider %s", probe.Region, provider.Name)
+	}
+	if probe.Zone != "" {
+		if probe.Region == "" {
+			return fmt.Errorf("zone `%s` given without region", probe.Zone)
+		}
+		if !strings.HasPrefix(probe.Zone, probe.Region) || !slugRegexp.MatchString(probe.Zone) {
+			return fmt.Errorf("invalid zone `%s` for region %s", probe.Zone, probe.Region)
+		}
+	}
+	if len(probe.InstanceID) > 100 {
+		return fmt.Errorf("instance id too long %s", probe.InstanceID)
+	}
+	if len(probe.InstanceType) > 64 {
+		return fmt.Errorf("instance type too long %s", probe.InstanceType)
+	}
+	if probe.MonthlyCost < 0 || math.IsNaN(probe.MonthlyCost) || math.IsInf(probe.MonthlyCost, 0) {
+		return fmt.Errorf("invalid monthly cost %f", probe.MonthlyCost)
+	}
+	return nil
+}
+
 func (probe *Probe) SetDefaults() {
 	probe.GeoLatitude = "NaN"
 	probe.GeoLongitude = "NaN"
@@ -144,25 +176,43 @@ func (probe *Probe) SetDefaults() {
 	probe.Provider = ""
 	probe.SSHPrivateKey = ""
 	probe.SSHPublicKey = ""
+	probe.SSHHostKey = ""
 	probe.TracesPath = "/var/log/traces"
+	probe.Region = ""
+	probe.Zone = ""
+	probe.InstanceID = ""
+	probe.InstanceType = ""
+	probe.MonthlyCost = 0
+	probe.DNSForward = false
+	probe.DNSReverse = false
 	probe.CreatedAt = time.Now()
 	probe.UpdatedAt = time.Now()
 	probe.DisabledAt = time.Time{}
+	probe.DNSVerifiedAt = time.Time{}
+	probe.SSHKeyCreatedAt = time.Time{}
 
 }
 
-func AddOne(probe Probe) (ProbeID string, err error) {
-
-	hashID := toHash(probe)
+// prepare fills the id, timestamps and location of a new probe and
+// validates it. The DNS checks and the age of its ssh keys are the
+// registry's own, the ones given are dropped.
+func prepare(probe *Probe) error {
+	hashID := toHash(*probe)
 	probe.ProbeID = hashID
 	probe.CreatedAt = time.Now()
 	probe.UpdatedAt = time.Now()
 	probe.DisabledAt = time.Time{}
+	probe.DNSForward = false
+	probe.DNSReverse = false
+	probe.DNSVerifiedAt = time.Time{}
+	probe.SSHKeyCreatedAt = time.Time{}
 
-	log.Infof("[models.AddOne] new probe %+v", probe)
-	ok, err := Validate(probe)
+	if provider, err := LookupProvider(probe.Provider); err == nil {
+		probe.Provider = provider.Name
+	}
+	ok, err := Validate(*probe)
 	if !ok {
-		return "", err
+		return err
 	}
 
 	record := gip.GetRecord(probe.Ipv4)
@@ -172,15 +222,27 @@ func AddOne(probe Probe) (ProbeID string, err error) {
 		probe.Country = record.CountryName
 	}
 
-	ok, err = Validate(probe)
+	ok, err = Validate(*probe)
 	if !ok {
+		return err
+	}
+	return nil
+}
+
+func AddOne(probe Probe) (ProbeID string, err error) {
+	log.Infof("[models.AddOne] new probe %+v", probe)
+	if err := prepare(&probe); err != nil {
 		return "", err
 	}
+	if dnsVerifyOnRegister {
+		verifyDNS(&probe)
+	}
 
 	_, err = o.Insert(&probe)
 	if err != nil {
 		log.Fatal(err)
 	}
+	notify(EventRegistered, &probe)
 	return probe.ProbeID, nil
 }
 
@@ -232,7 +294,7 @@ func GetByFQDN(fqdn string) ([]Probe, error) {
 func GetAll() []*Probe {
 	var probes []*Probe
 	num, err := o.QueryTable("probe").Filter("enabled", true).All(&probes)
-	log.Debugf("[models.probe.GetAll]: Returned Rows Num: %s, %s", num, err)
+	log.Debugf("[models.probe.GetAll]: Returned Rows Num: %d, %v", num, err)
 	return probes
 }
 
@@ -247,11 +309,20 @@ func Disable(ProbeID string) (*Probe, error) {
 		if err != nil {
 			return nil, err
 		}
+		disabled(probe)
 		return probe, nil
 	}
 	return nil, err
 }
 
+// disabled revokes the certificates of a probe once disabled
+func disabled(probe *Probe) {
+	if _, err := RevokeProbeCertificates(probe.ProbeID, RevokedDisabled); err != nil {
+		log.Errorf("[model.probe.Disable]: unable to revoke the certificates of probe %s: %s", probe.ProbeID, err)
+	}
+	notify(EventDisabled, probe)
+}
+
 func Enable(ProbeID string) (*Probe, error) {
 	log.Infof("[model.probe.Enable]: enabling probe %s", ProbeID)
 	probe, err := GetByID(ProbeID)
@@ -263,6 +334,7 @@ func Enable(ProbeID string) (*Probe, error) {
 		if err != nil {
 			return nil, err
 		}
+		notify(EventEnabled, probe)
 		return probe, nil
 	}
 	return nil, err
@@ -284,11 +356,13 @@ func UploadSSH(ProbeID string, SSHPrivateKey string, SSHPublicKey string) (*Prob
 		probe.SSHPrivateKey = SSHPrivateKey
 		probe.SSHPublicKey = SSHPublicKey
 		probe.UpdatedAt = time.Now()
+		probe.SSHKeyCreatedAt = probe.UpdatedAt
 		_, err = o.Update(probe)
 		log.Infof("[model.probe.UploadSSH]: Saving new key for probe %s", ProbeID)
 		if err != nil {
 			return nil, err
 		}
+		notify(EventRekeyed, probe)
 		return probe, nil
 	}
 	return nil, err
@@ -308,6 +382,7 @@ func UpdateTracesPath(ProbeID string, traces_path string) (*Probe, error) {
 		if err != nil {
 			return nil, err
 		}
+		notify(EventTracesPathUpdated, probe)
 		return probe, nil
 	}
 	return nil, err
@@ -337,6 +412,14 @@ func GetSSH(ProbeID string) (*ProbeSSHKeys, error) {
 	}
 	keys.Private = fmt.Sprintf("%s", decodedPrivate)
 	keys.Public = fmt.Sprintf("%s", decodedPublic)
+	if rotation, err := GetKeyRotation(ProbeID); err == nil {
+		if keys.StagedPrivate, err = decodeSSHKey(rotation.PrivateKey); err != nil {
+			return nil, err
+		}
+		if keys.StagedPublic, err = decodeSSHKey(rotation.PublicKey); err != nil {
+			return nil, err
+		}
+	}
 	return &keys, nil
 }
 
@@ -350,7 +433,26 @@ func Delete(ProbeID string) (bool, error) {
 		if err != nil {
 			return false, err
 		}
+		deleted(probe)
 		return true, nil
 	}
 	return false, err
 }
+
+// deleted removes what belongs to a probe once deleted
+func deleted(probe *Probe) {
+	ProbeID := probe.ProbeID
+	if err := deleteHostKeys(ProbeID); err != nil {
+		log.Errorf("[model.probe.Delete]: unable to remove host keys of probe %s: %s", ProbeID, err)
+	}
+	if err := deleteKeyRotation(ProbeID); err != nil {
+		log.Errorf("[model.probe.Delete]: unable to remove staged ssh key of probe %s: %s", ProbeID, err)
+	}
+	if err := removeGroupMemberships(ProbeID); err != nil {
+		log.Errorf("[model.probe.Delete]: unable to remove probe %s from its groups: %s", ProbeID, err)
+	}
+	if _, err := RevokeProbeCertificates(ProbeID, RevokedDeleted); err != nil {
+		log.Errorf("[model.probe.Delete]: unable to revoke the certificates of probe %s: %s", ProbeID, err)
+	}
+	notify(EventDeleted, probe)
+}
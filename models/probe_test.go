@@ -0,0 +1,35 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddOneDropsRegistryFields(t *testing.T) {
+	var probe Probe
+	probe.SetDefaults()
+	probe.FQDN = "claimed.example.com"
+	probe.Ipv4 = "192.0.2.40"
+	probe.Provider = "AWS"
+	probe.GeoLatitude = "40.4"
+	probe.GeoLongitude = "-3.7"
+	probe.DNSForward = true
+	probe.DNSReverse = true
+	probe.DNSVerifiedAt = time.Now()
+	probe.SSHKeyCreatedAt = time.Now().Add(24 * time.Hour)
+	ProbeID, err := AddOne(probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Delete(ProbeID)
+	added, err := GetByID(ProbeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.DNSForward || added.DNSReverse || !added.DNSVerifiedAt.IsZero() {
+		t.Errorf("dns checks taken from the request: %t %t %s", added.DNSForward, added.DNSReverse, added.DNSVerifiedAt)
+	}
+	if !added.SSHKeyCreatedAt.IsZero() {
+		t.Errorf("ssh key age taken from the request: %s", added.SSHKeyCreatedAt)
+	}
+}
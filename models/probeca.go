@@ -0,0 +1,320 @@
+package models
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego/orm"
+)
+
+// Revocation reasons of probe certificates
+const (
+	RevokedSuperseded = "superseded"
+	RevokedDisabled   = "probe disabled"
+	RevokedDeleted    = "probe deleted"
+	RevokedManually   = "revoked"
+)
+
+// probeCAValidity is how long the probe CA certificate generated by the
+// registry lasts
+const probeCAValidity = 10 * 365 * 24 * time.Hour
+
+// ProbeCertificate records a client certificate the probe CA issued to a
+// probe, whose common name is the ProbeID. Its private key is only given to
+// the probe when issued.
+type ProbeCertificate struct {
+	ID           int64     `orm:"pk;auto;column(id)" json:"id"`
+	ProbeID      string    `orm:"size(100);column(probe_id);index" json:"ProbeID"`
+	Serial       string    `orm:"size(40);unique" json:"serial"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	CreatedAt    time.Time `json:"created_at"`
+	Revoked      bool      `orm:"index" json:"revoked"`
+	RevokedAt    time.Time `orm:"null" json:"revoked_at"`
+	RevokeReason string    `orm:"size(64)" json:"revoke_reason,omitempty"`
+}
+
+// ProbeCredentials are a client certificate and its private key in PEM,
+// along with the probe CA certificate
+type ProbeCredentials struct {
+	Certificate   string            `json:"certificate"`
+	PrivateKey    string            `json:"privatekey"`
+	CACertificate string            `json:"cacertificate"`
+	Record        *ProbeCertificate `json:"record"`
+}
+
+var (
+	probeCAKey       *ecdsa.PrivateKey
+	probeCACert      *x509.Certificate
+	probeCAPEM       []byte
+	probeCertDefault = 365 * 24 * time.Hour
+)
+
+// ConfigureProbeCA loads the probe CA from the ca.crt and ca.key files of
+// dir, generating them when missing. Client certificates issued to probes
+// last validity.
+func ConfigureProbeCA(dir string, validity time.Duration) error {
+	certFile := filepath.Join(dir, "ca.crt")
+	keyFile := filepath.Join(dir, "ca.key")
+	if _, err := os.Stat(keyFile); os.IsNotExist(err) {
+		log.Infof("[models.probeca.ConfigureProbeCA]: generating the probe CA in %s", dir)
+		if err := generateProbeCA(certFile, keyFile); err != nil {
+			return err
+		}
+	}
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return fmt.Errorf("invalid probe CA in %s", dir)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return fmt.Errorf("invalid probe CA certificate: %s", err)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return fmt.Errorf("invalid probe CA key: %s", err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok || !key.PublicKey.Equal(cert.PublicKey) {
+		return errors.New("the probe CA key does not match its certificate")
+	}
+	probeCAKey = key
+	probeCACert = cert
+	probeCAPEM = certPEM
+	probeCertDefault = validity
+	return nil
+}
+
+func generateProbeCA(certFile string, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "sinker registry probe CA"},
+		NotBefore:             now.Add(-certClockSkew),
+		NotAfter:              now.Add(probeCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// ProbeCAConfigured reports whether the registry issues certificates to
+// probes
+func ProbeCAConfigured() bool {
+	return probeCAKey != nil
+}
+
+// ProbeCAPool returns the pool TLS client certificates are verified with
+func ProbeCAPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	if probeCACert != nil {
+		pool.AddCert(probeCACert)
+	}
+	return pool
+}
+
+// ProbeCACertificate returns the probe CA certificate in PEM
+func ProbeCACertificate() (string, error) {
+	if !ProbeCAConfigured() {
+		return "", errors.New("the probe certificate authority is not configured")
+	}
+	return string(probeCAPEM), nil
+}
+
+// IssueProbeCertificate issues a client certificate to a probe, revoking
+// the ones it had. It only authenticates the probe while it is enabled.
+func IssueProbeCertificate(ProbeID string) (*ProbeCredentials, error) {
+	if !ProbeCAConfigured() {
+		return nil, errors.New("the probe certificate authority is not configured")
+	}
+	if _, err := GetByID(ProbeID); err != nil {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: ProbeID},
+		NotBefore:    now.Add(-certClockSkew),
+		NotAfter:     now.Add(probeCertDefault),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, probeCACert, &key.PublicKey, probeCAKey)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	record := &ProbeCertificate{
+		ProbeID:   ProbeID,
+		Serial:    serial.Text(16),
+		NotBefore: template.NotBefore,
+		NotAfter:  template.NotAfter,
+		CreatedAt: now,
+	}
+	// the new certificate supersedes the former ones in a single transaction,
+	// a probe is never left without a valid certificate
+	tx := orm.NewOrm()
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Insert(record); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if _, err := revokeProbeCertificates(tx, ProbeID, RevokedSuperseded, record.ID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	log.Infof("[models.probeca.IssueProbeCertificate]: issued certificate %s to probe %s until %s", record.Serial, ProbeID, record.NotAfter.Format(time.RFC3339))
+	return &ProbeCredentials{
+		Certificate:   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKey:    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+		CACertificate: string(probeCAPEM),
+		Record:        record,
+	}, nil
+}
+
+// RevokeProbeCertificates revokes the certificates of a probe, returning
+// how many were still valid
+func RevokeProbeCertificates(ProbeID string, reason string) (int64, error) {
+	return revokeProbeCertificates(o, ProbeID, reason, 0)
+}
+
+// revokeProbeCertificates revokes the certificates of a probe but the one
+// with id keep, 0 for none
+func revokeProbeCertificates(db orm.Ormer, ProbeID string, reason string, keep int64) (int64, error) {
+	qs := db.QueryTable("probe_certificate").Filter("probe_id", ProbeID).Filter("revoked", false)
+	if keep != 0 {
+		qs = qs.Exclude("id", keep)
+	}
+	count, err := qs.Update(orm.Params{
+		"revoked":       true,
+		"revoked_at":    time.Now(),
+		"revoke_reason": reason,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		log.Infof("[models.probeca.RevokeProbeCertificates]: revoked %d certificates of probe %s: %s", count, ProbeID, reason)
+	}
+	return count, nil
+}
+
+// GetProbeCertificates returns the certificates issued to a probe, newest
+// first
+func GetProbeCertificates(ProbeID string) ([]*ProbeCertificate, error) {
+	certs := []*ProbeCertificate{}
+	_, err := o.QueryTable("probe_certificate").Filter("probe_id", ProbeID).OrderBy("-id").Limit(-1).All(&certs)
+	return certs, err
+}
+
+// AuthenticateProbe returns the ProbeID of a client certificate the TLS
+// handshake verified against the probe CA, refusing revoked ones and the
+// ones of disabled probes
+func AuthenticateProbe(cert *x509.Certificate) (string, error) {
+	record := ProbeCertificate{Serial: cert.SerialNumber.Text(16)}
+	if err := o.Read(&record, "Serial"); err != nil {
+		return "", fmt.Errorf("unknown certificate %s", record.Serial)
+	}
+	if record.Revoked {
+		return "", fmt.Errorf("certificate %s was revoked: %s", record.Serial, record.RevokeReason)
+	}
+	if cert.Subject.CommonName != record.ProbeID {
+		return "", fmt.Errorf("certificate %s was not issued to %s", record.Serial, cert.Subject.CommonName)
+	}
+	probe, err := GetByID(record.ProbeID)
+	if err != nil {
+		return "", err
+	}
+	if !probe.Enabled {
+		return "", fmt.Errorf("probe %s is disabled", record.ProbeID)
+	}
+	return record.ProbeID, nil
+}
+
+// ProbeCRL returns the revocation list of the probe CA in DER, it lists
+// the revoked certificates not expired yet
+func ProbeCRL() ([]byte, error) {
+	if !ProbeCAConfigured() {
+		return nil, errors.New("the probe certificate authority is not configured")
+	}
+	var revoked []*ProbeCertificate
+	now := time.Now()
+	if _, err := o.QueryTable("probe_certificate").Filter("revoked", true).Filter("not_after__gt", now).OrderBy("id").Limit(-1).All(&revoked); err != nil {
+		return nil, err
+	}
+	list := &x509.RevocationList{
+		Number:     big.NewInt(now.Unix()),
+		ThisUpdate: now,
+		NextUpdate: now.Add(24 * time.Hour),
+	}
+	for _, cert := range revoked {
+		serial, ok := new(big.Int).SetString(cert.Serial, 16)
+		if !ok {
+			continue
+		}
+		list.RevokedCertificateEntries = append(list.RevokedCertificateEntries, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: cert.RevokedAt})
+	}
+	return x509.CreateRevocationList(rand.Reader, list, probeCACert, probeCAKey)
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+)
+
+var prometheusLabelRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// PrometheusTargetGroup is an entry of a Prometheus http_sd_config response
+type PrometheusTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// ToPrometheusTargets returns one target group per probe, scraping port on
+// its IPv4 address. Probe labels are given as __meta_sinker_label_<key>, along
+// with __meta_sinker_labelpresent_<key> as Prometheus drops empty labels.
+func ToPrometheusTargets(probes []*Probe, port int) []PrometheusTargetGroup {
+	groups := make([]PrometheusTargetGroup, 0, len(probes))
+	for _, probe := range probes {
+		labels := map[string]string{
+			"__meta_sinker_probe_id": probe.ProbeID,
+			"__meta_sinker_fqdn":     probe.FQDN,
+			"__meta_sinker_provider": probe.Provider,
+			"__meta_sinker_country":  probe.Country,
+			"__meta_sinker_region":   probe.Region,
+		}
+		for key, value := range probe.Labels {
+			name := prometheusLabelRegexp.ReplaceAllString(key, "_")
+			labels["__meta_sinker_label_"+name] = value
+			labels["__meta_sinker_labelpresent_"+name] = "true"
+		}
+		groups = append(groups, PrometheusTargetGroup{
+			Targets: []string{net.JoinHostPort(probe.Ipv4, strconv.Itoa(port))},
+			Labels:  labels,
+		})
+	}
+	return groups
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestToPrometheusTargets checks every probe is a target group of its own,
+// scraped on the given port and described by meta labels
+func TestToPrometheusTargets(t *testing.T) {
+	probes := []*Probe{
+		{ProbeID: "madrid", FQDN: "madrid.example.com", Ipv4: "192.0.2.150", Provider: "AWS", Country: "ES", Region: "eu-south-2"},
+		{ProbeID: "paris", FQDN: "paris.example.com", Ipv4: "192.0.2.151", Provider: "Vultr", Country: "FR"},
+	}
+	groups := ToPrometheusTargets(probes, 9100)
+	if len(groups) != 2 {
+		t.Fatalf("unexpected target groups %+v", groups)
+	}
+	if !reflect.DeepEqual(groups[0].Targets, []string{"192.0.2.150:9100"}) || !reflect.DeepEqual(groups[1].Targets, []string{"192.0.2.151:9100"}) {
+		t.Errorf("unexpected targets %v %v", groups[0].Targets, groups[1].Targets)
+	}
+	expected := map[string]string{
+		"__meta_sinker_probe_id": "madrid",
+		"__meta_sinker_fqdn":     "madrid.example.com",
+		"__meta_sinker_provider": "AWS",
+		"__meta_sinker_country":  "ES",
+		"__meta_sinker_region":   "eu-south-2",
+	}
+	if !reflect.DeepEqual(groups[0].Labels, expected) {
+		t.Errorf("unexpected labels %v", groups[0].Labels)
+	}
+	if empty := ToPrometheusTargets(nil, 9100); empty == nil || len(empty) != 0 {
+		t.Errorf("empty fleet not given as an empty list: %v", empty)
+	}
+}
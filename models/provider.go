@@ -0,0 +1,283 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego/orm"
+)
+
+// Provider is a cloud provider probes can be registered with. ASNs and
+// Regions are stored JSON encoded in ASNList and RegionList.
+type Provider struct {
+	Slug       string    `orm:"pk;size(64)" json:"slug"`
+	Name       string    `orm:"size(100);unique" json:"name"`
+	ASNs       []uint32  `orm:"-" json:"asns"`
+	Regions    []string  `orm:"-" json:"regions"`
+	ASNList    string    `orm:"type(text)" json:"-"`
+	RegionList string    `orm:"type(text)" json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+var slugRegexp = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// defaultProviders seeds an empty catalogue with the providers supported
+// before the catalogue existed, along with the regions shipped with the
+// registry
+var defaultProviders = []Provider{
+	{Slug: "digitalocean", Name: "Digital Ocean", ASNs: []uint32{14061}, Regions: []string{
+		"nyc1", "nyc2", "nyc3", "sfo1", "sfo2", "sfo3", "tor1", "ams2", "ams3",
+		"lon1", "fra1", "blr1", "sgp1", "syd1",
+	}},
+	{Slug: "vultr", Name: "Vultr", ASNs: []uint32{20473}, Regions: []string{
+		"ewr", "ord", "dfw", "sea", "lax", "atl", "mia", "sjc", "yto", "mex",
+		"sao", "ams", "lhr", "fra", "cdg", "mad", "waw", "sto", "jnb", "bom",
+		"sgp", "nrt", "icn", "syd",
+	}},
+	{Slug: "aws", Name: "AWS", ASNs: []uint32{16509, 14618}, Regions: []string{
+		"us-east-1", "us-east-2", "us-west-1", "us-west-2", "ca-central-1",
+		"sa-east-1", "eu-west-1", "eu-west-2", "eu-west-3", "eu-central-1",
+		"eu-north-1", "eu-south-1", "me-south-1", "af-south-1", "ap-east-1",
+		"ap-south-1", "ap-northeast-1", "ap-northeast-2", "ap-northeast-3",
+		"ap-southeast-1", "ap-southeast-2",
+	}},
+	{Slug: "googlecloud", Name: "Google Cloud", ASNs: []uint32{15169, 396982}, Regions: []string{
+		"us-central1", "us-east1", "us-east4", "us-west1", "us-west2",
+		"northamerica-northeast1", "southamerica-east1", "europe-north1",
+		"europe-west1", "europe-west2", "europe-west3", "europe-west4",
+		"europe-west6", "asia-east1", "asia-east2", "asia-northeast1",
+		"asia-south1", "asia-southeast1", "australia-southeast1",
+	}},
+	{Slug: "linode", Name: "Linode", ASNs: []uint32{63949}, Regions: []string{
+		"us-east", "us-central", "us-west", "us-southeast", "ca-central",
+		"eu-west", "eu-central", "ap-south", "ap-northeast", "ap-west",
+		"ap-southeast",
+	}},
+	{Slug: "hetzner", Name: "Hetzner", ASNs: []uint32{24940}, Regions: []string{
+		"fsn1", "nbg1", "hel1", "ash", "hil",
+	}},
+}
+
+func (provider *Provider) pack() error {
+	if provider.ASNs == nil {
+		provider.ASNs = []uint32{}
+	}
+	if provider.Regions == nil {
+		provider.Regions = []string{}
+	}
+	asns, err := json.Marshal(provider.ASNs)
+	if err != nil {
+		return err
+	}
+	regions, err := json.Marshal(provider.Regions)
+	if err != nil {
+		return err
+	}
+	provider.ASNList = string(asns)
+	provider.RegionList = string(regions)
+	return nil
+}
+
+func (provider *Provider) unpack() error {
+	provider.ASNs = []uint32{}
+	provider.Regions = []string{}
+	if provider.ASNList != "" {
+		if err := json.Unmarshal([]byte(provider.ASNList), &provider.ASNs); err != nil {
+			return fmt.Errorf("corrupted asn list for provider %s: %s", provider.Slug, err)
+		}
+	}
+	if provider.RegionList != "" {
+		if err := json.Unmarshal([]byte(provider.RegionList), &provider.Regions); err != nil {
+			return fmt.Errorf("corrupted region list for provider %s: %s", provider.Slug, err)
+		}
+	}
+	return nil
+}
+
+// HasRegion reports whether region is one of the provider regions
+func (provider *Provider) HasRegion(region string) bool {
+	for _, r := range provider.Regions {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateProvider(provider Provider) error {
+	if !slugRegexp.MatchString(provider.Slug) {
+		return fmt.Errorf("invalid slug `%s`, expected lowercase letters, digits and dashes", provider.Slug)
+	}
+	if strings.TrimSpace(provider.Name) == "" {
+		return errors.New("provider name is required")
+	}
+	for _, asn := range provider.ASNs {
+		if asn == 0 {
+			return errors.New("invalid asn 0")
+		}
+	}
+	for _, region := range provider.Regions {
+		if !slugRegexp.MatchString(region) {
+			return fmt.Errorf("invalid region `%s`, expected lowercase letters, digits and dashes", region)
+		}
+	}
+	return nil
+}
+
+func AddProvider(provider Provider) (*Provider, error) {
+	log.Infof("[models.provider.AddProvider]: new provider %+v", provider)
+	if err := ValidateProvider(provider); err != nil {
+		return nil, err
+	}
+	if _, err := LookupProvider(provider.Slug); err == nil {
+		return nil, fmt.Errorf("provider already registered %s", provider.Slug)
+	}
+	if _, err := LookupProvider(provider.Name); err == nil {
+		return nil, fmt.Errorf("provider name already registered %s", provider.Name)
+	}
+	provider.CreatedAt = time.Now()
+	provider.UpdatedAt = time.Now()
+	if err := provider.pack(); err != nil {
+		return nil, err
+	}
+	if _, err := o.Insert(&provider); err != nil {
+		return nil, err
+	}
+	return &provider, nil
+}
+
+func GetProvider(slug string) (*Provider, error) {
+	provider := Provider{Slug: slug}
+	err := o.Read(&provider)
+	if err == orm.ErrNoRows || err == orm.ErrMissPK {
+		log.Warningf("[models.provider.GetProvider]: No result found for slug %s", slug)
+		return nil, errors.New("Provider not found")
+	} else if err != nil {
+		return nil, err
+	}
+	if err := provider.unpack(); err != nil {
+		return nil, err
+	}
+	return &provider, nil
+}
+
+// LookupProvider finds a provider by slug or by display name, the latter
+// being what probes have always stored
+func LookupProvider(descr string) (*Provider, error) {
+	if descr == "" {
+		return nil, errors.New("Provider not found")
+	}
+	var provider Provider
+	cond := orm.NewCondition().Or("slug", descr).Or("name__iexact", descr)
+	err := o.QueryTable("provider").SetCond(cond).One(&provider)
+	if err == orm.ErrNoRows {
+		return nil, errors.New("Provider not found")
+	} else if err != nil {
+		return nil, err
+	}
+	if err := provider.unpack(); err != nil {
+		return nil, err
+	}
+	return &provider, nil
+}
+
+func GetAllProviders() ([]*Provider, error) {
+	var providers []*Provider
+	_, err := o.QueryTable("provider").OrderBy("slug").All(&providers)
+	if err != nil {
+		return nil, err
+	}
+	for _, provider := range providers {
+		if err := provider.unpack(); err != nil {
+			return nil, err
+		}
+	}
+	return providers, nil
+}
+
+// UpdateProvider replaces name, ASNs and regions of a provider, probes
+// registered with the former name are moved to the new one
+func UpdateProvider(slug string, update Provider) (*Provider, error) {
+	log.Infof("[models.provider.UpdateProvider]: updating provider %s", slug)
+	provider, err := GetProvider(slug)
+	if err != nil {
+		return nil, err
+	}
+	update.Slug = slug
+	if err := ValidateProvider(update); err != nil {
+		return nil, err
+	}
+	if other, err := LookupProvider(update.Name); err == nil && other.Slug != slug {
+		return nil, fmt.Errorf("provider name already registered %s", update.Name)
+	}
+	formerName := provider.Name
+	provider.Name = update.Name
+	provider.ASNs = update.ASNs
+	provider.Regions = update.Regions
+	provider.UpdatedAt = time.Now()
+	if err := provider.pack(); err != nil {
+		return nil, err
+	}
+
+	tx := orm.NewOrm()
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Update(provider); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if formerName != provider.Name {
+		_, err := tx.QueryTable("probe").Filter("provider", formerName).Update(orm.Params{"provider": provider.Name})
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// DeleteProvider removes a provider that no probe is registered with
+func DeleteProvider(slug string) error {
+	log.Infof("[models.provider.DeleteProvider]: removing provider %s", slug)
+	provider, err := GetProvider(slug)
+	if err != nil {
+		return err
+	}
+	count, err := o.QueryTable("probe").Filter("provider", provider.Name).Count()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("provider %s still has %d probes", slug, count)
+	}
+	_, err = o.Delete(provider)
+	return err
+}
+
+// seedProviders fills an empty catalogue with the default providers, along
+// with their shipped regions. A catalogue already filled is left alone.
+func seedProviders() {
+	count, err := o.QueryTable("provider").Count()
+	if err != nil {
+		log.Errorf("[models.provider.seedProviders]: Error querying database %s", err)
+		return
+	}
+	if count > 0 {
+		return
+	}
+	for _, provider := range defaultProviders {
+		if _, err := AddProvider(provider); err != nil {
+			log.Errorf("[models.provider.seedProviders]: unable to add provider %s: %s", provider.Slug, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package models
+
+import "testing"
+
+// TestProviderCatalogue checks providers are validated, found by slug or
+// name, renamed along with their probes and only removed once unused
+func TestProviderCatalogue(t *testing.T) {
+	for _, invalid := range []Provider{
+		{Slug: "Upper", Name: "Upper"},
+		{Slug: "nameless"},
+		{Slug: "zero-asn", Name: "Zero", ASNs: []uint32{0}},
+		{Slug: "bad-region", Name: "Bad region", Regions: []string{"Eu West"}},
+	} {
+		if err := ValidateProvider(invalid); err == nil {
+			t.Errorf("invalid provider %+v accepted", invalid)
+		}
+	}
+
+	provider, err := AddProvider(Provider{Slug: "scaleway", Name: "Scaleway", ASNs: []uint32{12876}, Regions: []string{"par1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteProvider(provider.Slug)
+	if _, err := AddProvider(Provider{Slug: "scaleway", Name: "Other"}); err == nil {
+		t.Error("provider slug registered twice")
+	}
+	if _, err := AddProvider(Provider{Slug: "other", Name: "scaleway"}); err == nil {
+		t.Error("provider name registered twice")
+	}
+	if found, err := LookupProvider("SCALEWAY"); err != nil || found.Slug != "scaleway" || !found.HasRegion("par1") {
+		t.Errorf("provider not found by name: %+v %v", found, err)
+	}
+
+	var probe Probe
+	probe.SetDefaults()
+	probe.FQDN = "catalogued.example.com"
+	probe.Ipv4 = "192.0.2.130"
+	probe.Provider = "scaleway"
+	ProbeID, err := AddOne(probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added, _ := GetByID(ProbeID); added.Provider != "Scaleway" {
+		t.Errorf("probe registered with provider %s rather than its name", added.Provider)
+	}
+	if err := DeleteProvider("scaleway"); err == nil {
+		t.Error("provider of a registered probe removed")
+	}
+	if _, err := UpdateProvider("scaleway", Provider{Name: "Scaleway Elements", ASNs: provider.ASNs}); err != nil {
+		t.Fatal(err)
+	}
+	if moved, _ := GetByID(ProbeID); moved.Provider != "Scaleway Elements" {
+		t.Errorf("probe not moved to the new provider name: %s", moved.Provider)
+	}
+	Delete(ProbeID)
+	if err := DeleteProvider("scaleway"); err != nil {
+		t.Errorf("unused provider not removed: %s", err)
+	}
+	if _, err := GetProvider("scaleway"); err == nil {
+		t.Error("removed provider still found")
+	}
+}
+
+// TestSeedKeepsRegions checks the regions of a seeded provider are only
+// seeded when it is created, not again once an admin cleared them
+func TestSeedKeepsRegions(t *testing.T) {
+	provider, err := GetProvider("linode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.Regions) == 0 {
+		t.Fatalf("provider %s seeded without regions", provider.Slug)
+	}
+	shipped := provider.Regions
+	defer func() {
+		provider.Regions = shipped
+		UpdateProvider(provider.Slug, *provider)
+	}()
+
+	provider.Regions = []string{}
+	if _, err := UpdateProvider(provider.Slug, *provider); err != nil {
+		t.Fatal(err)
+	}
+	seedProviders()
+	if current, _ := GetProvider(provider.Slug); len(current.Regions) != 0 {
+		t.Errorf("cleared regions of %s seeded again: %v", provider.Slug, current.Regions)
+	}
+}
@@ -0,0 +1,266 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego/orm"
+)
+
+// Rate limit classes, routes reading probe secrets are given a budget of
+// their own, smaller than the one of other reads
+const (
+	RateRead   = "read"
+	RateWrite  = "write"
+	RateSecret = "secret"
+)
+
+// Where the rate limiter keeps its buckets
+const (
+	RateStoreMemory   = "memory"
+	RateStoreDatabase = "database"
+)
+
+const (
+	// rateSweepInterval is how often idle buckets are forgotten
+	rateSweepInterval = 5 * time.Minute
+	// rateRetries bounds the attempts to update a bucket other replicas are
+	// updating too
+	rateRetries = 5
+)
+
+// RateLimit is a token bucket budget, Burst requests at once refilled at
+// PerMinute requests a minute
+type RateLimit struct {
+	PerMinute int
+	Burst     int
+}
+
+// RateBucket is the state of a token bucket kept in the database, so
+// replicas of the registry share the budgets of their callers
+type RateBucket struct {
+	Key    string `orm:"pk;size(128);column(bucket)"`
+	Tokens float64
+	// Stamp is when the bucket was last updated, in unix nanoseconds
+	Stamp int64 `orm:"index"`
+}
+
+// RateCharge is a bucket a call is charged to, whose budget is Scale times
+// the one of the class of the call
+type RateCharge struct {
+	Key   string
+	Scale int
+}
+
+var (
+	rateMu      sync.Mutex
+	rateLimits  map[string]RateLimit
+	rateStore   string
+	rateBuckets = make(map[string]*RateBucket)
+	rateSweeper sync.Once
+)
+
+// ConfigureRateLimits limits the calls of every caller to the budget of the
+// class of each call, keeping the buckets in memory or in the database
+func ConfigureRateLimits(limits map[string]RateLimit, store string) error {
+	for class, limit := range limits {
+		if limit.PerMinute <= 0 || limit.Burst <= 0 {
+			return fmt.Errorf("invalid %s rate limit %d/min burst %d", class, limit.PerMinute, limit.Burst)
+		}
+	}
+	if store != RateStoreMemory && store != RateStoreDatabase {
+		return fmt.Errorf("unknown rate limit store %s, expected %s or %s", store, RateStoreMemory, RateStoreDatabase)
+	}
+	rateMu.Lock()
+	rateLimits = limits
+	rateStore = store
+	rateMu.Unlock()
+	rateSweeper.Do(func() {
+		go func() {
+			for range time.Tick(rateSweepInterval) {
+				sweepRateBuckets()
+			}
+		}()
+	})
+	return nil
+}
+
+// RateLimitEnabled reports whether calls are rate limited
+func RateLimitEnabled() bool {
+	rateMu.Lock()
+	defer rateMu.Unlock()
+	return rateLimits != nil
+}
+
+// TakeRates takes a token from every bucket a call of class is charged to,
+// or from none of them when one is empty, returning how long to wait before
+// retrying then
+func TakeRates(class string, charges []RateCharge) (bool, time.Duration, error) {
+	rateMu.Lock()
+	limit, ok := rateLimits[class]
+	store := rateStore
+	rateMu.Unlock()
+	if !ok || len(charges) == 0 {
+		return true, 0, nil
+	}
+	limits := make([]RateLimit, len(charges))
+	keys := make([]string, len(charges))
+	for i, charge := range charges {
+		limits[i] = limit
+		if charge.Scale > 1 {
+			limits[i] = RateLimit{PerMinute: limit.PerMinute * charge.Scale, Burst: limit.Burst * charge.Scale}
+		}
+		keys[i] = class + ":" + charge.Key
+	}
+	if store == RateStoreDatabase {
+		return takeStoredRates(limits, keys)
+	}
+	rateMu.Lock()
+	defer rateMu.Unlock()
+	now := time.Now()
+	buckets := make([]*RateBucket, len(keys))
+	for i, key := range keys {
+		bucket, ok := rateBuckets[key]
+		if !ok {
+			bucket = &RateBucket{Key: key, Tokens: float64(limits[i].Burst), Stamp: now.UnixNano()}
+			rateBuckets[key] = bucket
+		}
+		buckets[i] = bucket
+	}
+	allowed, wait := takeAll(limits, buckets, now)
+	return allowed, wait, nil
+}
+
+// take refills bucket for the time elapsed since it was last updated and
+// takes a token from it if there is one
+func (l RateLimit) take(bucket *RateBucket, now time.Time) (bool, time.Duration) {
+	return takeAll([]RateLimit{l}, []*RateBucket{bucket}, now)
+}
+
+// takeAll refills every bucket for the time elapsed since it was last
+// updated, and takes a token from each of them if they all hold one.
+// Otherwise it returns the longest wait for a token.
+func takeAll(limits []RateLimit, buckets []*RateBucket, now time.Time) (bool, time.Duration) {
+	var wait time.Duration
+	for i, bucket := range buckets {
+		rate := float64(limits[i].PerMinute) / float64(time.Minute)
+		elapsed := float64(now.UnixNano() - bucket.Stamp)
+		if elapsed > 0 {
+			bucket.Tokens = math.Min(float64(limits[i].Burst), bucket.Tokens+elapsed*rate)
+		}
+		bucket.Stamp = now.UnixNano()
+		if bucket.Tokens < 1 {
+			if w := time.Duration(math.Ceil((1 - bucket.Tokens) / rate)); w > wait {
+				wait = w
+			}
+		}
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, bucket := range buckets {
+		bucket.Tokens--
+	}
+	return true, 0
+}
+
+// takeStoredRates takes a token from buckets stored in the database, within
+// a transaction only committed when no other replica updated the buckets
+// meanwhile
+func takeStoredRates(limits []RateLimit, keys []string) (bool, time.Duration, error) {
+	for attempt := 0; attempt < rateRetries; attempt++ {
+		now := time.Now()
+		tx := orm.NewOrm()
+		if err := tx.Begin(); err != nil {
+			return false, 0, err
+		}
+		buckets := make([]*RateBucket, len(keys))
+		// previous holds the stamps the buckets were read with, 0 for the
+		// ones to create
+		previous := make([]int64, len(keys))
+		for i, key := range keys {
+			bucket := &RateBucket{Key: key}
+			err := tx.Read(bucket)
+			if err == orm.ErrNoRows {
+				bucket = &RateBucket{Key: key, Tokens: float64(limits[i].Burst), Stamp: now.UnixNano()}
+			} else if err != nil {
+				tx.Rollback()
+				return false, 0, err
+			} else {
+				previous[i] = bucket.Stamp
+			}
+			buckets[i] = bucket
+		}
+		allowed, wait := takeAll(limits, buckets, now)
+		stored := true
+		for i, bucket := range buckets {
+			if previous[i] == 0 {
+				// fails when another replica created it first
+				_, err := tx.Insert(bucket)
+				stored = err == nil
+			} else {
+				var err error
+				if stored, err = storeRateBucket(tx, bucket, previous[i]); err != nil {
+					tx.Rollback()
+					return false, 0, err
+				}
+			}
+			if !stored {
+				break
+			}
+		}
+		if !stored {
+			tx.Rollback()
+			continue
+		}
+		if err := tx.Commit(); err != nil {
+			return false, 0, err
+		}
+		return allowed, wait, nil
+	}
+	return false, 0, fmt.Errorf("rate buckets %v are contended", keys)
+}
+
+// storeRateBucket saves bucket unless it was updated since it was read with
+// the stamp previous, reporting whether it was saved
+func storeRateBucket(db orm.Ormer, bucket *RateBucket, previous int64) (bool, error) {
+	updated, err := db.QueryTable("rate_bucket").Filter("key", bucket.Key).Filter("stamp", previous).Update(orm.Params{
+		"tokens": bucket.Tokens,
+		"stamp":  bucket.Stamp,
+	})
+	return updated == 1, err
+}
+
+// sweepRateBuckets forgets the buckets full again, a missing bucket being
+// the same as a full one
+func sweepRateBuckets() {
+	rateMu.Lock()
+	longest := time.Duration(0)
+	for _, limit := range rateLimits {
+		if d := time.Duration(float64(limit.Burst) / float64(limit.PerMinute) * float64(time.Minute)); d > longest {
+			longest = d
+		}
+	}
+	store := rateStore
+	rateMu.Unlock()
+	before := time.Now().Add(-longest)
+	if store == RateStoreDatabase {
+		count, err := o.QueryTable("rate_bucket").Filter("stamp__lt", before.UnixNano()).Delete()
+		if err != nil {
+			log.Errorf("[models.ratelimit.sweepRateBuckets]: %s", err)
+		} else if count > 0 {
+			log.Debugf("[models.ratelimit.sweepRateBuckets]: forgot %d idle buckets", count)
+		}
+		return
+	}
+	rateMu.Lock()
+	defer rateMu.Unlock()
+	for key, bucket := range rateBuckets {
+		if bucket.Stamp < before.UnixNano() {
+			delete(rateBuckets, key)
+		}
+	}
+}
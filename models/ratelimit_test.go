@@ -0,0 +1,117 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRateLimitTake(t *testing.T) {
+	limit := RateLimit{PerMinute: 60, Burst: 3}
+	start := time.Unix(1000, 0)
+	bucket := &RateBucket{Key: "read:ip:192.0.2.1", Tokens: float64(limit.Burst), Stamp: start.UnixNano()}
+	for i := 0; i < limit.Burst; i++ {
+		if allowed, wait := limit.take(bucket, start); !allowed || wait != 0 {
+			t.Fatalf("call %d of the burst refused, wait %s", i, wait)
+		}
+	}
+	allowed, wait := limit.take(bucket, start)
+	if allowed || wait != time.Second {
+		t.Errorf("empty bucket: allowed %t, wait %s, expected a second", allowed, wait)
+	}
+	// half a token is refilled, the other half is waited for
+	allowed, wait = limit.take(bucket, start.Add(500*time.Millisecond))
+	if allowed || wait != 500*time.Millisecond {
+		t.Errorf("half refilled bucket: allowed %t, wait %s", allowed, wait)
+	}
+	if allowed, _ := limit.take(bucket, start.Add(time.Second)); !allowed {
+		t.Error("refilled token refused")
+	}
+	// an idle bucket refills up to its burst only
+	limit.take(bucket, start.Add(time.Hour))
+	if bucket.Tokens != float64(limit.Burst-1) {
+		t.Errorf("idle bucket holds %f tokens, expected %d", bucket.Tokens, limit.Burst-1)
+	}
+	// a clock going back refills nothing
+	tokens := bucket.Tokens
+	limit.take(bucket, start)
+	if bucket.Tokens != tokens-1 {
+		t.Errorf("bucket holds %f tokens after the clock went back, expected %f", bucket.Tokens, tokens-1)
+	}
+}
+
+func TestTakeStoredRate(t *testing.T) {
+	limit := RateLimit{PerMinute: 1, Burst: 2}
+	key := "secret:key:stored"
+	defer o.Delete(&RateBucket{Key: key})
+	for i := 0; i < limit.Burst; i++ {
+		if allowed, _, err := takeStoredRates([]RateLimit{limit}, []string{key}); err != nil || !allowed {
+			t.Fatalf("call %d of the burst refused: %v", i, err)
+		}
+	}
+	allowed, wait, err := takeStoredRates([]RateLimit{limit}, []string{key})
+	if err != nil || allowed || wait <= 0 || wait > time.Minute {
+		t.Errorf("empty bucket: allowed %t, wait %s, %v", allowed, wait, err)
+	}
+
+	// the bucket is shared, a call of another replica spends its budget too
+	stored := RateBucket{Key: key}
+	if err := o.Read(&stored); err != nil {
+		t.Fatal(err)
+	}
+	previous := stored.Stamp
+	stored.Tokens = float64(limit.Burst)
+	stored.Stamp = previous - int64(time.Hour)
+	if ok, err := storeRateBucket(o, &stored, previous); err != nil || !ok {
+		t.Fatalf("bucket not stored: %v", err)
+	}
+	if allowed, _, err := takeStoredRates([]RateLimit{limit}, []string{key}); err != nil || !allowed {
+		t.Errorf("call refused after the bucket was refilled: %v", err)
+	}
+
+	// an update based on a stale read is not applied
+	stale := RateBucket{Key: key, Tokens: 100, Stamp: time.Now().UnixNano()}
+	if ok, err := storeRateBucket(o, &stale, previous); err != nil || ok {
+		t.Errorf("stale bucket stored: %v", err)
+	}
+	if err := o.Read(&stored); err != nil || stored.Tokens >= float64(limit.Burst) {
+		t.Errorf("stale update applied, bucket holds %f tokens: %v", stored.Tokens, err)
+	}
+}
+
+// TestTakeRatesAllOrNothing checks a call refused by one of its buckets is
+// not charged to the others, whatever the store
+func TestTakeRatesAllOrNothing(t *testing.T) {
+	defer ConfigureRateLimits(nil, RateStoreMemory)
+	for _, store := range []string{RateStoreMemory, RateStoreDatabase} {
+		limits := map[string]RateLimit{RateWrite: {PerMinute: 1, Burst: 1}}
+		if err := ConfigureRateLimits(limits, store); err != nil {
+			t.Fatal(err)
+		}
+		key := RateCharge{Key: "key:" + store, Scale: 1}
+		ip := RateCharge{Key: "ip:" + store, Scale: 3}
+		defer o.Delete(&RateBucket{Key: RateWrite + ":" + key.Key})
+		defer o.Delete(&RateBucket{Key: RateWrite + ":" + ip.Key})
+
+		if allowed, _, err := TakeRates(RateWrite, []RateCharge{ip, key}); err != nil || !allowed {
+			t.Fatalf("%s: first call refused: %v", store, err)
+		}
+		for i := 0; i < 3; i++ {
+			if allowed, wait, err := TakeRates(RateWrite, []RateCharge{ip, key}); err != nil || allowed || wait <= 0 {
+				t.Errorf("%s: call %d over the budget of the key: allowed %t, wait %s, %v", store, i, allowed, wait, err)
+			}
+		}
+		// the source IP was charged once, two calls of other keys fit in
+		for i := 0; i < 2; i++ {
+			other := RateCharge{Key: fmt.Sprintf("key:%s-%d", store, i), Scale: 1}
+			defer o.Delete(&RateBucket{Key: RateWrite + ":" + other.Key})
+			if allowed, _, err := TakeRates(RateWrite, []RateCharge{ip, other}); err != nil || !allowed {
+				t.Errorf("%s: source IP charged for refused calls: %v", store, err)
+			}
+		}
+		if allowed, _, _ := TakeRates(RateWrite, []RateCharge{ip, {Key: "key:" + store + "-last", Scale: 1}}); allowed {
+			t.Errorf("%s: call over the budget of the source IP allowed", store)
+		}
+		o.Delete(&RateBucket{Key: RateWrite + ":key:" + store + "-last"})
+	}
+}
@@ -0,0 +1,232 @@
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/asaskevich/govalidator"
+	"github.com/astaxie/beego/orm"
+)
+
+// KeyRotation is a keypair staged to replace the ssh keys of a probe. Both
+// keypairs are handed out until it is promoted, so that the new public key
+// can be deployed before the old one stops working.
+type KeyRotation struct {
+	ID          int64     `orm:"pk;auto;column(id)" json:"-"`
+	ProbeID     string    `orm:"size(100);column(probe_id);unique" json:"ProbeID"`
+	PrivateKey  string    `orm:"type(text)" json:"-"`
+	PublicKey   string    `orm:"type(text)" json:"sshpublicKey"`
+	Fingerprint string    `orm:"size(64)" json:"fingerprint"`
+	StagedAt    time.Time `json:"staged_at"`
+	GraceUntil  time.Time `json:"grace_until"`
+}
+
+// StaleKey is a probe whose ssh keys are older than the maximum key age
+type StaleKey struct {
+	ProbeID      string    `json:"ProbeID"`
+	FQDN         string    `json:"fqdn"`
+	KeyCreatedAt time.Time `json:"sshkey_created_at"`
+	AgeDays      int       `json:"age_days"`
+	Staged       bool      `json:"staged"`
+}
+
+var (
+	keyGracePeriod = 24 * time.Hour
+	keyMaxAge      = 90 * 24 * time.Hour
+)
+
+// ConfigureKeyRotation sets how long both keypairs are handed out before a
+// staged one may be promoted, and the age after which keys are reported
+// as stale
+func ConfigureKeyRotation(grace time.Duration, maxAge time.Duration) {
+	keyGracePeriod = grace
+	keyMaxAge = maxAge
+}
+
+// KeyMaxAge returns the age after which keys are reported as stale
+func KeyMaxAge() time.Duration {
+	return keyMaxAge
+}
+
+// decodeSSHKey decodes a key stored base64 encoded, as uploaded by probes
+func decodeSSHKey(encoded string) (string, error) {
+	decoded, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		decoded, err = base64.StdEncoding.DecodeString(encoded)
+	}
+	return string(decoded), err
+}
+
+func keyFingerprint(encoded string) string {
+	public, err := decodeSSHKey(encoded)
+	if err != nil {
+		return ""
+	}
+	key, err := ParseSSHPublicKey(public)
+	if err != nil {
+		return ""
+	}
+	return key.Fingerprint()
+}
+
+// keyCreatedAt returns when the ssh keys of a probe were set, keys set
+// before their age was tracked date from the probe registration
+func keyCreatedAt(probe *Probe) time.Time {
+	if probe.SSHKeyCreatedAt.IsZero() {
+		return probe.CreatedAt
+	}
+	return probe.SSHKeyCreatedAt
+}
+
+// GetKeyRotation returns the keypair staged for a probe
+func GetKeyRotation(ProbeID string) (*KeyRotation, error) {
+	rotation := KeyRotation{ProbeID: ProbeID}
+	if err := o.Read(&rotation, "ProbeID"); err != nil {
+		if err == orm.ErrNoRows {
+			return nil, fmt.Errorf("no ssh key rotation staged for probe %s", ProbeID)
+		}
+		return nil, err
+	}
+	return &rotation, nil
+}
+
+// StageKey stages a new keypair for a probe, base64 encoded as UploadSSH
+// takes them, or generates an ed25519 one when none is given
+func StageKey(ProbeID string, SSHPrivateKey string, SSHPublicKey string) (*KeyRotation, error) {
+	log.Infof("[models.rotation.StageKey]: staging a new ssh key for probe %s", ProbeID)
+	probe, err := GetByID(ProbeID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := GetKeyRotation(ProbeID); err == nil {
+		return nil, fmt.Errorf("an ssh key rotation is already staged for probe %s", ProbeID)
+	}
+	if SSHPrivateKey == "" && SSHPublicKey == "" {
+		private, public, err := GenerateSSHKey(probe.FQDN)
+		if err != nil {
+			return nil, err
+		}
+		SSHPrivateKey = base64.URLEncoding.EncodeToString([]byte(private))
+		SSHPublicKey = base64.URLEncoding.EncodeToString([]byte(public))
+	} else if !(govalidator.IsBase64(SSHPrivateKey) && govalidator.IsBase64(SSHPublicKey)) {
+		return nil, fmt.Errorf("Malformed base64 in SSH Keys")
+	}
+	fingerprint := keyFingerprint(SSHPublicKey)
+	if fingerprint == "" {
+		return nil, fmt.Errorf("staged ssh public key is not in authorized_keys format")
+	}
+	now := time.Now()
+	rotation := &KeyRotation{
+		ProbeID:     ProbeID,
+		PrivateKey:  SSHPrivateKey,
+		PublicKey:   SSHPublicKey,
+		Fingerprint: fingerprint,
+		StagedAt:    now,
+		GraceUntil:  now.Add(keyGracePeriod),
+	}
+	if _, err := o.Insert(rotation); err != nil {
+		return nil, err
+	}
+	notifyDetail(EventKeyStaged, probe, fmt.Sprintf("ssh key %s staged, promotable from %s", fingerprint, rotation.GraceUntil.UTC().Format(time.RFC3339)))
+	return rotation, nil
+}
+
+// PromoteKey replaces the ssh keys of a probe with the staged ones and
+// retires the former keys. Promoting during the grace period needs force.
+func PromoteKey(ProbeID string, force bool) (*Probe, error) {
+	log.Infof("[models.rotation.PromoteKey]: promoting the staged ssh key of probe %s", ProbeID)
+	probe, err := GetByID(ProbeID)
+	if err != nil {
+		return nil, err
+	}
+	rotation, err := GetKeyRotation(ProbeID)
+	if err != nil {
+		return nil, err
+	}
+	if !force && time.Now().Before(rotation.GraceUntil) {
+		return nil, fmt.Errorf("the staged ssh key of probe %s is in its grace period until %s", ProbeID, rotation.GraceUntil.UTC().Format(time.RFC3339))
+	}
+	retired := keyFingerprint(probe.SSHPublicKey)
+	probe.SSHPrivateKey = rotation.PrivateKey
+	probe.SSHPublicKey = rotation.PublicKey
+	probe.SSHKeyCreatedAt = time.Now()
+	probe.UpdatedAt = probe.SSHKeyCreatedAt
+
+	tx := orm.NewOrm()
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Update(probe, "SSHPrivateKey", "SSHPublicKey", "SSHKeyCreatedAt", "UpdatedAt"); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if _, err := tx.Delete(rotation); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	detail := fmt.Sprintf("ssh key %s promoted", rotation.Fingerprint)
+	if retired != "" {
+		detail = fmt.Sprintf("ssh key %s retired, %s promoted", retired, rotation.Fingerprint)
+	}
+	notifyDetail(EventRekeyed, probe, detail)
+	return probe, nil
+}
+
+// CancelKeyRotation discards the keypair staged for a probe
+func CancelKeyRotation(ProbeID string) error {
+	log.Infof("[models.rotation.CancelKeyRotation]: discarding the staged ssh key of probe %s", ProbeID)
+	rotation, err := GetKeyRotation(ProbeID)
+	if err != nil {
+		return err
+	}
+	_, err = o.Delete(rotation)
+	return err
+}
+
+func deleteKeyRotation(ProbeID string) error {
+	_, err := o.QueryTable("key_rotation").Filter("probe_id", ProbeID).Delete()
+	return err
+}
+
+// GetStaleKeys returns the enabled probes whose ssh keys are older than
+// maxAge, oldest first
+func GetStaleKeys(maxAge time.Duration) ([]*StaleKey, error) {
+	var probes []*Probe
+	if _, err := o.QueryTable("probe").Filter("Enabled", true).Exclude("SSHPublicKey", "").Limit(-1).All(&probes); err != nil {
+		return nil, err
+	}
+	var rotations []*KeyRotation
+	if _, err := o.QueryTable("key_rotation").Limit(-1).All(&rotations); err != nil {
+		return nil, err
+	}
+	staged := make(map[string]bool)
+	for _, rotation := range rotations {
+		staged[rotation.ProbeID] = true
+	}
+	now := time.Now()
+	stale := []*StaleKey{}
+	for _, probe := range probes {
+		createdAt := keyCreatedAt(probe)
+		age := now.Sub(createdAt)
+		if age <= maxAge {
+			continue
+		}
+		stale = append(stale, &StaleKey{
+			ProbeID:      probe.ProbeID,
+			FQDN:         probe.FQDN,
+			KeyCreatedAt: createdAt,
+			AgeDays:      int(age.Hours() / 24),
+			Staged:       staged[probe.ProbeID],
+		})
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].KeyCreatedAt.Before(stale[j].KeyCreatedAt)
+	})
+	return stale, nil
+}
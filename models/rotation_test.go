@@ -0,0 +1,147 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestKeyRotationFlow checks a staged keypair is handed out along with the
+// current one, and only replaces it once promoted after its grace period
+func TestKeyRotationFlow(t *testing.T) {
+	defer ConfigureKeyRotation(keyGracePeriod, keyMaxAge)
+	ConfigureKeyRotation(time.Hour, keyMaxAge)
+	probe := addTestProbe(t, "rotated.example.com", "192.0.2.70", "AWS")
+	defer Delete(probe.ProbeID)
+
+	first, err := StageKey(probe.ProbeID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(first.Fingerprint, "SHA256:") || first.GraceUntil.Sub(first.StagedAt) != time.Hour {
+		t.Errorf("unexpected staged key %+v", first)
+	}
+	if _, err := StageKey(probe.ProbeID, "", ""); err == nil {
+		t.Error("a second rotation was staged")
+	}
+	if _, err := PromoteKey(probe.ProbeID, false); err == nil || !strings.Contains(err.Error(), "grace period") {
+		t.Errorf("staged key promoted during its grace period: %v", err)
+	}
+	promoted, err := PromoteKey(probe.ProbeID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if promoted.SSHPublicKey != first.PublicKey || promoted.SSHPrivateKey != first.PrivateKey || promoted.SSHKeyCreatedAt.IsZero() {
+		t.Errorf("forced promotion did not replace the keys: %+v", promoted)
+	}
+	if _, err := GetKeyRotation(probe.ProbeID); err == nil {
+		t.Error("promoted rotation still staged")
+	}
+
+	ConfigureKeyRotation(0, keyMaxAge)
+	second, err := StageKey(probe.ProbeID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := GetSSH(probe.ProbeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, _ := decodeSSHKey(first.PublicKey)
+	staged, _ := decodeSSHKey(second.PublicKey)
+	if keys.Public != current || keys.StagedPublic != staged || keys.StagedPrivate == "" {
+		t.Errorf("both keypairs not handed out during the rotation: %+v", keys)
+	}
+	if _, err := PromoteKey(probe.ProbeID, false); err != nil {
+		t.Fatalf("staged key not promoted after its grace period: %v", err)
+	}
+	if keys, _ := GetSSH(probe.ProbeID); keys.Public != staged || keys.StagedPublic != "" {
+		t.Errorf("former keys still handed out: %+v", keys)
+	}
+
+	third, err := StageKey(probe.ProbeID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CancelKeyRotation(probe.ProbeID); err != nil {
+		t.Fatal(err)
+	}
+	if probe, _ := GetByID(probe.ProbeID); probe.SSHPublicKey == third.PublicKey {
+		t.Error("cancelled rotation replaced the keys")
+	}
+}
+
+// TestStageKeyRejectsMalformedKeys checks only base64 encoded keys in
+// authorized_keys format are staged
+func TestStageKeyRejectsMalformedKeys(t *testing.T) {
+	probe := addTestProbe(t, "malformed.example.com", "192.0.2.71", "AWS")
+	defer Delete(probe.ProbeID)
+	if _, err := StageKey(probe.ProbeID, "not base64!", "not base64!"); err == nil {
+		t.Error("malformed base64 staged")
+	}
+	if _, err := StageKey(probe.ProbeID, "cHJpdmF0ZQ==", "cHVibGlj"); err == nil {
+		t.Error("public key not in authorized_keys format staged")
+	}
+	if _, err := StageKey("no-such-probe", "", ""); err == nil {
+		t.Error("key staged for a missing probe")
+	}
+}
+
+// TestGetStaleKeys checks only the enabled probes with keys older than the
+// maximum age are reported, oldest first, flagging the staged ones
+func TestGetStaleKeys(t *testing.T) {
+	ages := map[string]time.Duration{
+		"oldest.example.com":  200 * 24 * time.Hour,
+		"old.example.com":     100 * 24 * time.Hour,
+		"fresh.example.com":   time.Hour,
+		"off.example.com":     300 * 24 * time.Hour,
+		"keyless.example.com": 300 * 24 * time.Hour,
+	}
+	ids := make(map[string]string)
+	for i, fqdn := range []string{"oldest.example.com", "old.example.com", "fresh.example.com", "off.example.com", "keyless.example.com"} {
+		probe := addTestProbe(t, fqdn, fmt.Sprintf("192.0.2.%d", 80+i), "AWS")
+		defer Delete(probe.ProbeID)
+		ids[probe.ProbeID] = fqdn
+		if fqdn != "keyless.example.com" {
+			if _, err := StageKey(probe.ProbeID, "", ""); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := PromoteKey(probe.ProbeID, true); err != nil {
+				t.Fatal(err)
+			}
+			probe, _ = GetByID(probe.ProbeID)
+		}
+		probe.SSHKeyCreatedAt = time.Now().Add(-ages[fqdn])
+		if _, err := o.Update(probe, "SSHKeyCreatedAt"); err != nil {
+			t.Fatal(err)
+		}
+		switch fqdn {
+		case "old.example.com":
+			if _, err := StageKey(probe.ProbeID, "", ""); err != nil {
+				t.Fatal(err)
+			}
+		case "off.example.com":
+			if _, err := Disable(probe.ProbeID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	stale, err := GetStaleKeys(90 * 24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reported []*StaleKey
+	for _, key := range stale {
+		if _, ok := ids[key.ProbeID]; ok {
+			reported = append(reported, key)
+		}
+	}
+	if len(reported) != 2 || reported[0].FQDN != "oldest.example.com" || reported[1].FQDN != "old.example.com" {
+		t.Fatalf("unexpected stale keys %+v", reported)
+	}
+	if reported[0].AgeDays != 200 || reported[0].Staged || !reported[1].Staged {
+		t.Errorf("unexpected age or staging %+v %+v", reported[0], reported[1])
+	}
+}
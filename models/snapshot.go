@@ -0,0 +1,438 @@
+package models
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego/orm"
+)
+
+// Snapshots are gzipped tarballs holding a manifest, a JSON file per table
+// and the ssh private keys, webhook secrets and user and enrollment token
+// hashes encrypted with a passphrase
+const (
+	snapshotFormat = "sinker-registry-snapshot"
+	// SnapshotVersion is the snapshot format version written, restore
+	// refuses any other
+	SnapshotVersion = 1
+
+	snapshotKDF           = "pbkdf2-sha256"
+	snapshotKDFIterations = 600000
+	minPassphraseLength   = 12
+)
+
+// SnapshotManifest describes a snapshot
+type SnapshotManifest struct {
+	Format     string         `json:"format"`
+	Version    int            `json:"version"`
+	CreatedAt  time.Time      `json:"created_at"`
+	Counts     map[string]int `json:"counts"`
+	KDF        string         `json:"kdf"`
+	Iterations int            `json:"iterations"`
+	Salt       []byte         `json:"salt"`
+}
+
+type snapshot struct {
+	Providers   []*Provider
+	Probes      []*Probe
+	Webhooks    []*Webhook
+	Deliveries  []*WebhookDelivery
+	Changes     []*ProbeChange
+	HostKeys    []*ProbeHostKey
+	Rotations   []*KeyRotation
+	Certs       []*Certificate
+	Teams       []*Team
+	Users       []*User
+	ProbeCerts  []*ProbeCertificate
+	Groups      []*ProbeGroup
+	Members     []*ProbeGroupMember
+	Enrollments []*EnrollmentToken
+}
+
+// snapshotSecrets are stored encrypted, apart from the records they
+// belong to
+type snapshotSecrets struct {
+	Probes      map[string]string `json:"probes"`
+	Webhooks    map[int64]string  `json:"webhooks"`
+	Rotations   map[string]string `json:"rotations,omitempty"`
+	Users       map[int64]string  `json:"users,omitempty"`
+	Enrollments map[int64]string  `json:"enrollments,omitempty"`
+}
+
+type snapshotTable struct {
+	name    string
+	file    string
+	key     string
+	records interface{}
+}
+
+// tables of a snapshot, in restore order, records point to the slices of
+// snap
+func (snap *snapshot) tables() []snapshotTable {
+	return []snapshotTable{
+		{"provider", "providers.json", "Slug", &snap.Providers},
+		{"probe", "probes.json", "ProbeID", &snap.Probes},
+		{"webhook", "webhooks.json", "ID", &snap.Webhooks},
+		{"webhook_delivery", "webhook_deliveries.json", "ID", &snap.Deliveries},
+		{"probe_change", "changes.json", "ID", &snap.Changes},
+		{"probe_host_key", "host_keys.json", "ID", &snap.HostKeys},
+		{"key_rotation", "key_rotations.json", "ID", &snap.Rotations},
+		{"certificate", "certificates.json", "ID", &snap.Certs},
+		{"team", "teams.json", "ID", &snap.Teams},
+		{"user", "users.json", "ID", &snap.Users},
+		{"probe_certificate", "probe_certificates.json", "ID", &snap.ProbeCerts},
+		{"probe_group", "groups.json", "ID", &snap.Groups},
+		{"probe_group_member", "group_members.json", "ID", &snap.Members},
+		{"enrollment_token", "enrollment_tokens.json", "ID", &snap.Enrollments},
+	}
+}
+
+func (table snapshotTable) len() int {
+	return reflect.ValueOf(table.records).Elem().Len()
+}
+
+func (table snapshotTable) insertAll(tx orm.Ormer) error {
+	records := reflect.ValueOf(table.records).Elem()
+	for i := 0; i < records.Len(); i++ {
+		if _, err := tx.Insert(records.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// empty removes every record of the table, a few at a time to keep the
+// queries short
+func (table snapshotTable) empty(tx orm.Ormer) error {
+	for {
+		var keys orm.ParamsList
+		if _, err := tx.QueryTable(table.name).Limit(idsPerQuery).ValuesFlat(&keys, table.key); err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		if _, err := tx.QueryTable(table.name).Filter(table.key+"__in", keys...).Delete(); err != nil {
+			return err
+		}
+	}
+}
+
+func snapshotKey(passphrase string, salt []byte, iterations int) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+}
+
+func sealSecrets(key []byte, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openSecrets(key []byte, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("truncated secrets")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// readSnapshot reads every table within a single transaction so that the
+// snapshot is consistent
+func readSnapshot() (*snapshot, error) {
+	tx := orm.NewOrm()
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	snap := &snapshot{}
+	for _, table := range snap.tables() {
+		if _, err := tx.QueryTable(table.name).OrderBy(table.key).Limit(-1).All(table.records); err != nil {
+			return nil, fmt.Errorf("unable to read %s: %s", table.name, err)
+		}
+	}
+	return snap, nil
+}
+
+// ExportSnapshot writes a snapshot of the whole registry to w, the ssh
+// private keys and webhook secrets are encrypted with passphrase
+func ExportSnapshot(w io.Writer, passphrase string) (*SnapshotManifest, error) {
+	if len(passphrase) < minPassphraseLength {
+		return nil, fmt.Errorf("passphrase too short, %d characters at least", minPassphraseLength)
+	}
+	snap, err := readSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	secrets := snapshotSecrets{
+		Probes:      make(map[string]string),
+		Webhooks:    make(map[int64]string),
+		Rotations:   make(map[string]string),
+		Users:       make(map[int64]string),
+		Enrollments: make(map[int64]string),
+	}
+	for _, provider := range snap.Providers {
+		if err := provider.unpack(); err != nil {
+			return nil, err
+		}
+	}
+	for _, probe := range snap.Probes {
+		if probe.SSHPrivateKey != "" {
+			secrets.Probes[probe.ProbeID] = probe.SSHPrivateKey
+			probe.SSHPrivateKey = ""
+		}
+	}
+	for _, rotation := range snap.Rotations {
+		secrets.Rotations[rotation.ProbeID] = rotation.PrivateKey
+		rotation.PrivateKey = ""
+	}
+	for _, team := range snap.Teams {
+		team.unpack()
+	}
+	for _, user := range snap.Users {
+		secrets.Users[user.ID] = user.TokenHash
+	}
+	for _, token := range snap.Enrollments {
+		secrets.Enrollments[token.ID] = token.TokenHash
+	}
+	for _, group := range snap.Groups {
+		if err := group.unpack(); err != nil {
+			return nil, err
+		}
+	}
+	for _, webhook := range snap.Webhooks {
+		webhook.unpack()
+		secrets.Webhooks[webhook.ID] = webhook.Secret
+		webhook.Secret = ""
+	}
+
+	manifest := &SnapshotManifest{
+		Format:     snapshotFormat,
+		Version:    SnapshotVersion,
+		CreatedAt:  time.Now().UTC(),
+		Counts:     make(map[string]int),
+		KDF:        snapshotKDF,
+		Iterations: snapshotKDFIterations,
+		Salt:       make([]byte, 16),
+	}
+	if _, err := rand.Read(manifest.Salt); err != nil {
+		return nil, err
+	}
+	key, err := snapshotKey(passphrase, manifest.Salt, manifest.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealSecrets(key, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte)
+	for _, table := range snap.tables() {
+		content, err := json.MarshalIndent(table.records, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		files[table.file] = content
+		manifest.Counts[table.name] = table.len()
+	}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	entries := []string{"manifest.json"}
+	files["manifest.json"] = content
+	for _, table := range snap.tables() {
+		entries = append(entries, table.file)
+	}
+	entries = append(entries, "secrets.enc")
+	files["secrets.enc"] = sealed
+	for _, name := range entries {
+		header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(files[name])), ModTime: manifest.CreatedAt}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	log.Infof("[models.snapshot.ExportSnapshot]: exported %v", manifest.Counts)
+	return manifest, nil
+}
+
+func readArchive(r io.Reader) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a registry snapshot: %s", err)
+	}
+	tr := tar.NewReader(gz)
+	files := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("corrupted snapshot: %s", err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("corrupted snapshot: %s", err)
+		}
+		files[header.Name] = content
+	}
+}
+
+// readSnapshotArchive validates the format and version of a snapshot and
+// returns its manifest along with the files of the archive
+func readSnapshotArchive(r io.Reader) (*SnapshotManifest, map[string][]byte, error) {
+	files, err := readArchive(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var manifest SnapshotManifest
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil || manifest.Format != snapshotFormat {
+		return nil, nil, errors.New("not a registry snapshot, manifest.json is missing or invalid")
+	}
+	if manifest.Version != SnapshotVersion {
+		return nil, nil, fmt.Errorf("unsupported snapshot version %d, this registry reads version %d", manifest.Version, SnapshotVersion)
+	}
+	if manifest.KDF != snapshotKDF {
+		return nil, nil, fmt.Errorf("unsupported key derivation %s", manifest.KDF)
+	}
+	return &manifest, files, nil
+}
+
+// RestoreSnapshot replaces the whole content of the registry database with
+// the snapshot read from r, in a single transaction
+func RestoreSnapshot(r io.Reader, passphrase string) (*SnapshotManifest, error) {
+	manifest, files, err := readSnapshotArchive(r)
+	if err != nil {
+		return nil, err
+	}
+	key, err := snapshotKey(passphrase, manifest.Salt, manifest.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := openSecrets(key, files["secrets.enc"])
+	if err != nil {
+		return nil, errors.New("unable to decrypt the snapshot secrets, wrong passphrase or corrupted snapshot")
+	}
+	var secrets snapshotSecrets
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("corrupted snapshot secrets: %s", err)
+	}
+
+	snap := &snapshot{}
+	for _, table := range snap.tables() {
+		content, ok := files[table.file]
+		if !ok {
+			return nil, fmt.Errorf("corrupted snapshot, %s is missing", table.file)
+		}
+		if err := json.Unmarshal(content, table.records); err != nil {
+			return nil, fmt.Errorf("corrupted snapshot, invalid %s: %s", table.file, err)
+		}
+	}
+	for _, table := range snap.tables() {
+		if manifest.Counts[table.name] != table.len() {
+			return nil, fmt.Errorf("corrupted snapshot, expected %d %s records and found %d", manifest.Counts[table.name], table.name, table.len())
+		}
+	}
+	for _, provider := range snap.Providers {
+		if err := provider.pack(); err != nil {
+			return nil, err
+		}
+	}
+	for _, probe := range snap.Probes {
+		probe.SSHPrivateKey = secrets.Probes[probe.ProbeID]
+	}
+	for _, rotation := range snap.Rotations {
+		rotation.PrivateKey = secrets.Rotations[rotation.ProbeID]
+	}
+	for _, team := range snap.Teams {
+		// providers were checked when the team was saved, packing again would
+		// look them up in the database being replaced
+		team.Scope = strings.Join(team.Providers, ",")
+	}
+	for _, user := range snap.Users {
+		user.TokenHash = secrets.Users[user.ID]
+	}
+	for _, token := range snap.Enrollments {
+		token.TokenHash = secrets.Enrollments[token.ID]
+	}
+	for _, group := range snap.Groups {
+		if err := group.pack(); err != nil {
+			return nil, err
+		}
+	}
+	for _, webhook := range snap.Webhooks {
+		webhook.Secret = secrets.Webhooks[webhook.ID]
+		webhook.pack()
+	}
+
+	tx := orm.NewOrm()
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	tables := snap.tables()
+	for i := len(tables) - 1; i >= 0; i-- {
+		if err := tables[i].empty(tx); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("unable to empty %s: %s", tables[i].name, err)
+		}
+	}
+	for _, table := range tables {
+		if err := table.insertAll(tx); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("unable to restore %s: %s", table.name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	log.Infof("[models.snapshot.RestoreSnapshot]: restored %v from a snapshot of %s", manifest.Counts, manifest.CreatedAt)
+	return manifest, nil
+}
@@ -0,0 +1,220 @@
+package models
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// sshCertUser is the certificate type of user certificates
+const sshCertUser = 1
+
+// certClockSkew backdates certificates so that probes with a slightly late
+// clock accept them right away
+const certClockSkew = time.Minute
+
+// Certificate records a user certificate issued by the registry CA,
+// Principals are the FQDNs of the probes it grants access to
+type Certificate struct {
+	ID          int64     `orm:"pk;auto;column(id)" json:"id"`
+	Serial      int64     `json:"serial"`
+	KeyID       string    `orm:"size(255);column(key_id)" json:"key_id"`
+	Requester   string    `orm:"size(255)" json:"requester"`
+	RemoteAddr  string    `orm:"size(64)" json:"remote_addr"`
+	ProbeIDs    string    `orm:"type(text);column(probe_ids)" json:"probe_ids"`
+	Principals  string    `orm:"type(text)" json:"principals"`
+	Fingerprint string    `orm:"size(64)" json:"fingerprint"`
+	ValidAfter  time.Time `json:"valid_after"`
+	ValidBefore time.Time `json:"valid_before"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// CertificateRequest asks the registry CA for a certificate of PublicKey
+// granting access to the probes of ProbeIDs for TTL minutes
+type CertificateRequest struct {
+	PublicKey  string   `json:"publickey"`
+	ProbeIDs   []string `json:"probes"`
+	TTL        int      `json:"ttl"`
+	Requester  string   `json:"requester"`
+	RemoteAddr string   `json:"-"`
+}
+
+// SignedCertificate is a certificate in the authorized_keys format, ready
+// to be saved next to its private key as id-cert.pub
+type SignedCertificate struct {
+	Certificate string       `json:"certificate"`
+	Record      *Certificate `json:"record"`
+}
+
+var (
+	caKey        ed25519.PrivateKey
+	certTTL      = 5 * time.Minute
+	certMaxTTL   = time.Hour
+	certPermits  = []string{"permit-port-forwarding", "permit-pty"}
+	certKeyTypes = map[string]string{
+		"ssh-ed25519":         "ssh-ed25519-cert-v01@openssh.com",
+		"ssh-rsa":             "ssh-rsa-cert-v01@openssh.com",
+		"ecdsa-sha2-nistp256": "ecdsa-sha2-nistp256-cert-v01@openssh.com",
+		"ecdsa-sha2-nistp384": "ecdsa-sha2-nistp384-cert-v01@openssh.com",
+		"ecdsa-sha2-nistp521": "ecdsa-sha2-nistp521-cert-v01@openssh.com",
+	}
+)
+
+// ConfigureSSHCA loads the ed25519 CA key the registry signs certificates
+// with from path, generating it when missing. Certificates last ttl unless
+// requested otherwise, and maxTTL at most.
+func ConfigureSSHCA(path string, ttl time.Duration, maxTTL time.Duration) error {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Infof("[models.sshca.ConfigureSSHCA]: generating the ssh CA key %s", path)
+		private, _, err := GenerateSSHKey("sinker-registry-ca")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(private), 0600); err != nil {
+			return err
+		}
+		content = []byte(private)
+	} else if err != nil {
+		return err
+	}
+	key, err := parseED25519PrivateKey(content)
+	if err != nil {
+		return fmt.Errorf("invalid ssh CA key %s: %s", path, err)
+	}
+	caKey = key
+	certTTL = ttl
+	certMaxTTL = maxTTL
+	return nil
+}
+
+// SSHCAConfigured reports whether the registry issues certificates
+func SSHCAConfigured() bool {
+	return caKey != nil
+}
+
+// CAPublicKey returns the CA public key in the format TrustedUserCAKeys
+// reads
+func CAPublicKey() (string, error) {
+	if !SSHCAConfigured() {
+		return "", errors.New("the ssh certificate authority is not configured")
+	}
+	key := SSHPublicKey{Type: "ssh-ed25519", Blob: ed25519PublicBlob(caKey.Public().(ed25519.PublicKey))}
+	return key.String() + " sinker-registry-ca", nil
+}
+
+// signCertificate builds a user certificate of key and signs it with the CA
+func signCertificate(key *SSHPublicKey, record *Certificate, principals []string) []byte {
+	var list, extensions bytes.Buffer
+	for _, principal := range principals {
+		list.Write(sshString([]byte(principal)))
+	}
+	for _, permit := range certPermits {
+		extensions.Write(sshString([]byte(permit)))
+		extensions.Write(sshString(nil))
+	}
+	nonce := make([]byte, 32)
+	rand.Read(nonce)
+	uint64Bytes := func(v uint64) []byte {
+		out := make([]byte, 8)
+		binary.BigEndian.PutUint64(out, v)
+		return out
+	}
+
+	var cert bytes.Buffer
+	cert.Write(sshString([]byte(certKeyTypes[key.Type])))
+	cert.Write(sshString(nonce))
+	// the public key fields follow its type name in the key blob
+	cert.Write(key.Blob[4+len(key.Type):])
+	cert.Write(uint64Bytes(uint64(record.Serial)))
+	cert.Write(sshUint32(sshCertUser))
+	cert.Write(sshString([]byte(record.KeyID)))
+	cert.Write(sshString(list.Bytes()))
+	cert.Write(uint64Bytes(uint64(record.ValidAfter.Unix())))
+	cert.Write(uint64Bytes(uint64(record.ValidBefore.Unix())))
+	cert.Write(sshString(nil))
+	cert.Write(sshString(extensions.Bytes()))
+	cert.Write(sshString(nil))
+	cert.Write(sshString(ed25519PublicBlob(caKey.Public().(ed25519.PublicKey))))
+	signature := ed25519.Sign(caKey, cert.Bytes())
+	cert.Write(sshString(append(sshString([]byte("ssh-ed25519")), sshString(signature)...)))
+	return cert.Bytes()
+}
+
+// IssueCertificate signs a short lived user certificate for the public key
+// of request, valid on the requested enabled probes only, and logs it
+func IssueCertificate(request CertificateRequest) (*SignedCertificate, error) {
+	if !SSHCAConfigured() {
+		return nil, errors.New("the ssh certificate authority is not configured")
+	}
+	if request.Requester == "" {
+		return nil, errors.New("requester is missing")
+	}
+	key, err := ParseSSHPublicKey(request.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(request.ProbeIDs) == 0 {
+		return nil, errors.New("no probes requested")
+	}
+	var principals []string
+	for _, ProbeID := range request.ProbeIDs {
+		probe, err := GetByID(ProbeID)
+		if err != nil {
+			return nil, fmt.Errorf("probe %s not found", ProbeID)
+		}
+		if !probe.Enabled {
+			return nil, fmt.Errorf("probe %s is disabled", ProbeID)
+		}
+		principals = append(principals, probe.FQDN)
+	}
+	ttl := certTTL
+	if request.TTL > 0 {
+		ttl = time.Duration(request.TTL) * time.Minute
+	}
+	if ttl > certMaxTTL {
+		return nil, fmt.Errorf("certificates last %s at most", certMaxTTL)
+	}
+
+	serial := make([]byte, 8)
+	if _, err := rand.Read(serial); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	record := &Certificate{
+		Serial:      int64(binary.BigEndian.Uint64(serial) >> 1),
+		Requester:   request.Requester,
+		RemoteAddr:  request.RemoteAddr,
+		ProbeIDs:    strings.Join(request.ProbeIDs, ","),
+		Principals:  strings.Join(principals, ","),
+		Fingerprint: key.Fingerprint(),
+		ValidAfter:  now.Add(-certClockSkew),
+		ValidBefore: now.Add(ttl),
+		CreatedAt:   now,
+	}
+	record.KeyID = fmt.Sprintf("%s-%d", request.Requester, record.Serial)
+	cert := signCertificate(key, record, principals)
+	if _, err := o.Insert(record); err != nil {
+		return nil, err
+	}
+	log.Infof("[models.sshca.IssueCertificate]: issued certificate %d to %s for %s until %s", record.Serial, record.Requester, record.Principals, record.ValidBefore.Format(time.RFC3339))
+	line := certKeyTypes[key.Type] + " " + base64.StdEncoding.EncodeToString(cert) + " " + record.KeyID
+	return &SignedCertificate{Certificate: line, Record: record}, nil
+}
+
+// GetCertificates returns the latest limit certificates issued, newest first
+func GetCertificates(limit int) ([]*Certificate, error) {
+	var certs []*Certificate
+	_, err := o.QueryTable("certificate").OrderBy("-id").Limit(limit).All(&certs)
+	return certs, err
+}
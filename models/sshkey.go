@@ -0,0 +1,193 @@
+package models
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var hostKeyTypes = map[string]bool{
+	"ssh-ed25519":         true,
+	"ssh-rsa":             true,
+	"ecdsa-sha2-nistp256": true,
+	"ecdsa-sha2-nistp384": true,
+	"ecdsa-sha2-nistp521": true,
+}
+
+// SSHPublicKey is a public key in the OpenSSH authorized_keys format
+type SSHPublicKey struct {
+	Type    string
+	Blob    []byte
+	Comment string
+}
+
+// ParseSSHPublicKey parses a "type base64 [comment]" line and checks the key
+// blob is consistent with its type
+func ParseSSHPublicKey(line string) (*SSHPublicKey, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("malformed ssh public key `%s`", line)
+	}
+	if !hostKeyTypes[fields[0]] {
+		return nil, fmt.Errorf("unsupported ssh key type `%s`", fields[0])
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed base64 in ssh public key: %s", err)
+	}
+	if len(blob) < 4 {
+		return nil, fmt.Errorf("truncated ssh public key")
+	}
+	size := binary.BigEndian.Uint32(blob)
+	if uint64(len(blob)) < 4+uint64(size) || string(blob[4:4+size]) != fields[0] {
+		return nil, fmt.Errorf("ssh public key blob does not match its type %s", fields[0])
+	}
+	return &SSHPublicKey{Type: fields[0], Blob: blob, Comment: strings.Join(fields[2:], " ")}, nil
+}
+
+// String returns the key without its comment, as used in known_hosts
+func (key *SSHPublicKey) String() string {
+	return key.Type + " " + base64.StdEncoding.EncodeToString(key.Blob)
+}
+
+// sshString encodes b as an SSH wire protocol string
+func sshString(b []byte) []byte {
+	out := make([]byte, 4, 4+len(b))
+	binary.BigEndian.PutUint32(out, uint32(len(b)))
+	return append(out, b...)
+}
+
+func sshUint32(v uint32) []byte {
+	out := make([]byte, 4)
+	binary.BigEndian.PutUint32(out, v)
+	return out
+}
+
+func ed25519PublicBlob(public ed25519.PublicKey) []byte {
+	return append(sshString([]byte("ssh-ed25519")), sshString(public)...)
+}
+
+// sshReader decodes SSH wire protocol values, the first error sticks
+type sshReader struct {
+	buf []byte
+	err error
+}
+
+func (r *sshReader) uint32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.buf) < 4 {
+		r.err = errors.New("truncated ssh key")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v
+}
+
+func (r *sshReader) string() []byte {
+	size := r.uint32()
+	if r.err != nil {
+		return nil
+	}
+	if uint64(len(r.buf)) < uint64(size) {
+		r.err = errors.New("truncated ssh key")
+		return nil
+	}
+	v := r.buf[:size]
+	r.buf = r.buf[size:]
+	return v
+}
+
+// marshalED25519PrivateKey encodes an unencrypted private key in the
+// OpenSSH format read by ssh and ssh-keygen
+func marshalED25519PrivateKey(private ed25519.PrivateKey, comment string) ([]byte, error) {
+	check := make([]byte, 4)
+	if _, err := rand.Read(check); err != nil {
+		return nil, err
+	}
+	public := private.Public().(ed25519.PublicKey)
+	var secret bytes.Buffer
+	secret.Write(check)
+	secret.Write(check)
+	secret.Write(sshString([]byte("ssh-ed25519")))
+	secret.Write(sshString(public))
+	secret.Write(sshString(private))
+	secret.Write(sshString([]byte(comment)))
+	for i := byte(1); secret.Len()%8 != 0; i++ {
+		secret.WriteByte(i)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("openssh-key-v1\x00")
+	buf.Write(sshString([]byte("none")))
+	buf.Write(sshString([]byte("none")))
+	buf.Write(sshString(nil))
+	buf.Write(sshUint32(1))
+	buf.Write(sshString(ed25519PublicBlob(public)))
+	buf.Write(sshString(secret.Bytes()))
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: buf.Bytes()}), nil
+}
+
+// parseED25519PrivateKey decodes an unencrypted ed25519 private key in the
+// OpenSSH format, as ssh-keygen -t ed25519 writes it without passphrase
+func parseED25519PrivateKey(pemBytes []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil || block.Type != "OPENSSH PRIVATE KEY" {
+		return nil, errors.New("not an OpenSSH private key")
+	}
+	magic := "openssh-key-v1\x00"
+	if !bytes.HasPrefix(block.Bytes, []byte(magic)) {
+		return nil, errors.New("not an OpenSSH private key")
+	}
+	r := &sshReader{buf: block.Bytes[len(magic):]}
+	cipherName, kdfName := string(r.string()), string(r.string())
+	r.string()
+	count := r.uint32()
+	r.string()
+	secret := &sshReader{buf: r.string()}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if cipherName != "none" || kdfName != "none" {
+		return nil, errors.New("encrypted ssh private keys are not supported")
+	}
+	if count != 1 {
+		return nil, fmt.Errorf("expected a single ssh private key, found %d", count)
+	}
+	if secret.uint32() != secret.uint32() {
+		return nil, errors.New("corrupted ssh private key")
+	}
+	keyType := string(secret.string())
+	secret.string()
+	private := secret.string()
+	if secret.err != nil {
+		return nil, secret.err
+	}
+	if keyType != "ssh-ed25519" || len(private) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("unsupported ssh private key type %s, ed25519 expected", keyType)
+	}
+	return ed25519.PrivateKey(private), nil
+}
+
+// GenerateSSHKey returns a new ed25519 keypair, the private key in the
+// OpenSSH format and the public one in the authorized_keys format
+func GenerateSSHKey(comment string) (private string, public string, err error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	pemKey, err := marshalED25519PrivateKey(privateKey, comment)
+	if err != nil {
+		return "", "", err
+	}
+	key := SSHPublicKey{Type: "ssh-ed25519", Blob: ed25519PublicBlob(publicKey), Comment: comment}
+	return string(pemKey), strings.TrimSpace(key.String() + " " + comment), nil
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego/orm"
+)
+
+// FleetStats summarizes the registry, every figure is computed by the
+// database with aggregate queries
+type FleetStats struct {
+	Total          int64         `json:"total"`
+	Enabled        int64         `json:"enabled"`
+	Disabled       int64         `json:"disabled"`
+	MissingSSHKeys int64         `json:"missing_ssh_keys"`
+	MissingGeo     int64         `json:"missing_geo"`
+	MonthlyCost    float64       `json:"monthly_cost"`
+	ByProvider     []StatsBucket `json:"by_provider"`
+	ByCountry      []StatsBucket `json:"by_country"`
+	ByRegion       []StatsBucket `json:"by_region"`
+	ByMonth        []StatsBucket `json:"by_month"`
+}
+
+// StatsBucket holds the figures of a group of probes
+type StatsBucket struct {
+	Key         string  `json:"key"`
+	Total       int64   `json:"total"`
+	Enabled     int64   `json:"enabled"`
+	Disabled    int64   `json:"disabled"`
+	MonthlyCost float64 `json:"monthly_cost"`
+}
+
+const (
+	enabledSum     = "SUM(CASE WHEN enabled THEN 1 ELSE 0 END)"
+	missingSSHSum  = "SUM(CASE WHEN s_s_h_private_key = '' OR s_s_h_public_key = '' THEN 1 ELSE 0 END)"
+	missingGeoSum  = "SUM(CASE WHEN geo_latitude IN ('', 'NaN') OR geo_longitude IN ('', 'NaN') THEN 1 ELSE 0 END)"
+	monthlyCostSum = "SUM(monthly_cost)"
+)
+
+// monthExpression formats created_at as YYYY-MM for the backend in use
+func monthExpression(driver orm.DriverType) string {
+	switch driver {
+	case orm.DRMySQL:
+		return "DATE_FORMAT(created_at, '%Y-%m')"
+	case orm.DRPostgres:
+		return "to_char(created_at, 'YYYY-MM')"
+	default:
+		return "strftime('%Y-%m', created_at)"
+	}
+}
+
+func GetStats(scope *Scope) (*FleetStats, error) {
+	var stats FleetStats
+	conditions, err := scopeConditions(scope)
+	if err != nil {
+		return nil, err
+	}
+	for _, condition := range conditions {
+		var rows []orm.ParamsList
+		query := fmt.Sprintf("SELECT COUNT(*), %s, %s, %s, %s FROM probe%s", enabledSum, missingSSHSum, missingGeoSum, monthlyCostSum, condition.where)
+		if _, err := o.Raw(query, condition.args...).ValuesList(&rows); err != nil || len(rows) != 1 {
+			log.Errorf("[models.stats.GetStats]: Error querying database %s", err)
+			return nil, fmt.Errorf("unable to compute fleet totals: %v", err)
+		}
+		stats.Total += toInt64(rows[0][0])
+		stats.Enabled += toInt64(rows[0][1])
+		stats.MissingSSHKeys += toInt64(rows[0][2])
+		stats.MissingGeo += toInt64(rows[0][3])
+		stats.MonthlyCost += toFloat64(rows[0][4])
+	}
+	stats.Disabled = stats.Total - stats.Enabled
+
+	if stats.ByProvider, err = groupStats("provider", conditions); err != nil {
+		return nil, err
+	}
+	if stats.ByCountry, err = groupStats("country", conditions); err != nil {
+		return nil, err
+	}
+	if stats.ByRegion, err = groupStats("region", conditions); err != nil {
+		return nil, err
+	}
+	if stats.ByMonth, err = groupStats(monthExpression(o.Driver().Type()), conditions); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// statsCondition restricts the stats queries to some of the probes
+type statsCondition struct {
+	where string
+	args  []interface{}
+}
+
+// scopeConditions restricts the stats queries to the probes of scope, nil
+// meaning all of them. Labels are not understood by the database, the
+// probes matching the label selector of the scope are listed by id, in
+// chunks short enough for a single query each. The figures of every
+// condition add up.
+func scopeConditions(scope *Scope) ([]statsCondition, error) {
+	if scope == nil || len(scope.Providers) == 0 && len(scope.Selector) == 0 {
+		return []statsCondition{{}}, nil
+	}
+	column := "provider"
+	values := scope.Providers
+	if len(scope.Selector) > 0 {
+		filter := ProbeFilter{State: StateAll, Scope: scope}
+		var probes []*Probe
+		if _, err := filter.apply(o.QueryTable("probe")).Limit(-1).All(&probes, "ProbeID", "Provider", "Labels"); err != nil {
+			return nil, err
+		}
+		probes = filter.selected(probes)
+		column = "probe_i_d"
+		values = make([]string, len(probes))
+		for i, probe := range probes {
+			values[i] = probe.ProbeID
+		}
+	}
+	if len(values) == 0 {
+		return []statsCondition{{where: " WHERE 1 = 0"}}, nil
+	}
+	var conditions []statsCondition
+	for _, chunk := range chunkIDs(values) {
+		args := make([]interface{}, len(chunk))
+		for i, value := range chunk {
+			args[i] = value
+		}
+		where := " WHERE " + column + " IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(chunk)), ", ") + ")"
+		conditions = append(conditions, statsCondition{where: where, args: args})
+	}
+	return conditions, nil
+}
+
+func groupStats(expression string, conditions []statsCondition) ([]StatsBucket, error) {
+	buckets := []StatsBucket{}
+	index := make(map[string]int)
+	for _, condition := range conditions {
+		var rows []orm.ParamsList
+		query := fmt.Sprintf("SELECT %s AS bucket, COUNT(*), %s, %s FROM probe%s GROUP BY bucket",
+			expression, enabledSum, monthlyCostSum, condition.where)
+		if _, err := o.Raw(query, condition.args...).ValuesList(&rows); err != nil {
+			log.Errorf("[models.stats.groupStats]: Error querying database %s", err)
+			return nil, err
+		}
+		for _, row := range rows {
+			key := toString(row[0])
+			i, ok := index[key]
+			if !ok {
+				i = len(buckets)
+				index[key] = i
+				buckets = append(buckets, StatsBucket{Key: key})
+			}
+			buckets[i].Total += toInt64(row[1])
+			buckets[i].Enabled += toInt64(row[2])
+			buckets[i].MonthlyCost += toFloat64(row[3])
+		}
+	}
+	for i := range buckets {
+		buckets[i].Disabled = buckets[i].Total - buckets[i].Enabled
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Key < buckets[j].Key
+	})
+	return buckets, nil
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func toInt64(value interface{}) int64 {
+	if v, ok := value.(int64); ok {
+		return v
+	}
+	n, _ := strconv.ParseFloat(toString(value), 64)
+	return int64(n)
+}
+
+func toFloat64(value interface{}) float64 {
+	if v, ok := value.(float64); ok {
+		return v
+	}
+	n, _ := strconv.ParseFloat(toString(value), 64)
+	return n
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// bucket returns the bucket of buckets named key
+func bucket(buckets []StatsBucket, key string) StatsBucket {
+	for _, b := range buckets {
+		if b.Key == key {
+			return b
+		}
+	}
+	return StatsBucket{Key: key}
+}
+
+// TestStats checks the totals and buckets of the fleet account for newly
+// registered probes
+func TestStats(t *testing.T) {
+	before, err := GetStats(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lisbon := testProbe("stats-lisbon.example.com", "192.0.2.131", "AWS")
+	lisbon.Country = "PT"
+	lisbon.Region = "eu-west-1"
+	lisbon.MonthlyCost = 5
+	first := registerTestProbe(t, lisbon)
+	defer Delete(first.ProbeID)
+	porto := testProbe("stats-porto.example.com", "192.0.2.132", "AWS")
+	porto.Country = "PT"
+	porto.MonthlyCost = 7
+	porto.GeoLatitude = ""
+	porto.GeoLongitude = ""
+	second := registerTestProbe(t, porto)
+	defer Delete(second.ProbeID)
+	if _, err := Disable(second.ProbeID); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := GetStats(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Total-before.Total != 2 || after.Enabled-before.Enabled != 1 || after.Disabled-before.Disabled != 1 {
+		t.Errorf("unexpected totals before %+v after %+v", before, after)
+	}
+	if after.MonthlyCost-before.MonthlyCost != 12 || after.MissingGeo-before.MissingGeo != 1 || after.MissingSSHKeys-before.MissingSSHKeys != 2 {
+		t.Errorf("unexpected cost, geo or keys before %+v after %+v", before, after)
+	}
+	country := bucket(after.ByCountry, "PT")
+	if country.Total-bucket(before.ByCountry, "PT").Total != 2 || country.Enabled != 1 || country.Disabled != 1 || country.MonthlyCost != 12 {
+		t.Errorf("unexpected country bucket %+v", country)
+	}
+	if region := bucket(after.ByRegion, "eu-west-1"); region.Total-bucket(before.ByRegion, "eu-west-1").Total != 1 {
+		t.Errorf("unexpected region bucket %+v", region)
+	}
+	month := time.Now().Format("2006-01")
+	if bucket(after.ByMonth, month).Total-bucket(before.ByMonth, month).Total != 2 {
+		t.Errorf("probes not counted in the month they were registered, %s: %+v", month, after.ByMonth)
+	}
+}
+
+// TestStatsAcrossChunks checks the figures of a scope listing more probes
+// than a single query takes add up across the queries
+func TestStatsAcrossChunks(t *testing.T) {
+	count := idsPerQuery + 10
+	probes := make([]Probe, count)
+	for i := range probes {
+		probes[i] = Probe{
+			ProbeID:     fmt.Sprintf("bulk-stats-%d", i),
+			FQDN:        fmt.Sprintf("bulk-stats-%d.example.com", i),
+			Provider:    "AWS",
+			Country:     "ES",
+			MonthlyCost: 2,
+			Labels:      Labels{"fleet": "bulk"},
+			Enabled:     i%2 == 0,
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+		}
+		if i%5 == 0 {
+			probes[i].Provider = "Vultr"
+		}
+	}
+	if _, err := o.InsertMulti(100, probes); err != nil {
+		t.Fatal(err)
+	}
+	defer o.QueryTable("probe").Filter("probe_i_d__startswith", "bulk-stats-").Delete()
+
+	selector, err := ParseLabelSelector("fleet=bulk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := GetStats(&Scope{Selector: selector})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != int64(count) || stats.Enabled != int64(count/2) || stats.Disabled != int64(count/2) {
+		t.Errorf("unexpected totals %+v", stats)
+	}
+	if stats.MonthlyCost != float64(2*count) || stats.MissingGeo != int64(count) {
+		t.Errorf("unexpected cost or geo %+v", stats)
+	}
+	if len(stats.ByProvider) != 2 || stats.ByProvider[0].Key != "AWS" || stats.ByProvider[1].Key != "Vultr" {
+		t.Fatalf("unexpected providers %+v", stats.ByProvider)
+	}
+	if stats.ByProvider[0].Total != int64(count-count/5) || stats.ByProvider[1].Total != int64(count/5) {
+		t.Errorf("provider buckets not merged across queries %+v", stats.ByProvider)
+	}
+	if len(stats.ByCountry) != 1 || stats.ByCountry[0].Total != int64(count) || stats.ByCountry[0].MonthlyCost != float64(2*count) {
+		t.Errorf("country buckets not merged across queries %+v", stats.ByCountry)
+	}
+}
@@ -0,0 +1,384 @@
+package models
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego/orm"
+)
+
+// Webhook is a subscription to probe lifecycle events, every event is
+// POSTed to URL signed with Secret. An empty Events list subscribes to all.
+type Webhook struct {
+	ID        int64     `orm:"pk;auto;column(id)" json:"id"`
+	URL       string    `orm:"size(512);column(url)" json:"url"`
+	Secret    string    `orm:"size(128)" json:"secret,omitempty"`
+	Events    []string  `orm:"-" json:"events"`
+	EventList string    `orm:"size(512)" json:"-"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery is the delivery of an event to a webhook along with the
+// outcome of its last attempt
+type WebhookDelivery struct {
+	ID            int64     `orm:"pk;auto;column(id)" json:"id"`
+	Webhook       int64     `orm:"index" json:"webhook"`
+	Event         string    `orm:"size(64)" json:"event"`
+	ProbeID       string    `orm:"size(100);column(probe_id)" json:"ProbeID"`
+	Payload       string    `orm:"type(text)" json:"payload"`
+	Attempts      int       `json:"attempts"`
+	StatusCode    int       `json:"status_code"`
+	Error         string    `orm:"size(512)" json:"error"`
+	Delivered     bool      `json:"delivered"`
+	RedeliveryOf  int64     `json:"redelivery_of"`
+	CreatedAt     time.Time `json:"created_at"`
+	LastAttemptAt time.Time `orm:"null" json:"last_attempt_at"`
+}
+
+// webhookPayload is the body POSTed to webhooks, the probe ssh private key
+// is never sent
+type webhookPayload struct {
+	Event     string    `json:"event"`
+	Detail    string    `json:"detail,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Probe     Probe     `json:"probe"`
+}
+
+var webhookEvents = []string{
+	EventRegistered, EventEnabled, EventDisabled, EventKeyStaged, EventRekeyed,
+	EventHostKeyUpdated, EventHostKeyChanged, EventHostKeyMismatch,
+	EventTracesPathUpdated, EventLabelsUpdated, EventDeleted,
+}
+
+var (
+	webhookMaxAttempts = 6
+	webhookBackoff     = 2 * time.Second
+	webhookClient      = &http.Client{Timeout: 10 * time.Second}
+)
+
+// ConfigureWebhooks starts delivering probe events to the webhooks, each
+// delivery is attempted up to maxAttempts times waiting backoff after the
+// first failure and doubling the wait after every other one. Deliveries
+// interrupted by a restart are resumed.
+func ConfigureWebhooks(maxAttempts int, backoff time.Duration, timeout time.Duration) {
+	if maxAttempts > 0 {
+		webhookMaxAttempts = maxAttempts
+	}
+	if backoff > 0 {
+		webhookBackoff = backoff
+	}
+	if timeout > 0 {
+		webhookClient = &http.Client{Timeout: timeout}
+	}
+	OnProbeEvent(enqueueWebhooks)
+	resumeDeliveries()
+}
+
+func (webhook *Webhook) pack() {
+	webhook.EventList = strings.Join(webhook.Events, ",")
+}
+
+func (webhook *Webhook) unpack() {
+	webhook.Events = []string{}
+	if webhook.EventList != "" {
+		webhook.Events = strings.Split(webhook.EventList, ",")
+	}
+}
+
+// SetDefaults makes a webhook being created active unless the request says
+// otherwise
+func (webhook *Webhook) SetDefaults() {
+	webhook.Active = true
+}
+
+// Redacted returns a copy of the webhook without its secret
+func (webhook Webhook) Redacted() *Webhook {
+	webhook.Secret = ""
+	return &webhook
+}
+
+// Subscribed reports whether the webhook wants events of eventType
+func (webhook *Webhook) Subscribed(eventType string) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, event := range webhook.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateWebhook(webhook Webhook) error {
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url `%s`, expected an http or https url", webhook.URL)
+	}
+	if len(webhook.URL) > 512 {
+		return fmt.Errorf("webhook url too long %s", webhook.URL)
+	}
+	if len(webhook.Secret) > 128 {
+		return errors.New("webhook secret too long, 128 characters at most")
+	}
+	for _, event := range webhook.Events {
+		known := false
+		for _, e := range webhookEvents {
+			known = known || e == event
+		}
+		if !known {
+			return fmt.Errorf("unknown event `%s`, expected one of %s", event, strings.Join(webhookEvents, ", "))
+		}
+	}
+	return nil
+}
+
+func generateSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// AddWebhook registers a webhook, a random secret is generated when none is
+// given. The returned webhook is the only place the secret is shown.
+func AddWebhook(webhook Webhook) (*Webhook, error) {
+	log.Infof("[models.webhook.AddWebhook]: new webhook %s", webhook.URL)
+	if err := ValidateWebhook(webhook); err != nil {
+		return nil, err
+	}
+	if webhook.Secret == "" {
+		secret, err := generateSecret()
+		if err != nil {
+			return nil, err
+		}
+		webhook.Secret = secret
+	}
+	webhook.ID = 0
+	webhook.CreatedAt = time.Now()
+	webhook.UpdatedAt = time.Now()
+	webhook.pack()
+	if _, err := o.Insert(&webhook); err != nil {
+		return nil, err
+	}
+	webhook.unpack()
+	return &webhook, nil
+}
+
+func GetWebhook(id int64) (*Webhook, error) {
+	webhook := Webhook{ID: id}
+	err := o.Read(&webhook)
+	if err == orm.ErrNoRows || err == orm.ErrMissPK {
+		log.Warningf("[models.webhook.GetWebhook]: No result found for id %d", id)
+		return nil, errors.New("Webhook not found")
+	} else if err != nil {
+		return nil, err
+	}
+	webhook.unpack()
+	return &webhook, nil
+}
+
+func GetAllWebhooks() ([]*Webhook, error) {
+	var webhooks []*Webhook
+	if _, err := o.QueryTable("webhook").OrderBy("id").All(&webhooks); err != nil {
+		return nil, err
+	}
+	for _, webhook := range webhooks {
+		webhook.unpack()
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook replaces url, events and active flag of a webhook, and its
+// secret when one is given
+func UpdateWebhook(id int64, update Webhook) (*Webhook, error) {
+	log.Infof("[models.webhook.UpdateWebhook]: updating webhook %d", id)
+	webhook, err := GetWebhook(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateWebhook(update); err != nil {
+		return nil, err
+	}
+	webhook.URL = update.URL
+	webhook.Events = update.Events
+	webhook.Active = update.Active
+	if update.Secret != "" {
+		webhook.Secret = update.Secret
+	}
+	webhook.UpdatedAt = time.Now()
+	webhook.pack()
+	if _, err := o.Update(webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// DeleteWebhook removes a webhook along with its delivery log
+func DeleteWebhook(id int64) error {
+	log.Infof("[models.webhook.DeleteWebhook]: removing webhook %d", id)
+	webhook, err := GetWebhook(id)
+	if err != nil {
+		return err
+	}
+	if _, err := o.QueryTable("webhook_delivery").Filter("webhook", id).Delete(); err != nil {
+		return err
+	}
+	_, err = o.Delete(webhook)
+	return err
+}
+
+// GetDeliveries returns the latest deliveries of a webhook, newest first
+func GetDeliveries(webhookID int64, limit int) ([]*WebhookDelivery, error) {
+	if _, err := GetWebhook(webhookID); err != nil {
+		return nil, err
+	}
+	var deliveries []*WebhookDelivery
+	_, err := o.QueryTable("webhook_delivery").Filter("webhook", webhookID).OrderBy("-id").Limit(limit).All(&deliveries)
+	return deliveries, err
+}
+
+// Redeliver sends the payload of a past delivery again as a new delivery
+func Redeliver(webhookID int64, deliveryID int64) (*WebhookDelivery, error) {
+	webhook, err := GetWebhook(webhookID)
+	if err != nil {
+		return nil, err
+	}
+	past := WebhookDelivery{ID: deliveryID}
+	if err := o.Read(&past); err != nil || past.Webhook != webhookID {
+		return nil, errors.New("Delivery not found")
+	}
+	delivery := &WebhookDelivery{
+		Webhook:      webhookID,
+		Event:        past.Event,
+		ProbeID:      past.ProbeID,
+		Payload:      past.Payload,
+		RedeliveryOf: past.ID,
+		CreatedAt:    time.Now(),
+	}
+	if _, err := o.Insert(delivery); err != nil {
+		return nil, err
+	}
+	queued := *delivery
+	go deliver(webhook, delivery)
+	return &queued, nil
+}
+
+func enqueueWebhooks(event ProbeEvent) {
+	webhooks, err := GetAllWebhooks()
+	if err != nil {
+		log.Errorf("[models.webhook.enqueueWebhooks]: Error querying database %s", err)
+		return
+	}
+	probe := event.Probe
+	probe.SSHPrivateKey = ""
+	payload, err := json.Marshal(webhookPayload{Event: event.Type, Detail: event.Detail, Timestamp: time.Now(), Probe: probe})
+	if err != nil {
+		log.Errorf("[models.webhook.enqueueWebhooks]: unable to encode %s event: %s", event.Type, err)
+		return
+	}
+	for _, webhook := range webhooks {
+		if !webhook.Active || !webhook.Subscribed(event.Type) {
+			continue
+		}
+		delivery := &WebhookDelivery{
+			Webhook:   webhook.ID,
+			Event:     event.Type,
+			ProbeID:   probe.ProbeID,
+			Payload:   string(payload),
+			CreatedAt: time.Now(),
+		}
+		if _, err := o.Insert(delivery); err != nil {
+			log.Errorf("[models.webhook.enqueueWebhooks]: unable to log delivery to webhook %d: %s", webhook.ID, err)
+			continue
+		}
+		go deliver(webhook, delivery)
+	}
+}
+
+// resumeDeliveries retries the deliveries that had attempts left when the
+// registry stopped
+func resumeDeliveries() {
+	var deliveries []*WebhookDelivery
+	_, err := o.QueryTable("webhook_delivery").Filter("delivered", false).Filter("attempts__lt", webhookMaxAttempts).All(&deliveries)
+	if err != nil {
+		log.Errorf("[models.webhook.resumeDeliveries]: Error querying database %s", err)
+		return
+	}
+	for _, delivery := range deliveries {
+		webhook, err := GetWebhook(delivery.Webhook)
+		if err != nil || !webhook.Active {
+			continue
+		}
+		go deliver(webhook, delivery)
+	}
+}
+
+// Signature returns the value of the X-Sinker-Signature header of payload
+func Signature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func deliver(webhook *Webhook, delivery *WebhookDelivery) {
+	for delivery.Attempts < webhookMaxAttempts {
+		if delivery.Attempts > 0 {
+			time.Sleep(webhookBackoff << uint(delivery.Attempts-1))
+		}
+		delivery.Attempts++
+		delivery.LastAttemptAt = time.Now()
+		delivery.StatusCode, delivery.Error = post(webhook, delivery)
+		delivery.Delivered = delivery.Error == ""
+		if _, err := o.Update(delivery); err != nil {
+			log.Errorf("[models.webhook.deliver]: unable to log delivery %d: %s", delivery.ID, err)
+		}
+		if delivery.Delivered {
+			return
+		}
+		log.Warningf("[models.webhook.deliver]: attempt %d of delivery %d to %s failed: %s", delivery.Attempts, delivery.ID, webhook.URL, delivery.Error)
+	}
+}
+
+func post(webhook *Webhook, delivery *WebhookDelivery) (int, string) {
+	payload := []byte(delivery.Payload)
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sinker_registry_api")
+	req.Header.Set("X-Sinker-Event", delivery.Event)
+	req.Header.Set("X-Sinker-Delivery", fmt.Sprintf("%d", delivery.ID))
+	req.Header.Set("X-Sinker-Signature", Signature(webhook.Secret, payload))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, truncate(err.Error(), 512)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Sprintf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, ""
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -16,6 +16,77 @@ func init() {
 				&controllers.ProbeController{},
 			),
 		),
+		beego.NSNamespace("/provider",
+			beego.NSInclude(
+				&controllers.ProviderController{},
+			),
+		),
+		beego.NSNamespace("/stats",
+			beego.NSInclude(
+				&controllers.StatsController{},
+			),
+		),
+		beego.NSNamespace("/inventory",
+			beego.NSInclude(
+				&controllers.InventoryController{},
+			),
+		),
+		beego.NSNamespace("/sd",
+			beego.NSInclude(
+				&controllers.ServiceDiscoveryController{},
+			),
+		),
+		beego.NSNamespace("/export",
+			beego.NSInclude(
+				&controllers.ExportController{},
+			),
+		),
+		beego.NSNamespace("/dns",
+			beego.NSInclude(
+				&controllers.DNSController{},
+			),
+		),
+		beego.NSNamespace("/webhooks",
+			beego.NSInclude(
+				&controllers.WebhookController{},
+			),
+		),
+		beego.NSNamespace("/events",
+			beego.NSInclude(
+				&controllers.EventsController{},
+			),
+		),
+		beego.NSNamespace("/admin",
+			beego.NSInclude(
+				&controllers.AdminController{},
+			),
+		),
+		beego.NSNamespace("/ssh",
+			beego.NSInclude(
+				&controllers.SSHController{},
+			),
+		),
+		beego.NSNamespace("/auth",
+			beego.NSInclude(
+				&controllers.OIDCController{},
+			),
+		),
+		beego.NSNamespace("/pki",
+			beego.NSInclude(
+				&controllers.PKIController{},
+			),
+		),
+		beego.NSNamespace("/enroll",
+			beego.NSInclude(
+				&controllers.EnrollController{},
+			),
+		),
+		beego.NSNamespace("/groups",
+			beego.NSInclude(
+				&controllers.GroupController{},
+			),
+		),
 	)
 	beego.AddNamespace(ns)
+	beego.InsertFilter("/v1/*", beego.BeforeRouter, controllers.RateLimit)
 }
@@ -1,11 +1,16 @@
 package test
 
 import (
+	"bitbucket.org/fseros/sinker_registry_api/models"
 	_ "bitbucket.org/fseros/sinker_registry_api/routers"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/astaxie/beego"
@@ -13,9 +18,53 @@ import (
 )
 
 func init() {
-	_, file, _, _ := runtime.Caller(1)
+	_, file, _, _ := runtime.Caller(0)
 	apppath, _ := filepath.Abs(filepath.Dir(filepath.Join(file, ".."+string(filepath.Separator))))
 	beego.TestBeegoInit(apppath)
+	dir, err := ioutil.TempDir("", "sinker_registry_api")
+	if err != nil {
+		panic(err)
+	}
+	models.DataSource = filepath.Join(dir, "data.db")
+	models.Init()
+}
+
+// request builds a request carrying body, if any
+func request(method string, path string, body string) *http.Request {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r, _ := http.NewRequest(method, path, reader)
+	return r
+}
+
+// call serves an anonymous request
+func call(method string, path string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	beego.BeeApp.Handlers.ServeHTTP(w, request(method, path, body))
+	return w
+}
+
+// addProbe registers a probe and returns its ID
+func addProbe(t *testing.T, fqdn string, ipv4 string, provider string, privateKey string) string {
+	var probe models.Probe
+	probe.SetDefaults()
+	probe.FQDN = fqdn
+	probe.Ipv4 = ipv4
+	probe.Provider = provider
+	probe.Enabled = true
+	probe.GeoLatitude = "40.4"
+	probe.GeoLongitude = "-3.7"
+	if privateKey != "" {
+		probe.SSHPrivateKey = base64.URLEncoding.EncodeToString([]byte(privateKey))
+		probe.SSHPublicKey = base64.URLEncoding.EncodeToString([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOmwG1Fq4fnq8NbSEfbAxBWtyBg5QGn5mwSbkIsnlq0A " + fqdn))
+	}
+	ProbeID, err := models.AddOne(probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ProbeID
 }
 
 // TestGet is a sample to run an endpoint test
@@ -0,0 +1,70 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+)
+
+// TestGroupScope checks a scoped operator only sees the members of a static
+// group within the scope of its team, and changes no group holding probes
+// outside it
+func TestGroupScope(t *testing.T) {
+	inScope := addProbe(t, "grouped-aws.example.com", "192.0.2.60", "AWS", "")
+	defer models.Delete(inScope)
+	outOfScope := addProbe(t, "grouped-vultr.example.com", "192.0.2.61", "Vultr", "")
+	defer models.Delete(outOfScope)
+	if _, err := models.AddGroup(models.ProbeGroup{Name: "mixed", Members: []string{inScope, outOfScope}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer models.DeleteGroup("mixed")
+	if _, err := models.AddGroup(models.ProbeGroup{Name: "aws-only", Members: []string{inScope}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer models.DeleteGroup("aws-only")
+	team, err := models.AddTeam(models.Team{Name: "aws-groupers", Providers: []string{"AWS"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := addUser(t, "aws-grouper", models.RoleOperator, team.ID)
+
+	withAuth(t, func() {
+		w := callAs("GET", "/v1/groups/mixed", token, "")
+		if w.Code != 200 {
+			t.Fatalf("group refused: %d %s", w.Code, w.Body.String())
+		}
+		var group models.ProbeGroup
+		json.Unmarshal(w.Body.Bytes(), &group)
+		if len(group.Members) != 1 || group.Members[0] != inScope {
+			t.Errorf("members outside the scope served: %v", group.Members)
+		}
+		w = callAs("GET", "/v1/groups/", token, "")
+		var groups []*models.ProbeGroup
+		json.Unmarshal(w.Body.Bytes(), &groups)
+		for _, group := range groups {
+			for _, member := range group.Members {
+				if member == outOfScope {
+					t.Errorf("member outside the scope served in group %s", group.Name)
+				}
+			}
+		}
+
+		update := `{"description": "changed"}`
+		if w := callAs("PUT", "/v1/groups/mixed", token, update); w.Code != 403 {
+			t.Errorf("group holding probes outside the scope updated: %d %s", w.Code, w.Body.String())
+		}
+		if w := callAs("DELETE", "/v1/groups/mixed/members?ids="+inScope, token, ""); w.Code != 403 {
+			t.Errorf("members removed from a group holding probes outside the scope: %d %s", w.Code, w.Body.String())
+		}
+		if w := callAs("DELETE", "/v1/groups/mixed", token, ""); w.Code != 403 {
+			t.Errorf("group holding probes outside the scope deleted: %d %s", w.Code, w.Body.String())
+		}
+		if w := callAs("PUT", "/v1/groups/aws-only", token, update); w.Code != 200 {
+			t.Errorf("group within the scope not updated: %d %s", w.Code, w.Body.String())
+		}
+	})
+	if group, err := models.GetGroup("mixed", nil); err != nil || len(group.Members) != 2 || group.Description != "" {
+		t.Errorf("group holding probes outside the scope changed: %+v %v", group, err)
+	}
+}
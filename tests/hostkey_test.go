@@ -0,0 +1,54 @@
+package test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+)
+
+const testHostKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOmwG1Fq4fnq8NbSEfbAxBWtyBg5QGn5mwSbkIsnlq0A"
+
+// TestHostKeyAuditTrail checks a changed host key is kept in the audit
+// trail of the probe, and that the single host key route is the plural one
+func TestHostKeyAuditTrail(t *testing.T) {
+	ProbeID := addProbe(t, "audited.example.com", "192.0.2.40", "AWS", "")
+	defer models.Delete(ProbeID)
+	replacement := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOoLW3tWqbCabdbgPfT6PyjSlIcK1mcPmhG/VfwPXs6Z"
+
+	for _, key := range []string{testHostKey, replacement} {
+		if w := call("PUT", "/v1/probe/"+ProbeID+"/hostkeys", `{"hostkeys": ["`+key+`"]}`); w.Code != 200 {
+			t.Fatalf("host key %s refused: %d %s", key, w.Code, w.Body.String())
+		}
+	}
+	if w := call("PUT", "/v1/probe/hostkey/"+ProbeID, `{"sshhostkey": "`+testHostKey+`"}`); w.Code == 200 {
+		t.Errorf("the former host key route still pins keys: %s", w.Body.String())
+	}
+
+	changes, err := models.GetHostKeyChanges(ProbeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changed []string
+	for _, change := range changes {
+		if change.Event == models.EventHostKeyChanged {
+			changed = append(changed, change.Detail)
+		}
+	}
+	if len(changed) != 1 || !strings.Contains(changed[0], "changed from SHA256:") {
+		t.Errorf("host key change not audited: %v", changed)
+	}
+
+	w := call("GET", "/v1/probe/"+ProbeID+"/hostkeys/changes", "")
+	if w.Code != 200 {
+		t.Fatalf("audit trail refused: %d %s", w.Code, w.Body.String())
+	}
+	var served []*models.ProbeChange
+	if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil {
+		t.Fatal(err)
+	}
+	if len(served) != len(changes) || len(served) != 3 {
+		t.Errorf("audit trail of %d changes served as %d, expected 3", len(changes), len(served))
+	}
+}
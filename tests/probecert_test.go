@@ -0,0 +1,112 @@
+package test
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+	"github.com/astaxie/beego"
+)
+
+// TestProbeCertificateOwnRecord checks a probe authenticated by its client
+// certificate only changes its own record, whatever the id query parameter
+// says
+func TestProbeCertificateOwnRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probeca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := models.ConfigureProbeCA(dir, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	own := addProbe(t, "certified.example.com", "192.0.2.30", "AWS", "")
+	defer models.Delete(own)
+	other := addProbe(t, "other.example.com", "192.0.2.31", "AWS", "")
+	defer models.Delete(other)
+	credentials, err := models.IssueProbeCertificate(own)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair([]byte(credentials.Certificate), []byte(credentials.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(beego.BeeApp.Handlers)
+	server.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: models.ProbeCAPool()}
+	server.StartTLS()
+	defer server.Close()
+	client := server.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{cert}
+	put := func(path string) int {
+		body := `{"hostkeys": ["` + testHostKey + ` probe"]}`
+		r, _ := http.NewRequest("PUT", server.URL+path, strings.NewReader(body))
+		w, err := client.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Body.Close()
+		return w.StatusCode
+	}
+
+	withAuth(t, func() {
+		if code := put("/v1/probe/" + other + "/hostkeys?id=" + own); code != 400 {
+			t.Errorf("probe changed another one naming itself in the query: %d", code)
+		}
+		if code := put("/v1/probe/" + other + "/hostkeys"); code != 403 {
+			t.Errorf("probe changed another one: %d", code)
+		}
+		if code := put("/v1/probe/" + own + "/hostkeys?id=" + own); code != 200 {
+			t.Errorf("probe refused its own host keys: %d", code)
+		}
+	})
+	if keys, _ := models.GetHostKeys(other); len(keys) != 0 {
+		t.Errorf("host keys of probe %s changed: %v", other, keys)
+	}
+	if keys, _ := models.GetHostKeys(own); len(keys) != 1 {
+		t.Errorf("host keys of probe %s not pinned: %v", own, keys)
+	}
+}
+
+// TestProbeCertificateSupersedes checks a new certificate revokes the
+// former ones of the probe, and only them
+func TestProbeCertificateSupersedes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probeca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := models.ConfigureProbeCA(dir, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	ProbeID := addProbe(t, "reissued.example.com", "192.0.2.32", "AWS", "")
+	defer models.Delete(ProbeID)
+	first, err := models.IssueProbeCertificate(ProbeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := models.IssueProbeCertificate(ProbeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := models.GetProbeCertificates(ProbeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || certs[0].Serial != second.Record.Serial || certs[1].Serial != first.Record.Serial {
+		t.Fatalf("unexpected certificates %+v", certs)
+	}
+	if certs[0].Revoked {
+		t.Error("new certificate revoked")
+	}
+	if !certs[1].Revoked || certs[1].RevokeReason != models.RevokedSuperseded {
+		t.Errorf("former certificate not superseded: %+v", certs[1])
+	}
+}
@@ -0,0 +1,58 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+	"github.com/astaxie/beego"
+)
+
+// TestSecretRoutesRateLimited checks the routes handing out secrets are
+// charged to the secret budget, and the probe reads are not
+func TestSecretRoutesRateLimited(t *testing.T) {
+	limits := map[string]models.RateLimit{
+		models.RateRead:   {PerMinute: 1000, Burst: 1000},
+		models.RateWrite:  {PerMinute: 1000, Burst: 1000},
+		models.RateSecret: {PerMinute: 1, Burst: 1},
+	}
+	if err := models.ConfigureRateLimits(limits, models.RateStoreMemory); err != nil {
+		t.Fatal(err)
+	}
+	defer models.ConfigureRateLimits(nil, models.RateStoreMemory)
+	ProbeID := addProbe(t, "limited.example.com", "192.0.2.70", "AWS", "rate limited key")
+	defer models.Delete(ProbeID)
+
+	routes := []struct {
+		method string
+		path   string
+		secret bool
+	}{
+		{"GET", "/v1/probe/ssh/" + ProbeID, true},
+		{"GET", "/v1/auth/callback", true},
+		{"POST", "/v1/admin/users", true},
+		{"POST", "/v1/admin/users/1/token", true},
+		{"POST", "/v1/webhooks/", true},
+		{"POST", "/v1/admin/enrollments", true},
+		{"POST", "/v1/enroll/", true},
+		{"GET", "/v1/probe/", false},
+		{"GET", "/v1/probe/" + ProbeID, false},
+		{"GET", "/v1/probe/" + ProbeID + "/ssh/rotation", false},
+	}
+	for i, route := range routes {
+		var code int
+		for call := 0; call < 2; call++ {
+			r, _ := http.NewRequest(route.method, route.path, strings.NewReader("{}"))
+			r.RemoteAddr = fmt.Sprintf("198.51.100.%d:1234", i+1)
+			w := httptest.NewRecorder()
+			beego.BeeApp.Handlers.ServeHTTP(w, r)
+			code = w.Code
+		}
+		if limited := code == 429; limited != route.secret {
+			t.Errorf("%s %s: second call got %d, secret %t", route.method, route.path, code, route.secret)
+		}
+	}
+}
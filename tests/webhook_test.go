@@ -0,0 +1,240 @@
+package test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bitbucket.org/fseros/sinker_registry_api/models"
+)
+
+const (
+	testWebhookBackoff  = 50 * time.Millisecond
+	testWebhookAttempts = 3
+)
+
+var configureWebhooks sync.Once
+
+// receivedHook is a request the test receiver got
+type receivedHook struct {
+	At        time.Time
+	Path      string
+	Event     string
+	Delivery  string
+	Signature string
+	Body      []byte
+}
+
+// hookReceiver records the requests it gets, failing the first ones of
+// every path as told by fail
+type hookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	received []receivedHook
+	fail     map[string]int
+}
+
+func newHookReceiver(fail map[string]int) *hookReceiver {
+	receiver := &hookReceiver{fail: fail}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		receiver.received = append(receiver.received, receivedHook{
+			At:        time.Now(),
+			Path:      r.URL.Path,
+			Event:     r.Header.Get("X-Sinker-Event"),
+			Delivery:  r.Header.Get("X-Sinker-Delivery"),
+			Signature: r.Header.Get("X-Sinker-Signature"),
+			Body:      body,
+		})
+		if receiver.fail[r.URL.Path] != 0 {
+			receiver.fail[r.URL.Path]--
+			w.WriteHeader(500)
+		}
+	}))
+	return receiver
+}
+
+// wait returns the requests received on path once there are count of them
+func (receiver *hookReceiver) wait(t *testing.T, path string, count int) []receivedHook {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var received []receivedHook
+		receiver.mu.Lock()
+		for _, hook := range receiver.received {
+			if hook.Path == path {
+				received = append(received, hook)
+			}
+		}
+		receiver.mu.Unlock()
+		if len(received) >= count {
+			return received
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests received on %s, expected %d", len(received), path, count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// addWebhook subscribes path of the receiver to events of type event
+func addWebhook(t *testing.T, receiver *hookReceiver, path string, secret string, event string) int64 {
+	configureWebhooks.Do(func() {
+		models.ConfigureWebhooks(testWebhookAttempts, testWebhookBackoff, time.Second)
+	})
+	body := fmt.Sprintf(`{"url": "%s%s", "secret": "%s", "events": ["%s"]}`, receiver.URL, path, secret, event)
+	w := call("POST", "/v1/webhooks/", body)
+	if w.Code != 201 {
+		t.Fatalf("webhook refused: %d %s", w.Code, w.Body.String())
+	}
+	var webhook models.Webhook
+	if err := json.Unmarshal(w.Body.Bytes(), &webhook); err != nil {
+		t.Fatal(err)
+	}
+	return webhook.ID
+}
+
+// deliveries returns the delivery log of a webhook, newest first
+func deliveries(t *testing.T, webhookID int64) []*models.WebhookDelivery {
+	w := call("GET", fmt.Sprintf("/v1/webhooks/%d/deliveries", webhookID), "")
+	var log []*models.WebhookDelivery
+	if err := json.Unmarshal(w.Body.Bytes(), &log); err != nil {
+		t.Fatalf("delivery log of webhook %d: %d %s", webhookID, w.Code, w.Body.String())
+	}
+	return log
+}
+
+// TestWebhookActiveByDefault checks webhooks are created active unless the
+// request says otherwise
+func TestWebhookActiveByDefault(t *testing.T) {
+	cases := map[string]bool{
+		`{"url": "https://hooks.example.com/default"}`:                 true,
+		`{"url": "https://hooks.example.com/active", "active": true}`:  true,
+		`{"url": "https://hooks.example.com/paused", "active": false}`: false,
+	}
+	for body, active := range cases {
+		w := call("POST", "/v1/webhooks/", body)
+		if w.Code != 201 {
+			t.Errorf("%s refused: %d %s", body, w.Code, w.Body.String())
+			continue
+		}
+		var webhook models.Webhook
+		if err := json.Unmarshal(w.Body.Bytes(), &webhook); err != nil {
+			t.Fatal(err)
+		}
+		defer models.DeleteWebhook(webhook.ID)
+		if stored, err := models.GetWebhook(webhook.ID); err != nil || stored.Active != active {
+			t.Errorf("%s: active %t, expected %t: %v", body, stored.Active, active, err)
+		}
+	}
+}
+
+// TestWebhookKeepsActive checks an update leaving the active flag out keeps
+// it as it was
+func TestWebhookKeepsActive(t *testing.T) {
+	w := call("POST", "/v1/webhooks/", `{"url": "https://hooks.example.com/kept"}`)
+	var webhook models.Webhook
+	if err := json.Unmarshal(w.Body.Bytes(), &webhook); err != nil {
+		t.Fatal(err)
+	}
+	defer models.DeleteWebhook(webhook.ID)
+	path := fmt.Sprintf("/v1/webhooks/%d", webhook.ID)
+	for _, update := range []struct {
+		body   string
+		active bool
+	}{
+		{`{"url": "https://hooks.example.com/moved"}`, true},
+		{`{"url": "https://hooks.example.com/moved", "active": false}`, false},
+		{`{"url": "https://hooks.example.com/paused"}`, false},
+		{`{"url": "https://hooks.example.com/paused", "active": true}`, true},
+	} {
+		if w := call("PUT", path, update.body); w.Code != 200 {
+			t.Fatalf("%s refused: %d %s", update.body, w.Code, w.Body.String())
+		}
+		if stored, _ := models.GetWebhook(webhook.ID); stored.Active != update.active {
+			t.Errorf("%s: active %t, expected %t", update.body, stored.Active, update.active)
+		}
+	}
+	if w := call("PUT", "/v1/webhooks/0", `{"url": "https://hooks.example.com/none"}`); w.Code != 404 {
+		t.Errorf("missing webhook updated: %d", w.Code)
+	}
+}
+
+// TestWebhookDelivery checks deliveries are signed with the secret of the
+// webhook, retried with a doubling wait until they succeed or run out of
+// attempts, and sent again on request
+func TestWebhookDelivery(t *testing.T) {
+	receiver := newHookReceiver(map[string]int{"/flaky": testWebhookAttempts - 1, "/down": testWebhookAttempts})
+	defer receiver.Close()
+	flaky := addWebhook(t, receiver, "/flaky", "flaky-secret", models.EventRegistered)
+	defer models.DeleteWebhook(flaky)
+	down := addWebhook(t, receiver, "/down", "down-secret", models.EventRegistered)
+	defer models.DeleteWebhook(down)
+
+	ProbeID := addProbe(t, "hooked.example.com", "192.0.2.110", "AWS", "")
+	defer models.Delete(ProbeID)
+
+	received := receiver.wait(t, "/flaky", testWebhookAttempts)
+	for i, hook := range received {
+		mac := hmac.New(sha256.New, []byte("flaky-secret"))
+		mac.Write(hook.Body)
+		if hook.Signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("attempt %d signed %s", i+1, hook.Signature)
+		}
+		if hook.Event != models.EventRegistered || !strings.Contains(string(hook.Body), ProbeID) {
+			t.Errorf("attempt %d delivered %s %s", i+1, hook.Event, hook.Body)
+		}
+	}
+	if first, second := received[1].At.Sub(received[0].At), received[2].At.Sub(received[1].At); first < testWebhookBackoff || second < 2*testWebhookBackoff {
+		t.Errorf("attempts %s and %s apart, expected %s and %s at least", first, second, testWebhookBackoff, 2*testWebhookBackoff)
+	}
+	receiver.wait(t, "/down", testWebhookAttempts)
+	time.Sleep(2 * testWebhookBackoff)
+	if hooks := receiver.wait(t, "/down", 0); len(hooks) != testWebhookAttempts {
+		t.Errorf("failing delivery attempted %d times, expected %d", len(hooks), testWebhookAttempts)
+	}
+
+	var logged *models.WebhookDelivery
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if log := deliveries(t, flaky); len(log) == 1 && log[0].Delivered {
+			logged = log[0]
+			break
+		}
+	}
+	if logged == nil {
+		t.Fatal("delivery never logged as delivered")
+	}
+	if !logged.Delivered || logged.Attempts != testWebhookAttempts || logged.StatusCode != 200 {
+		t.Errorf("unexpected delivery %+v", logged)
+	}
+	if log := deliveries(t, down); len(log) != 1 || log[0].Delivered || log[0].Attempts != testWebhookAttempts || log[0].StatusCode != 500 {
+		t.Errorf("unexpected failed delivery %+v", log)
+	}
+
+	w := call("POST", fmt.Sprintf("/v1/webhooks/%d/deliveries/%d/redeliver", flaky, logged.ID), "")
+	if w.Code != 202 {
+		t.Fatalf("redelivery refused: %d %s", w.Code, w.Body.String())
+	}
+	var redelivery models.WebhookDelivery
+	json.Unmarshal(w.Body.Bytes(), &redelivery)
+	if redelivery.RedeliveryOf != logged.ID || redelivery.ID == logged.ID {
+		t.Errorf("unexpected redelivery %+v", redelivery)
+	}
+	again := receiver.wait(t, "/flaky", testWebhookAttempts+1)[testWebhookAttempts]
+	if string(again.Body) != string(received[0].Body) || again.Delivery != fmt.Sprint(redelivery.ID) || again.Signature != received[0].Signature {
+		t.Errorf("redelivered %s %s as delivery %s", again.Body, again.Signature, again.Delivery)
+	}
+	if w := call("POST", fmt.Sprintf("/v1/webhooks/%d/deliveries/%d/redeliver", down, logged.ID), ""); w.Code != 404 {
+		t.Errorf("delivery of another webhook redelivered: %d", w.Code)
+	}
+}